@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/clone"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/restore"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go --from-org <org> --from-service-account <file> --to-org <org> --to-service-account <file> [--api-endpoint <url>] [--to-api-endpoint <url>] [--on-error fail-fast|continue] [--mapping <file> [--allow-unmapped]] [--include-*/--exclude-* ...] [--state <file>] [--resume] [--dry-run] [--format text|json]")
+	fmt.Println("\nDescription: Copia developers, apps e chaves de uma organizacao para outra, direto pela memoria.")
+	fmt.Println("A origem e lida pelo mesmo codigo do backup e o destino e gravado pelo restore all, sem arquivos")
+	fmt.Println("intermediarios: os consumer secrets nunca passam pelo disco.")
+	fmt.Println("\n- Options: --from-org / --from-service-account - Organizacao de origem e o service account com acesso de leitura a ela")
+	fmt.Println("- Options: --to-org / --to-service-account - Organizacao de destino e o service account com acesso de escrita a ela")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
+	fmt.Println("- Options: --to-api-endpoint - Endpoint do destino, quando diferente do da origem (ex: de hybrid para X)")
+	fmt.Println("- Options: --on-error - fail-fast (padrao) para no primeiro erro; continue segue com tudo que nao depende do que falhou")
+	fmt.Println("- Options: --mapping / --allow-unmapped - Renomeia API products, ambientes, emails e atributos, como no restore")
+	fmt.Println("- Options: --include-*/--exclude-* - Copia so os developers e apps que passam nos filtros, como no restore all (podem repetir)")
+	fmt.Println("- Options: --state - Arquivo onde o progresso e gravado a cada passo (padrao clone-<origem>-<destino>.json); e apagado no final sem erros")
+	fmt.Println("- Options: --resume - Retoma um clone interrompido a partir do --state, sem repetir os passos ja feitos")
+	fmt.Println("- Options: --dry-run - Mostra o plano sem alterar o destino")
+	fmt.Println("- Options: --format - Formato do plano e do relatorio: text (padrao) ou json")
+	fmt.Println("\nEx: go run main.go --from-org prod-org --from-service-account prod.json --to-org stg-org --to-service-account stg.json")
+}
+
+func main() {
+	endpoint := apigeeclient.EndpointFlag()
+	toEndpoint := flag.String("to-api-endpoint", "", "Endpoint da API do destino; padrao e o mesmo da origem")
+	fromOrg := flag.String("from-org", "", "Organizacao de origem")
+	fromSA := flag.String("from-service-account", "", "Service account da origem")
+	toOrg := flag.String("to-org", "", "Organizacao de destino")
+	toSA := flag.String("to-service-account", "", "Service account do destino")
+	policy := flag.String("on-error", restore.PolicyFailFast, "O que fazer quando um passo falha: fail-fast ou continue")
+	statePath := flag.String("state", "", "Arquivo de progresso do clone")
+	resume := flag.Bool("resume", false, "Retoma o clone a partir do --state")
+	dryRun := flag.Bool("dry-run", false, "Mostra o plano sem alterar o destino")
+	format := flag.String("format", restore.FormatText, "Formato do plano e do relatorio: text ou json")
+	mappingFile := mapping.Flags()
+	filters := filter.Flags()
+	flag.Usage = help
+	flag.Parse()
+
+	if *fromOrg == "" || *toOrg == "" || *fromSA == "" || *toSA == "" {
+		help()
+		os.Exit(2)
+	}
+	if *statePath == "" {
+		*statePath = fmt.Sprintf("clone-%s-%s.json", *fromOrg, *toOrg)
+	}
+	if *toEndpoint == "" {
+		*toEndpoint = *endpoint
+	}
+
+	ctx := context.Background()
+
+	m, err := mappingFile()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := filters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	source, err := apigeeclient.New(ctx, *fromSA, *endpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee da origem: %v", err)
+	}
+	target, err := apigeeclient.New(ctx, *toSA, *toEndpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee do destino: %v", err)
+	}
+
+	// O stdout fica so com o plano ou o relatorio.
+	backup.Output = os.Stderr
+	restore.Output = os.Stderr
+
+	opts := restore.Options{Policy: *policy, Mapping: m, Filter: f}
+
+	var report *restore.Report
+	if *resume {
+		report, err = clone.Resume(ctx, source, target, *fromOrg, *toOrg, *statePath, opts)
+	} else {
+		if _, statErr := os.Stat(*statePath); statErr == nil && !*dryRun {
+			log.Fatalf("Ja existe um clone interrompido em %s: use --resume para continuar ou apague o arquivo", *statePath)
+		}
+
+		plan, src, planErr := clone.Plan(ctx, source, target, *fromOrg, *toOrg, opts)
+		if planErr != nil {
+			log.Fatal(planErr)
+		}
+		for _, warning := range plan.Warnings {
+			log.Printf("Atencao: %s", warning)
+		}
+
+		if *dryRun {
+			if err := restore.WritePlan(os.Stdout, plan, *format); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
+		report, err = clone.Apply(ctx, target, *fromOrg, plan, src, *statePath, nil, opts)
+	}
+
+	if report != nil {
+		if err := restore.WriteReport(os.Stdout, report, *format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Printf("Progresso salvo em %s; corrija o problema e rode de novo com --resume", *statePath)
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--on-error fail-fast|continue] [--format text|json] [--dry-run] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] [--regenerate-secrets] [--mapping <file> [--allow-unmapped]] [--include-*/--exclude-* ...] [--journal <file>] <serviceAccountFile> <organization> <snapshot> [<snapshot>...]")
+	fmt.Println("       go run main.go [--api-endpoint <url>] [--on-error fail-fast|continue] [--format text|json] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] [--regenerate-secrets] [--journal <file>] --apply-plan <plan.json> <serviceAccountFile> <organization>")
+	fmt.Println("\nDescription: Restaura a organizacao inteira a partir dos snapshots, na ordem das dependencias:")
+	fmt.Println("API products, developers, apps, chaves, associacoes das chaves com os products e status.")
+	fmt.Println("O que ja existe na organizacao e pulado. No final mostra o resultado de cada recurso, por fase.")
+	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
+	fmt.Println("- Options: <organization> - Organizacao Apigee")
+	fmt.Println("- Options: <snapshot> - Snapshots do backup, normalmente o de developers e o de apps (diretorio ou .tar.gz/.tar.zst, local ou em gs:// / s3://)")
+	fmt.Println("- Options: --on-error - fail-fast (padrao) para no primeiro erro e desfaz o que ja foi feito; continue segue com tudo que nao depende do que falhou")
+	fmt.Println("- Options: --journal - Arquivo onde cada alteracao e registrada (padrao restore-<org>-<data e hora>.jsonl), para desfazer com o all/rollback")
+	fmt.Println("- Options: --format - Formato do plano e do relatorio: text (padrao) ou json")
+	fmt.Println("- Options: --dry-run - Mostra o plano sem alterar a organizacao; com --format json pode ser executado depois com --apply-plan")
+	fmt.Println("- Options: --mapping - Arquivo YAML que renomeia API products, ambientes, emails e valores de atributos para restaurar em outra organizacao")
+	fmt.Println("- Options: --allow-unmapped - Restaura mesmo com referencias fora do --mapping, com o nome original")
+	fmt.Println("- Options: --include-developer/--exclude-developer <glob>, --include-app/--exclude-app <regex>, --include-product/--exclude-product <product>, --include-status/--exclude-status <status>, --include-attribute/--exclude-attribute <nome=valor> - Restaura so os developers e apps do snapshot que passam nos filtros (podem repetir)")
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave ou senha para decifrar os consumer secrets, se o backup foi cifrado")
+	fmt.Println("- Options: --regenerate-secrets - Gera consumer secrets novos quando o backup foi feito com --redact-secrets")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
+	fmt.Println("\nOBS: o backup nao guarda os API products; a primeira fase confere se os products usados pelas chaves existem.")
+	fmt.Println("\nEx: go run main.go --on-error continue service-account.json my-org backups/developers_<ID> backups/apps_<ID>")
+}
+
+func main() {
+	endpoint := apigeeclient.EndpointFlag()
+	secretsKMS := secrets.Flags()
+	mappingFile := mapping.Flags()
+	filters := filter.Flags()
+	journalFile := restore.JournalFlag()
+	regenerate := flag.Bool("regenerate-secrets", false, "Gera consumer secrets novos para backups com --redact-secrets")
+	policy := flag.String("on-error", restore.PolicyFailFast, "O que fazer quando um passo falha: fail-fast ou continue")
+	format := flag.String("format", restore.FormatText, "Formato do plano e do relatorio: text ou json")
+	dryRun := flag.Bool("dry-run", false, "Mostra o plano sem alterar a organizacao")
+	applyPlan := flag.String("apply-plan", "", "Executa o plano gravado pelo --dry-run --format json")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 && !(*applyPlan != "" && flag.NArg() == 2) {
+		help()
+		os.Exit(2)
+	}
+
+	org := flag.Arg(1)
+	ctx := context.Background()
+
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m, err := mappingFile()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := filters()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := apigeeclient.New(ctx, flag.Arg(0), *endpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
+	}
+
+	// O stdout fica so com o plano ou o relatorio.
+	backup.Output = os.Stderr
+	restore.Output = os.Stderr
+
+	opts := restore.Options{RegenerateRedacted: *regenerate, Policy: *policy, Mapping: m, Filter: f}
+
+	var plan *restore.Plan
+	var snaps []*snapshot.Reader
+	if *applyPlan != "" {
+		plan, err = restore.ReadPlan(*applyPlan)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if plan.Organization != org {
+			log.Fatalf("O plano e da organizacao %s, nao de %s", plan.Organization, org)
+		}
+		snaps, err = restore.OpenSnapshots(ctx, plan, kms)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, path := range flag.Args()[2:] {
+			snap, err := snapshot.Open(ctx, path)
+			if err != nil {
+				log.Fatalf("Erro ao abrir o snapshot %s: %v", path, err)
+			}
+			snap.SetKMS(kms)
+			snaps = append(snaps, snap)
+		}
+
+		if err := restore.CheckMapping(opts, snaps...); err != nil {
+			log.Fatal(err)
+		}
+
+		live, err := drift.Live(ctx, client, org, map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true})
+		if err != nil {
+			log.Fatalf("Erro ao ler o estado atual da organizacao: %v", err)
+		}
+		plan, err = restore.AllPlan(org, live, opts, snaps...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, warning := range plan.Warnings {
+			log.Printf("Atencao: %s", warning)
+		}
+	}
+
+	if *dryRun {
+		if err := restore.WritePlan(os.Stdout, plan, *format); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	opts.Journal, err = journalFile(org)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Rollback = *policy != restore.PolicyContinue
+
+	report, err := restore.ApplyPlan(ctx, client, plan, snaps, opts)
+	opts.Journal.CloseAndLog()
+	if report != nil {
+		if err := restore.WriteReport(os.Stdout, report, *format); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
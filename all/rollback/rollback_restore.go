@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/restore"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--dry-run] <serviceAccountFile> <journal>")
+	fmt.Println("\nDescription: Desfaz, da ultima para a primeira, as alteracoes registradas no journal de um restore:")
+	fmt.Println("apaga as chaves, apps e developers criados e volta os status alterados.")
+	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
+	fmt.Println("- Options: <journal> - Journal gravado pelo restore (--journal ou restore-<org>-<data e hora>.jsonl)")
+	fmt.Println("- Options: --dry-run - So lista o que seria desfeito")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
+	fmt.Println("\nO que ja foi desfeito fica marcado no journal: rodar de novo so tenta o que falhou.")
+	fmt.Println("\nEx: go run main.go service-account.json restore-my-org-20240201T100000Z.jsonl")
+}
+
+func main() {
+	endpoint := apigeeclient.EndpointFlag()
+	dryRun := flag.Bool("dry-run", false, "So lista o que seria desfeito")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		help()
+		os.Exit(2)
+	}
+
+	journal, err := restore.OpenJournal(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer journal.Close()
+
+	pending := journal.Pending()
+	if len(pending) == 0 {
+		fmt.Println("Nada a desfazer.")
+		return
+	}
+
+	if *dryRun {
+		for i := len(pending) - 1; i >= 0; i-- {
+			fmt.Printf("desfazer (%s): %s\n", pending[i].Organization, pending[i].Step)
+		}
+		return
+	}
+
+	ctx := context.Background()
+	client, err := apigeeclient.New(ctx, flag.Arg(0), *endpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
+	}
+
+	if err := journal.Rollback(ctx, client); err != nil {
+		journal.Close()
+		log.Fatal(err)
+	}
+	fmt.Printf("Total de alteracoes desfeitas: %d\n", len(pending))
+}
@@ -2,190 +2,155 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"os"
-	"time"
 
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/apigee/v1"
-	"google.golang.org/api/option"
-	"gopkg.in/yaml.v2"
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/history"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
 )
 
-type Attribute struct {
-	Name  string `yaml:"name"`
-	Value string `yaml:"value"`
-}
-
-type Credential struct {
-	APIProducts []struct {
-		APIProduct string `yaml:"apiproduct"`
-		Status     string `yaml:"status"`
-	} `yaml:"apiProducts"`
-	ConsumerKey    string `yaml:"consumerKey"`
-	ConsumerSecret string `yaml:"consumerSecret"`
-	ExpiresAt      int64  `yaml:"expiresAt"`
-	IssuedAt       int64  `yaml:"issuedAt"`
-	Status         string `yaml:"status"`
-}
-
-type AppBackup struct {
-	AppID          string       `yaml:"appId"`
-	Attributes     []Attribute  `yaml:"attributes"`
-	CreatedAt      int64        `yaml:"createdAt"`
-	Credentials    []Credential `yaml:"credentials"`
-	DeveloperID    string       `yaml:"developerId"`
-	LastModifiedAt int64        `yaml:"lastModifiedAt"`
-	Name           string       `yaml:"name"`
-	Status         string       `yaml:"status"`
-	AppFamily      string       `yaml:"appFamily"`
-}
-
 func help() {
-	fmt.Println("Usage: go run main.go <serviceAccountFile> <organization> <backupDir>")
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--archive tar.gz|tar.zst] [--format yaml|json|ndjson] [--incremental] [--dedup] [--git <dir> [--git-push <remote>]] [--secrets-key-file <file> | --secrets-passphrase-env <VAR> | --redact-secrets] [--include-*/--exclude-* ...] <serviceAccountFile> <organization> <backupDir>")
+	fmt.Println("       go run main.go [--api-endpoint <url>] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] --resume <snapshot> <serviceAccountFile> <organization>")
 	fmt.Println("\nDescription: Este programa faz backup de todos os Apps do Apigee.")
 	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
 	fmt.Println("- Options: <organization> - Organizacao Apigee")
-	fmt.Println("- Options: <backupDir> - Diretorio que deseja criar. OBS: O script cria no final do diretorio _timestamp")
+	fmt.Println("- Options: <backupDir> - Diretorio, gs://bucket/prefixo ou s3://bucket/prefixo que deseja criar. OBS: O script cria no final do diretorio _<data e hora UTC>-<sufixo>, ex: _20240201T100000Z-3fa2c1")
+	fmt.Println("- Options: --archive - Grava o snapshot em um unico arquivo " + snapshot.FormatTarGz + " ou " + snapshot.FormatTarZstd + " em vez de um diretorio")
+	fmt.Println("- Options: --secrets-key-file - Cifra os consumer secrets com a chave local do arquivo (hex, 32 bytes)")
+	fmt.Println("- Options: --secrets-passphrase-env - Cifra os consumer secrets com a senha da variavel de ambiente informada")
+	fmt.Println("- Options: --redact-secrets - Troca os consumer secrets por uma impressao digital (SHA-256 com salt). O backup serve para inventario/auditoria")
+	fmt.Println("- Options: --include-developer/--exclude-developer <glob>, --include-app/--exclude-app <regex>, --include-product/--exclude-product <product>, --include-status/--exclude-status <status>, --include-attribute/--exclude-attribute <nome=valor> - Faz o backup so dos apps que passam nos filtros (podem repetir); os filtros ficam no manifesto")
+	fmt.Println("- Options: --format - Formato dos documentos: yaml, json ou ndjson (um arquivo " + snapshot.StreamPath(snapshot.KindApp) + " com um app por linha). Padrao: apps em YAML e developers em JSON")
+	fmt.Println("- Options: --incremental - Compara com o snapshot completo mais recente do mesmo <backupDir> no catalogo e grava so os apps que mudaram (lastModifiedAt) e os apagados. Sem snapshot anterior, faz um backup completo")
+	fmt.Println("- Options: --dedup - Grava cada documento uma vez so, pelo SHA-256, em .objects ao lado dos snapshots; o snapshot fica so com o manifesto. Nao vale com --archive")
+	fmt.Println("- Options: --git - Depois do backup, grava a organizacao no repositorio git local informado (<dir>/<organization>) e faz um commit com as contagens e as mudancas. Exige secrets cifrados ou --redact-secrets")
+	fmt.Println("- Options: --git-push - Remote que recebe o push do commit do --git, ex: origin")
+	fmt.Println("- Options: --resume - Retoma um backup interrompido ou parcial no snapshot informado, com o mesmo ID; os apps ja salvos sao pulados. Filtros e redacao vem do snapshot; informe a mesma chave ou senha se os secrets foram cifrados")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
 	fmt.Println("\nEx: go run main.go service-account.json my-org backups")
 }
 
 func main() {
-	if len(os.Args) < 4 {
+	endpoint := apigeeclient.EndpointFlag()
+	archive := flag.String("archive", "", "Grava o snapshot em um arquivo tar.gz ou tar.zst")
+	secretsKMS := secrets.Flags()
+	filters := filter.Flags()
+	redact := flag.Bool("redact-secrets", false, "Troca os consumer secrets pela impressao digital")
+	resume := flag.String("resume", "", "Snapshot interrompido ou parcial a retomar")
+	incremental := flag.Bool("incremental", false, "Grava so o que mudou desde o snapshot anterior")
+	dedup := flag.Bool("dedup", false, "Grava os documentos deduplicados pelo conteudo em .objects")
+	format := flag.String("format", "", "Formato dos documentos: yaml, json ou ndjson")
+	gitDir := flag.String("git", "", "Repositorio git local que recebe o backup")
+	gitPush := flag.String("git-push", "", "Remote do push depois do commit no git")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 && (*resume == "" || flag.NArg() < 2) {
 		help()
 		return
 	}
 
-	serviceAccountFile := os.Args[1]
-	org := os.Args[2]
-	backupDir := os.Args[3]
+	serviceAccountFile := flag.Arg(0)
+	org := flag.Arg(1)
+	backupDir := flag.Arg(2)
 
-	timestamp := time.Now().Format("02-01-2006_15-04-05")
-	dirBackup := backupDir + "_" + string(timestamp)
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	err := os.Mkdir(dirBackup, 0755)
+	f, err := filters()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("Diretorio '%s' criado com sucesso.", backupDir)
+	if *gitDir != "" && *resume == "" && (f != nil || (kms == nil && !*redact)) {
+		log.Fatal("--git guarda a organizacao inteira e sem secrets em texto claro: nao use filtros e informe --secrets-key-file, --secrets-passphrase-env ou --redact-secrets")
+	}
 
 	ctx := context.Background()
 
-	serviceAccountJSON, err := os.ReadFile(serviceAccountFile)
-	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais de Service Account %v", err)
+	var snap *snapshot.Writer
+	if *resume != "" {
+		if *archive != "" || *redact || f != nil || *incremental || *dedup || *format != "" {
+			log.Fatal("--resume usa o formato, o --archive, a redacao, os filtros e os modos incremental e deduplicado do snapshot retomado")
+		}
+		snap, err = snapshot.Resume(ctx, *resume, org, snapshot.Options{KMS: kms})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Retomando o snapshot '%s'.", snap.Path())
+	} else {
+		opts := snapshot.Options{Archive: *archive, KMS: kms, RedactSecrets: *redact, Filter: f, Incremental: *incremental, Dedup: *dedup, Format: *format}
+		snap, err = snapshot.Create(ctx, backupDir, org, opts)
+		if errors.Is(err, snapshot.ErrNoBase) {
+			log.Printf("%v; fazendo um backup completo.", err)
+			opts.Incremental = false
+			snap, err = snapshot.Create(ctx, backupDir, org, opts)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Snapshot '%s' criado com sucesso.", snap.Path())
 	}
-
-	credentials, err := google.CredentialsFromJSON(ctx, serviceAccountJSON, apigee.CloudPlatformScope)
-	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais da Service Account: %v", err)
+	if base := snap.Base(); base != nil {
+		log.Printf("Backup incremental sobre '%s'.", base.Path())
+	}
+	if f := snap.Filter(); f != nil {
+		log.Printf("Filtros: %s", f)
 	}
 
-	service, err := apigee.NewService(ctx, option.WithCredentials(credentials))
+	client, err := apigeeclient.New(ctx, serviceAccountFile, *endpoint)
 	if err != nil {
 		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
 	}
 
-	developers, err := service.Organizations.Developers.List("organizations/" + org).Do()
+	numApps, err := backup.Apps(ctx, client, org, snap)
 	if err != nil {
-		log.Fatalf("Erro ao obter a lista de developers: %v", err)
+		snap.RecordError(err)
 	}
 
-	var numApps int
-
-	for _, developer := range developers.Developer {
-		apps, err := service.Organizations.Developers.Apps.List("organizations/" + org + "/developers/" + developer.Email).Do()
-		if err != nil {
-			log.Printf("Erro ao obter a lista de Apps do developer %s: %v", developer.Email, err)
-			continue
-		}
+	manifest, closeErr := snap.Close()
+	if closeErr != nil {
+		log.Fatalf("Erro ao gravar o manifesto: %v", closeErr)
+	}
+	if manifest.Status == snapshot.StatusPartial && *archive == "" {
+		log.Printf("Backup parcial; para continuar de onde parou: go run backup_apps.go --resume %s %s %s", snap.Path(), serviceAccountFile, org)
+	}
+	if err != nil {
+		log.Fatalf("Erro ao fazer o backup dos Apps: %v", err)
+	}
 
-		for _, app := range apps.App {
-			appDetails, err := service.Organizations.Developers.Apps.Get("organizations/" + org + "/developers/" + developer.Email + "/apps/" + app.AppId).Do()
-			if err != nil {
-				log.Printf("Erro ao obter os detalhes do App: %v", err)
-				continue
-			}
-			numApps++
-
-			var attributes []Attribute
-			for _, attr := range appDetails.Attributes {
-				attributes = append(attributes, Attribute{
-					Name:  attr.Name,
-					Value: attr.Value,
-				})
-			}
-
-			var credentials []Credential
-			for _, cred := range appDetails.Credentials {
-				apiProducts := make([]struct {
-					APIProduct string `yaml:"apiproduct"`
-					Status     string `yaml:"status"`
-				}, len(cred.ApiProducts))
-
-				for i, product := range cred.ApiProducts {
-					apiProducts[i] = struct {
-						APIProduct string `yaml:"apiproduct"`
-						Status     string `yaml:"status"`
-					}{
-						APIProduct: product.Apiproduct,
-						Status:     product.Status,
-					}
-				}
-
-				credentials = append(credentials, Credential{
-					APIProducts:    apiProducts,
-					ConsumerKey:    cred.ConsumerKey,
-					ConsumerSecret: cred.ConsumerSecret,
-					ExpiresAt:      cred.ExpiresAt,
-					IssuedAt:       cred.IssuedAt,
-					Status:         cred.Status,
-				})
-			}
-
-			appBackup := AppBackup{
-				AppID:          appDetails.AppId,
-				Attributes:     attributes,
-				CreatedAt:      appDetails.CreatedAt,
-				Credentials:    credentials,
-				DeveloperID:    developer.Email,
-				LastModifiedAt: appDetails.LastModifiedAt,
-				Name:           appDetails.Name,
-				Status:         appDetails.Status,
-				AppFamily:      appDetails.AppFamily,
-			}
-
-			yamlData, err := yaml.Marshal(appBackup)
-			if err != nil {
-				log.Fatalf("Erro ao converter o backup do App em YAML: %v", err)
-				continue
-			}
-
-			filename := fmt.Sprintf(dirBackup+"/%s.yaml", appDetails.Name)
-			err = saveToFile(filename, yamlData)
-			if err != nil {
-				log.Printf("Erro ao salvar o arquivo YAML: %v", err)
-			}
-			fmt.Printf(" - Apps consumido: %s\n", appDetails.Name)
+	if *gitDir != "" {
+		if manifest.Status != snapshot.StatusComplete {
+			log.Fatal("Backup parcial nao vai para o git; retome o backup e rode de novo com --git")
 		}
+		commitHistory(ctx, snap.Path(), history.Options{Dir: *gitDir, KMS: kms, Remote: *gitPush})
 	}
 
 	fmt.Printf("Total de Apps: %d\n", numApps)
 }
 
-func saveToFile(filename string, data []byte) error {
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+// commitHistory grava o snapshot no repositorio git do --git.
+func commitHistory(ctx context.Context, path string, opts history.Options) {
+	r, err := snapshot.Open(ctx, path)
 	if err != nil {
-		return fmt.Errorf("erro ao abrir o arquivo: %v", err)
+		log.Fatal(err)
 	}
-	defer file.Close()
-
-	_, err = file.Write(data)
+	result, err := history.Commit(ctx, r, opts)
 	if err != nil {
-		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+		log.Fatalf("Erro ao gravar o backup no git: %v", err)
 	}
-
-	return nil
+	if result.Commit == "" {
+		log.Printf("Nada mudou no git desde o ultimo commit em '%s'.", opts.Dir)
+		return
+	}
+	log.Printf("Commit %s gravado em '%s'.", result.Commit, opts.Dir)
 }
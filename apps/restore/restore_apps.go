@@ -2,232 +2,241 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/apigee/v1"
-	"google.golang.org/api/option"
-	"gopkg.in/yaml.v2"
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
 )
 
-type CustomAttribute struct {
-	Name  string `json:"name" yaml:"name"`
-	Value string `json:"value" yaml:"value"`
-}
-
-type Credential struct {
-	APIProducts []struct {
-		APIProduct string `json:"apiproduct" yaml:"apiproduct"`
-		Status     string `json:"status" yaml:"status"`
-	} `json:"apiProducts" yaml:"apiProducts"`
-	ConsumerKey    string `json:"consumerKey" yaml:"consumerKey"`
-	ConsumerSecret string `json:"consumerSecret" yaml:"consumerSecret"`
-	ExpiresAt      int64  `json:"expiresAt" yaml:"expiresAt"`
-	IssuedAt       int64  `json:"issuedAt" yaml:"issuedAt"`
-	Status         string `json:"status" yaml:"status"`
-}
-
-type AppBackup struct {
-	AppID      string            `json:"appId" yaml:"appId"`
-	Attributes []CustomAttribute `json:"attributes" yaml:"attributes"`
-	//Attributes     map[string]string `json:"attributes" yaml:"attributes"`
-	CreatedAt      int64        `json:"createdAt" yaml:"createdAt"`
-	Credentials    []Credential `json:"credentials" yaml:"credentials"`
-	DeveloperID    string       `json:"developerId" yaml:"developerId"`
-	LastModifiedAt int64        `json:"lastModifiedAt" yaml:"lastModifiedAt"`
-	Name           string       `json:"name" yaml:"name"`
-	Status         string       `json:"status" yaml:"status"`
-	AppFamily      string       `json:"appFamily" yaml:"appFamily"`
-}
-
 type Config struct {
 	ServiceAccountFile string
 	Organization       string
 	BackupFile         string
+	APIEndpoint        string
 }
 
 func help() {
-	fmt.Println("Usage: go run main.go <serviceAccountFile> <organization> <backupFile>")
-	fmt.Println("\nDescription: Este programa faz o restore de um App do Apigee a partir de um arquivo de backup no formato YAML.")
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] [--regenerate-secrets] [--as-of <time>] [--mapping <file> [--allow-unmapped]] [--include-*/--exclude-* ...] [--journal <file>] [--dry-run [--format text|json]] <serviceAccountFile> <organization> <backupFile>")
+	fmt.Println("       go run main.go [--api-endpoint <url>] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] [--regenerate-secrets] [--journal <file>] --apply-plan <plan.json> <serviceAccountFile> <organization>")
+	fmt.Println("\nDescription: Este programa faz o restore de Apps do Apigee a partir do arquivo de um app ou de um snapshot inteiro, em qualquer formato (yaml, json ou ndjson).")
 	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
 	fmt.Println("- Options: <organization> - Organizacao Apigee")
-	fmt.Println("- Options: <backupFile> - Arquivo de backup no formato YAML")
+	fmt.Println("- Options: <backupFile> - Arquivo de um app (apps/<email>/<app>.yaml, apps/<email>/<app>.json, tambem no formato ndjson, ou <app>.yaml nos snapshots antigos), diretorio do snapshot ou arquivo .tar.gz/.tar.zst, local ou em gs:// / s3://")
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave ou senha para decifrar os consumer secrets, se o backup foi cifrado")
+	fmt.Println("- Options: --regenerate-secrets - Gera consumer secrets novos quando o backup foi feito com --redact-secrets")
+	fmt.Println("- Options: --as-of - Restaura o snapshot mais recente iniciado ate o horario (ex: 2024-02-01T10:00:00Z); <backupFile> passa a ser o <backupDir> do backup")
+	fmt.Println("- Options: --mapping - Arquivo YAML que renomeia API products, ambientes, emails e valores de atributos para restaurar em outra organizacao")
+	fmt.Println("- Options: --allow-unmapped - Restaura mesmo com referencias fora do --mapping, com o nome original")
+	fmt.Println("- Options: --include-developer/--exclude-developer <glob>, --include-app/--exclude-app <regex>, --include-product/--exclude-product <product>, --include-status/--exclude-status <status>, --include-attribute/--exclude-attribute <nome=valor> - Restaura so os developers e apps do snapshot que passam nos filtros (podem repetir)")
+	fmt.Println("- Options: --journal - Arquivo onde cada alteracao e registrada (padrao restore-<org>-<data e hora>.jsonl), para desfazer com o all/rollback. Um App que falha no meio e desfeito na hora")
+	fmt.Println("- Options: --dry-run - Nao altera nada: compara o backup com a organizacao e mostra o plano (criar app, apagar a chave padrao, importar chave, associar produtos)")
+	fmt.Println("- Options: --format - Formato do plano no --dry-run: text (padrao) ou json")
+	fmt.Println("- Options: --apply-plan - Executa exatamente o plano gravado pelo --dry-run --format json, lendo as credenciais do snapshot do plano")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
 	fmt.Println("\nEx: go run main.go service-account.json my-org backup.yaml")
 }
 
 func main() {
-	if len(os.Args) < 4 {
+	endpoint := apigeeclient.EndpointFlag()
+	secretsKMS := secrets.Flags()
+	mappingFile := mapping.Flags()
+	filters := filter.Flags()
+	journalFile := restore.JournalFlag()
+	regenerate := flag.Bool("regenerate-secrets", false, "Gera consumer secrets novos para backups com --redact-secrets")
+	asOf := flag.String("as-of", "", "Restaura o snapshot mais recente iniciado ate este horario")
+	dryRun := flag.Bool("dry-run", false, "Mostra o plano do restore sem alterar a organizacao")
+	format := flag.String("format", restore.FormatText, "Formato do plano no --dry-run: text ou json")
+	applyPlan := flag.String("apply-plan", "", "Executa o plano gravado pelo --dry-run --format json")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 && !(*applyPlan != "" && flag.NArg() == 2) {
 		help()
 		return
 	}
 
 	config := Config{
-		ServiceAccountFile: os.Args[1],
-		Organization:       os.Args[2],
-		BackupFile:         os.Args[3],
+		ServiceAccountFile: flag.Arg(0),
+		Organization:       flag.Arg(1),
+		BackupFile:         flag.Arg(2),
+		APIEndpoint:        *endpoint,
 	}
 
 	ctx := context.Background()
 
-	serviceAccountJSON, err := os.ReadFile(config.ServiceAccountFile)
+	client, err := apigeeclient.New(ctx, config.ServiceAccountFile, config.APIEndpoint)
 	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais de Service Account %v", err)
-	}
-
-	token, err := google.CredentialsFromJSON(ctx, serviceAccountJSON, apigee.CloudPlatformScope)
-	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais da Service Account: %v", err)
+		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
 	}
 
-	service, err := apigee.NewService(ctx, option.WithCredentials(token))
+	kms, err := secretsKMS()
 	if err != nil {
-		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
+		log.Fatal(err)
 	}
 
-	httpClient := oauth2.NewClient(ctx, token.TokenSource)
-
-	data, err := os.ReadFile(config.BackupFile)
+	m, err := mappingFile()
 	if err != nil {
-		log.Fatalf("Erro ao ler o arquivo de backup: %v", err)
+		log.Fatal(err)
 	}
 
-	var appBackup AppBackup
-	err = yaml.Unmarshal(data, &appBackup)
+	f, err := filters()
 	if err != nil {
-		log.Fatalf("Erro ao fazer a desserializacao do arquivo de backup: %v", err)
+		log.Fatal(err)
 	}
 
-	credentials := appBackup.Credentials
-	if len(credentials) == 0 {
-		log.Fatal("Nenhuma credencial encontrada no arquivo de backup")
+	opts := restore.Options{RegenerateRedacted: *regenerate, Mapping: m, Filter: f, Rollback: true}
+	if !*dryRun {
+		opts.Journal, err = journalFile(config.Organization)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	err = createApp(service, appBackup, config)
-	if err != nil {
-		log.Fatalf("Erro ao criar o aplicativo %s: %v", appBackup.Name, err)
+	if *applyPlan != "" {
+		applyPlanFile(ctx, client, config.Organization, *applyPlan, kms, opts)
+		return
 	}
 
-	err = createConsumerKeys(httpClient, service, appBackup.DeveloperID, config.Organization, appBackup.Name, credentials)
-	if err != nil {
-		log.Fatalf("Erro ao criar as chaves do aplicativo para o App %s: %v", appBackup.Name, err)
+	if *asOf != "" {
+		config.BackupFile, err = snapshot.ResolveAsOf(ctx, config.BackupFile, *asOf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Usando o snapshot %s", config.BackupFile)
 	}
 
-	fmt.Println("App restaurado com sucesso!")
-}
-
-func createApp(client *apigee.Service, appBackup AppBackup, config Config) error {
-	app := &apigee.GoogleCloudApigeeV1DeveloperApp{
-		Name:       appBackup.Name,
-		Attributes: convertAttributes(appBackup.Attributes),
-	}
+	// Um app avulso e lido pelo snapshot que o contem, que tem o manifesto
+	// com a chave quando os secrets estao cifrados.
+	if isAppFile(config.BackupFile) {
+		snap, name, err := snapshot.OpenFile(ctx, config.BackupFile)
+		if err != nil {
+			log.Fatalf("Erro ao abrir o snapshot: %v", err)
+		}
+		snap.SetKMS(kms)
 
-	// createAppCall := client.Organizations.Developers.Apps.Create("organizations/"+config.Organization+"/developers/"+appBackup.DeveloperID, app)
-	// _, err := createAppCall.Do()
-	// if err != nil {
-	// 	return fmt.Errorf("erro ao criar o app: %v", err)
-	// }
+		sealed, err := snap.ReadAppSealed(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !f.App(sealed) {
+			log.Printf("O App %s nao passa nos filtros (%s); nada a restaurar", sealed.Name, f)
+			return
+		}
+		if err := restore.CheckUnmapped(m, nil, []model.AppBackup{sealed}); err != nil {
+			log.Fatal(err)
+		}
 
-	createAppCall := client.Organizations.Developers.Apps.Create("organizations/"+config.Organization+"/developers/"+appBackup.DeveloperID, app)
-	newApp, err := createAppCall.Do()
-	if err != nil {
-		return fmt.Errorf("erro ao criar o app: %v", err)
-	}
-
-	if newApp.Credentials != nil {
-		for _, key := range newApp.Credentials {
-			if key.ApiProducts == nil || len(key.ApiProducts) == 0 {
-				deleteKeyCall := client.Organizations.Developers.Apps.Keys.Delete("organizations/" + config.Organization + "/developers/" + appBackup.DeveloperID + "/apps/" + appBackup.Name + "/keys/" + key.ConsumerKey)
-				deleteKeyCall.Context(context.Background())
-				if _, err := deleteKeyCall.Do(); err != nil {
-					return fmt.Errorf("erro ao excluir o token padrão: %v", err)
-				}
-				fmt.Printf("Token padrão do app %s excluído: %s\n", appBackup.Name, key.ConsumerKey)
+		if *dryRun {
+			live := liveState(ctx, client, config.Organization)
+			plan, err := restore.FilePlan(config.Organization, snap, name, live, opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := restore.WritePlan(os.Stdout, plan, *format); err != nil {
+				log.Fatal(err)
 			}
+			return
+		}
 
+		appBackup, err := snap.ReadApp(name)
+		if err != nil {
+			log.Fatal(err)
 		}
-	}
 
-	return nil
-}
+		err = restore.App(ctx, client, config.Organization, appBackup, opts)
+		opts.Journal.CloseAndLog()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-func convertAttributes(attributes []CustomAttribute) []*apigee.GoogleCloudApigeeV1Attribute {
-	apiAttributes := make([]*apigee.GoogleCloudApigeeV1Attribute, 0, len(attributes))
-	for _, attr := range attributes {
-		apiAttributes = append(apiAttributes, &apigee.GoogleCloudApigeeV1Attribute{
-			Name:  attr.Name,
-			Value: attr.Value,
-		})
+		fmt.Println("App restaurado com sucesso!")
+		return
 	}
-	return apiAttributes
-}
 
-func createConsumerKeys(httpClient *http.Client, client *apigee.Service, developerID, org, appName string, credentials []Credential) error {
-	if len(credentials) == 0 {
-		return fmt.Errorf("nenhuma credencial encontrada no arquivo de backup")
+	snap, err := snapshot.Open(ctx, config.BackupFile)
+	if err != nil {
+		log.Fatalf("Erro ao abrir o snapshot: %v", err)
 	}
+	snap.SetKMS(kms)
 
-	for _, credential := range credentials {
-		consumerKey := credential.ConsumerKey
-		consumerSecret := credential.ConsumerSecret
-
-		req := &apigee.GoogleCloudApigeeV1DeveloperAppKey{
-			ConsumerKey:    consumerKey,
-			ConsumerSecret: consumerSecret,
-		}
-
-		keyCreateCall := client.Organizations.Developers.Apps.Keys.Create("organizations/"+org+"/developers/"+developerID+"/apps/"+appName, req)
-		keyCreateCall.Context(context.Background())
+	if err := restore.CheckMapping(opts, snap); err != nil {
+		log.Fatal(err)
+	}
 
-		key, err := keyCreateCall.Do()
+	if *dryRun {
+		live := liveState(ctx, client, config.Organization)
+		plan, err := restore.SnapshotPlan(config.Organization, live, opts, snap)
 		if err != nil {
-			log.Printf("Erro ao criar a chave para o App: %v", err)
-			continue
+			log.Fatal(err)
 		}
-
-		fmt.Printf("Chave do app %s criada: %s\n", appName, key.ConsumerKey)
-
-		err = associateKeyToProduct(httpClient, client, org, developerID, appName, key.ConsumerKey, credential.APIProducts[0].APIProduct)
-		if err != nil {
-			log.Printf("Erro ao associar a chave ao produto para o App: %v", err)
+		if err := restore.WritePlan(os.Stdout, plan, *format); err != nil {
+			log.Fatal(err)
 		}
-
+		return
 	}
 
-	return nil
+	restored, err := restore.Apps(ctx, client, config.Organization, snap, opts)
+	opts.Journal.CloseAndLog()
+	fmt.Printf("Total de Apps restaurados: %d\n", restored)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-func associateKeyToProduct(httpClient *http.Client, client *apigee.Service, org, developerID, appName, consumerKey, productID string) error {
-	url := fmt.Sprintf("https://apigee.googleapis.com/v1/organizations/%s/developers/%s/apps/%s/keys/%s", org, developerID, appName, consumerKey)
-
-	requestBody := fmt.Sprintf(`{"apiProducts": ["%s"]}`, productID)
+// isAppFile reconhece um app avulso: um YAML ou um JSON em apps/<email>/. No
+// formato ndjson o caminho e o do documento dentro de apps.ndjson.
+func isAppFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".json" {
+		return filepath.Base(filepath.Dir(filepath.Dir(filepath.FromSlash(path)))) == snapshot.AppsDir
+	}
+	return ext == ".yaml" || ext == ".yml"
+}
 
-	req, err := http.NewRequest("POST", url, strings.NewReader(requestBody))
+// liveState le developers e apps da organizacao para o plano do --dry-run. O
+// progresso vai para o stderr, para o plano poder ser redirecionado.
+func liveState(ctx context.Context, client *apigeeclient.Client, org string) *diff.State {
+	backup.Output = os.Stderr
+	live, err := drift.Live(ctx, client, org, map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true})
 	if err != nil {
-		return fmt.Errorf("erro ao criar a requisição HTTP: %v", err)
+		log.Fatalf("Erro ao ler o estado atual da organizacao: %v", err)
 	}
+	return live
+}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := httpClient.Do(req)
+func applyPlanFile(ctx context.Context, client *apigeeclient.Client, org, path string, kms secrets.KMS, opts restore.Options) {
+	plan, err := restore.ReadPlan(path)
 	if err != nil {
-		return fmt.Errorf("erro ao fazer a requisição HTTP: %v", err)
+		log.Fatal(err)
+	}
+	if plan.Organization != org {
+		log.Fatalf("O plano e da organizacao %s, nao de %s", plan.Organization, org)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	snaps, err := restore.OpenSnapshots(ctx, plan, kms)
 	if err != nil {
-		return fmt.Errorf("erro ao ler o corpo da resposta HTTP: %v", err)
+		log.Fatal(err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("erro ao associar a chave ao produto: %s", string(body))
+	report, err := restore.ApplyPlan(ctx, client, plan, snaps, opts)
+	opts.Journal.CloseAndLog()
+	if report != nil {
+		restore.WriteReport(os.Stdout, report, restore.FormatText)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	return nil
 }
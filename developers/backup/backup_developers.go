@@ -2,136 +2,147 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"os"
-	"time"
 
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/apigee/v1"
-	"google.golang.org/api/option"
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/history"
+	"backup-restore-apigee/internal/snapshot"
 )
 
-type DeveloperBackup struct {
-	Email            string   `json:"email"`
-	FirstName        string   `json:"firstName"`
-	LastName         string   `json:"lastName"`
-	UserName         string   `json:"userName"`
-	Apps             []string `json:"apps"`
-	DeveloperID      string   `json:"developerId"`
-	OrganizationName string   `json:"organizationName"`
-	Status           string   `json:"status"`
-	CreatedAt        int64    `json:"createdAt"`
-	LastModifiedAt   int64    `json:"lastModifiedAt"`
-}
-
 func help() {
-	fmt.Println("Usage: go run main.go <serviceAccountFile> <organization> <backupDir>")
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--archive tar.gz|tar.zst] [--format yaml|json|ndjson] [--incremental] [--dedup] [--git <dir> [--git-push <remote>]] [--include-developer <glob>] [--exclude-developer <glob>] <serviceAccountFile> <organization> <backupDir>")
+	fmt.Println("       go run main.go [--api-endpoint <url>] --resume <snapshot> <serviceAccountFile> <organization>")
 	fmt.Println("\nDescription: Este programa faz backup de todos os developers do Apigee.")
 	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
 	fmt.Println("- Options: <organization> - Organizacao Apigee")
-	fmt.Println("- Options: <backupDir> - Diretorio que deseja criar. OBS: O script cria no final do diretorio _dia-mes-ano_hora_min_segundos")
+	fmt.Println("- Options: <backupDir> - Diretorio, gs://bucket/prefixo ou s3://bucket/prefixo que deseja criar. OBS: O script cria no final do diretorio _<data e hora UTC>-<sufixo>, ex: _20240201T100000Z-3fa2c1")
+	fmt.Println("- Options: --archive - Grava o snapshot em um unico arquivo " + snapshot.FormatTarGz + " ou " + snapshot.FormatTarZstd + " em vez de um diretorio")
+	fmt.Println("- Options: --include-developer/--exclude-developer - Glob do email dos developers incluidos ou excluidos, ex: *@parceiro.com (podem repetir); os filtros ficam no manifesto")
+	fmt.Println("- Options: --format - Formato dos documentos: yaml, json ou ndjson (um arquivo " + snapshot.StreamPath(snapshot.KindDeveloper) + " com um developer por linha). Padrao: apps em YAML e developers em JSON")
+	fmt.Println("- Options: --incremental - Compara com o snapshot completo mais recente do mesmo <backupDir> no catalogo e grava so os developers que mudaram (lastModifiedAt) e os apagados. Sem snapshot anterior, faz um backup completo")
+	fmt.Println("- Options: --dedup - Grava cada documento uma vez so, pelo SHA-256, em .objects ao lado dos snapshots; o snapshot fica so com o manifesto. Nao vale com --archive")
+	fmt.Println("- Options: --git - Depois do backup, grava a organizacao no repositorio git local informado (<dir>/<organization>) e faz um commit com as contagens e as mudancas")
+	fmt.Println("- Options: --git-push - Remote que recebe o push do commit do --git, ex: origin")
+	fmt.Println("- Options: --resume - Retoma um backup interrompido ou parcial no snapshot informado, com o mesmo ID; os developers ja salvos sao pulados. Os filtros vem do snapshot")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
 	fmt.Println("\nEx: go run main.go service-account.json my-org backups")
 }
 
 func main() {
-
-	if len(os.Args) < 4 {
+	endpoint := apigeeclient.EndpointFlag()
+	archive := flag.String("archive", "", "Grava o snapshot em um arquivo tar.gz ou tar.zst")
+	filters := filter.Flags()
+	resume := flag.String("resume", "", "Snapshot interrompido ou parcial a retomar")
+	incremental := flag.Bool("incremental", false, "Grava so o que mudou desde o snapshot anterior")
+	dedup := flag.Bool("dedup", false, "Grava os documentos deduplicados pelo conteudo em .objects")
+	format := flag.String("format", "", "Formato dos documentos: yaml, json ou ndjson")
+	gitDir := flag.String("git", "", "Repositorio git local que recebe o backup")
+	gitPush := flag.String("git-push", "", "Remote do push depois do commit no git")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 && (*resume == "" || flag.NArg() < 2) {
 		help()
 		return
-
 	}
 
-	serviceAccountFile := os.Args[1]
-	org := os.Args[2]
-	backupDir := os.Args[3]
-
-	//timestamp := time.Now().Unix()
-	timestamp := time.Now().Format("02-01-2006_15-04-05")
+	serviceAccountFile := flag.Arg(0)
+	org := flag.Arg(1)
+	backupDir := flag.Arg(2)
 
-	//dirBackup := backupDir + "_" + strconv.FormatInt(timestamp, 10)
-	dirBackup := backupDir + "_" + string(timestamp)
-
-	err := os.Mkdir(dirBackup, 0755)
+	f, err := filters()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if f.SelectsApps() {
+		log.Fatal("O backup de developers aceita so --include-developer e --exclude-developer")
+	}
 
-	log.Printf("Diretorio '%s' criado com sucesso.", backupDir)
-
-	//serviceAccountFile := "../../credentials/admapi.json"
+	if *gitDir != "" && *resume == "" && f != nil {
+		log.Fatal("--git guarda a organizacao inteira: nao use filtros")
+	}
 
 	ctx := context.Background()
 
-	serviceAccountJSON, err := os.ReadFile(serviceAccountFile)
-	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais de Service Account %v", err)
+	var snap *snapshot.Writer
+	if *resume != "" {
+		if *archive != "" || f != nil || *incremental || *dedup || *format != "" {
+			log.Fatal("--resume usa o formato, o --archive, os filtros e os modos incremental e deduplicado do snapshot retomado")
+		}
+		snap, err = snapshot.Resume(ctx, *resume, org, snapshot.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Retomando o snapshot '%s'.", snap.Path())
+	} else {
+		opts := snapshot.Options{Archive: *archive, Filter: f, Incremental: *incremental, Dedup: *dedup, Format: *format}
+		snap, err = snapshot.Create(ctx, backupDir, org, opts)
+		if errors.Is(err, snapshot.ErrNoBase) {
+			log.Printf("%v; fazendo um backup completo.", err)
+			opts.Incremental = false
+			snap, err = snapshot.Create(ctx, backupDir, org, opts)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Snapshot '%s' criado com sucesso.", snap.Path())
 	}
-
-	credentials, err := google.CredentialsFromJSON(ctx, serviceAccountJSON, apigee.CloudPlatformScope)
-	if err != nil {
-		log.Fatalf("Erro ao carregar as credenciais da Service Account: %v", err)
+	if base := snap.Base(); base != nil {
+		log.Printf("Backup incremental sobre '%s'.", base.Path())
+	}
+	if f := snap.Filter(); f != nil {
+		log.Printf("Filtros: %s", f)
 	}
 
-	service, err := apigee.NewService(ctx, option.WithCredentials(credentials))
+	client, err := apigeeclient.New(ctx, serviceAccountFile, *endpoint)
 	if err != nil {
 		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
 	}
 
-	developers, err := service.Organizations.Developers.List("organizations/" + org).Do()
+	numDevelopers, err := backup.Developers(ctx, client, org, snap)
 	if err != nil {
-		log.Fatalf("Erro ao obter a lista de developers: %v", err)
+		snap.RecordError(err)
 	}
 
-	// Percorre a lista de apps que pertencem ao developers
-	for _, developer := range developers.Developer {
-		developerDetails, err := service.Organizations.Developers.Get(fmt.Sprintf("organizations/%s/developers/%s", org, developer.Email)).Do()
-		if err != nil {
-			log.Fatalf("Erro ao obter a lista de developer %s: %v", developer.Email, err)
-			continue
-		}
-
-		developerBackup := DeveloperBackup{
-			Email:            developerDetails.Email,
-			FirstName:        developerDetails.FirstName,
-			LastName:         developerDetails.LastName,
-			UserName:         developerDetails.UserName,
-			Apps:             developerDetails.Apps,
-			DeveloperID:      developerDetails.DeveloperId,
-			OrganizationName: developerDetails.OrganizationName,
-			Status:           developerDetails.Status,
-			CreatedAt:        developerDetails.CreatedAt,
-			LastModifiedAt:   developerDetails.LastModifiedAt,
-		}
-
-		backupData, err := json.MarshalIndent(developerBackup, "", "  ")
-		if err != nil {
-			log.Printf("Erro ao converter o developer para JSON: %v", err)
-			continue
-		}
+	manifest, closeErr := snap.Close()
+	if closeErr != nil {
+		log.Fatalf("Erro ao gravar o manifesto: %v", closeErr)
+	}
+	if manifest.Status == snapshot.StatusPartial && *archive == "" {
+		log.Printf("Backup parcial; para continuar de onde parou: go run backup_developers.go --resume %s %s %s", snap.Path(), serviceAccountFile, org)
+	}
+	if err != nil {
+		log.Fatalf("Erro ao fazer o backup dos developers: %v", err)
+	}
 
-		filename := fmt.Sprintf(dirBackup+"/%s.json", developerBackup.Email)
-		err = saveToFile(filename, backupData)
-		if err != nil {
-			log.Printf("Erro ao salvar o arquivo de backup para o developers %s: %v", developer.Email, err)
+	if *gitDir != "" {
+		if manifest.Status != snapshot.StatusComplete {
+			log.Fatal("Backup parcial nao vai para o git; retome o backup e rode de novo com --git")
 		}
+		commitHistory(ctx, snap.Path(), history.Options{Dir: *gitDir, KMS: nil, Remote: *gitPush})
 	}
+
+	fmt.Printf("Total de developers: %d\n", numDevelopers)
 }
 
-func saveToFile(filename string, data []byte) error {
-	// Abre o arquivo no modo de escrita
-	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+// commitHistory grava o snapshot no repositorio git do --git.
+func commitHistory(ctx context.Context, path string, opts history.Options) {
+	r, err := snapshot.Open(ctx, path)
 	if err != nil {
-		return fmt.Errorf("erro ao abrir o arquivo: %v", err)
+		log.Fatal(err)
 	}
-	defer file.Close()
-
-	// Escreve os dados no arquivo
-	_, err = file.Write(data)
+	result, err := history.Commit(ctx, r, opts)
 	if err != nil {
-		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+		log.Fatalf("Erro ao gravar o backup no git: %v", err)
 	}
-
-	return nil
+	if result.Commit == "" {
+		log.Printf("Nada mudou no git desde o ultimo commit em '%s'.", opts.Dir)
+		return
+	}
+	log.Printf("Commit %s gravado em '%s'.", result.Commit, opts.Dir)
 }
@@ -2,121 +2,147 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/apigee/v1"
-	"google.golang.org/api/option"
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
 )
 
-type DeveloperBackup struct {
-	Email            string   `json:"email,omitempty"`
-	UserName         string   `json:"userName,omitempty"`
-	FirstName        string   `json:"firstName,omitempty"`
-	LastName         string   `json:"lastName,omitempty"`
-	Apps             []string `json:"apps"`
-	DeveloperID      string   `json:"developerId"`
-	OrganizationName string   `json:"organizationName,omitempty"`
-	Status           string   `json:"status"`
-	CreatedAt        int64    `json:"createdAt"`
-	LastModifiedAt   int64    `json:"lastModifiedAt"`
-}
-
 func help() {
-	fmt.Println("Usage: go run main.go <serviceAccountFile> <organization> <restoreDir>")
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--as-of <time>] [--mapping <file> [--allow-unmapped]] [--include-developer <glob>] [--exclude-developer <glob>] [--journal <file>] [--dry-run [--format text|json]] <serviceAccountFile> <organization> <restoreDir>")
+	fmt.Println("       go run main.go [--api-endpoint <url>] [--journal <file>] --apply-plan <plan.json> <serviceAccountFile> <organization>")
 	fmt.Println("\nDescription: Este programa faz backup de todos os developers do Apigee.")
 	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
 	fmt.Println("- Options: <organization> - Organizacao Apigee")
-	fmt.Println("- Options: <restoreDir> - Diretorio que contem os *json dos apps, OBS: O script lista todos os *.json do diretorio e cria 1 a 1.")
+	fmt.Println("- Options: <restoreDir> - Diretorio (ou arquivo .tar.gz/.tar.zst) que contem os *json dos developers, OBS: O script lista todos os *.json e cria 1 a 1.")
+	fmt.Println("- Options: --as-of - Restaura o snapshot mais recente iniciado ate o horario (ex: 2024-02-01T10:00:00Z); <restoreDir> passa a ser o <backupDir> do backup")
+	fmt.Println("- Options: --mapping - Arquivo YAML que renomeia os emails dos developers para restaurar em outra organizacao")
+	fmt.Println("- Options: --allow-unmapped - Restaura mesmo com emails fora do --mapping, com o email original")
+	fmt.Println("- Options: --include-developer/--exclude-developer - Glob do email dos developers restaurados ou pulados, ex: *@parceiro.com (podem repetir)")
+	fmt.Println("- Options: --journal - Arquivo onde cada alteracao e registrada (padrao restore-<org>-<data e hora>.jsonl), para desfazer com o all/rollback")
+	fmt.Println("- Options: --dry-run - Nao altera nada: compara o backup com a organizacao e mostra os developers que seriam criados")
+	fmt.Println("- Options: --format - Formato do plano no --dry-run: text (padrao) ou json")
+	fmt.Println("- Options: --apply-plan - Executa exatamente o plano gravado pelo --dry-run --format json")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
 	fmt.Println("\nEx: go run main.go service-account.json my-org restoreDir")
 }
 
 func main() {
-
-	if len(os.Args) < 4 {
+	endpoint := apigeeclient.EndpointFlag()
+	asOf := flag.String("as-of", "", "Restaura o snapshot mais recente iniciado ate este horario")
+	dryRun := flag.Bool("dry-run", false, "Mostra o plano do restore sem alterar a organizacao")
+	format := flag.String("format", restore.FormatText, "Formato do plano no --dry-run: text ou json")
+	applyPlan := flag.String("apply-plan", "", "Executa o plano gravado pelo --dry-run --format json")
+	mappingFile := mapping.Flags()
+	filters := filter.Flags()
+	journalFile := restore.JournalFlag()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 && !(*applyPlan != "" && flag.NArg() == 2) {
 		help()
 		return
 
 	}
 
-	serviceAccountFile := os.Args[1]
-	org := os.Args[2]
-	backupDir := os.Args[3]
+	serviceAccountFile := flag.Arg(0)
+	org := flag.Arg(1)
+	backupDir := flag.Arg(2)
 
 	ctx := context.Background()
 
-	serviceAccountJSON, err := os.ReadFile(serviceAccountFile)
+	m, err := mappingFile()
 	if err != nil {
-		log.Fatalf("Error reading service account file: %v", err)
+		log.Fatal(err)
 	}
-
-	credentials, err := google.CredentialsFromJSON(ctx, serviceAccountJSON, apigee.CloudPlatformScope)
+	f, err := filters()
 	if err != nil {
-		log.Fatalf("Error creating credentials: %v", err)
+		log.Fatal(err)
 	}
-
-	service, err := apigee.NewService(ctx, option.WithCredentials(credentials))
-	if err != nil {
-		log.Fatalf("Error creating Apigee service: %v", err)
+	if f.SelectsApps() {
+		log.Fatal("O restore de developers aceita so --include-developer e --exclude-developer")
+	}
+	opts := restore.Options{Mapping: m, Filter: f, Rollback: true}
+	if !*dryRun {
+		opts.Journal, err = journalFile(org)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// Listar os arquivos de backup
-	backupFiles, err := filepath.Glob(filepath.Join(backupDir, "*.json"))
+	client, err := apigeeclient.New(ctx, serviceAccountFile, *endpoint)
 	if err != nil {
-		log.Fatalf("Error listing backup files: %v", err)
+		log.Fatalf("Error creating Apigee service: %v", err)
 	}
 
-	// Percorrer os arquivos de backup
-	for _, backupFile := range backupFiles {
-		// Abrir o arquivo de backup
-		file, err := os.Open(backupFile)
+	if *applyPlan != "" {
+		plan, err := restore.ReadPlan(*applyPlan)
 		if err != nil {
-			log.Printf("Error opening backup file %s: %v", backupFile, err)
-			continue
+			log.Fatal(err)
 		}
-		defer file.Close()
-
-		// Decodificar o arquivo JSON em uma estrutura DeveloperBackup
-		var backup DeveloperBackup
-		err = json.NewDecoder(file).Decode(&backup)
+		if plan.Organization != org {
+			log.Fatalf("O plano e da organizacao %s, nao de %s", plan.Organization, org)
+		}
+		snaps, err := restore.OpenSnapshots(ctx, plan, nil)
 		if err != nil {
-			log.Printf("Error decoding backup file %s: %v", backupFile, err)
-			continue
+			log.Fatal(err)
 		}
-
-		// Criar ou atualizar o registro do developer no Apigee
-		developer := &apigee.GoogleCloudApigeeV1Developer{
-			Email:            backup.Email,
-			UserName:         backup.UserName,
-			FirstName:        backup.FirstName,
-			LastName:         backup.LastName,
-			Apps:             backup.Apps,
-			DeveloperId:      backup.DeveloperID,
-			OrganizationName: backup.OrganizationName,
-			Status:           backup.Status,
-			CreatedAt:        backup.CreatedAt,
-			LastModifiedAt:   backup.LastModifiedAt,
+		report, err := restore.ApplyPlan(ctx, client, plan, snaps, opts)
+		opts.Journal.CloseAndLog()
+		if report != nil {
+			restore.WriteReport(os.Stdout, report, restore.FormatText)
 		}
-
-		// Imprimir o JSON do objeto developer
-		jsonData, err := json.MarshalIndent(developer, "", "  ")
 		if err != nil {
-			log.Printf("Error marshaling developer to JSON: %v", err)
-			continue
+			log.Fatal(err)
 		}
+		return
+	}
+
+	backupDir, err = snapshot.ResolveAsOf(ctx, backupDir, *asOf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *asOf != "" {
+		log.Printf("Usando o snapshot %s", backupDir)
+	}
 
-		fmt.Println(string(jsonData))
+	snap, err := snapshot.Open(ctx, backupDir)
+	if err != nil {
+		log.Fatalf("Error opening snapshot: %v", err)
+	}
 
-		_, err = service.Organizations.Developers.Create("organizations/"+org, developer).Do()
+	if err := restore.CheckMapping(opts, snap); err != nil {
+		log.Fatal(err)
+	}
+
+	if *dryRun {
+		// O progresso da leitura vai para o stderr; o stdout fica so com o plano.
+		backup.Output = os.Stderr
+		live, err := drift.Live(ctx, client, org, map[string]bool{snapshot.KindDeveloper: true})
+		if err != nil {
+			log.Fatalf("Erro ao ler o estado atual da organizacao: %v", err)
+		}
+		plan, err := restore.SnapshotPlan(org, live, opts, snap)
 		if err != nil {
-			log.Printf("Error creating or updating developer %s: %v", backup.Email, err)
-			continue
+			log.Fatal(err)
+		}
+		if err := restore.WritePlan(os.Stdout, plan, *format); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
 
-		fmt.Printf("Restored developer: %s\n", backup.Email)
+	_, err = restore.Developers(ctx, client, org, snap, opts)
+	opts.Journal.CloseAndLog()
+	if err != nil {
+		log.Fatal(err)
 	}
 }
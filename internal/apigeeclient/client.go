@@ -0,0 +1,83 @@
+package apigeeclient
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/apigee/v1"
+	"google.golang.org/api/option"
+)
+
+// DefaultEndpoint e o endpoint publico da API de gerenciamento do Apigee X.
+const DefaultEndpoint = "https://apigee.googleapis.com/"
+
+// EndpointEnv permite trocar o endpoint padrao sem passar a flag em todo comando.
+const EndpointEnv = "APIGEE_API_ENDPOINT"
+
+// Client agrupa o cliente gerado do Apigee e o http.Client autenticado usado
+// nas chamadas que a biblioteca nao cobre. Os dois apontam para o mesmo endpoint.
+type Client struct {
+	Service  *apigee.Service
+	HTTP     *http.Client
+	Endpoint string
+}
+
+// EndpointFlag registra a flag --api-endpoint no FlagSet padrao.
+func EndpointFlag() *string {
+	def := os.Getenv(EndpointEnv)
+	if def == "" {
+		def = DefaultEndpoint
+	}
+	return flag.String("api-endpoint", def, "Endpoint da API de gerenciamento do Apigee (hybrid, regional ou servidor local)")
+}
+
+// New carrega o service account e cria o cliente apontando para endpoint.
+func New(ctx context.Context, serviceAccountFile, endpoint string) (*Client, error) {
+	serviceAccountJSON, err := os.ReadFile(serviceAccountFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao carregar as credenciais de Service Account: %v", err)
+	}
+
+	credentials, err := google.CredentialsFromJSON(ctx, serviceAccountJSON, apigee.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao carregar as credenciais da Service Account: %v", err)
+	}
+
+	return NewWithHTTPClient(ctx, endpoint, oauth2.NewClient(ctx, credentials.TokenSource))
+}
+
+// NewWithHTTPClient cria o cliente usando um http.Client ja autenticado.
+func NewWithHTTPClient(ctx context.Context, endpoint string, httpClient *http.Client) (*Client, error) {
+	endpoint = normalizeEndpoint(endpoint)
+
+	service, err := apigee.NewService(ctx, option.WithHTTPClient(httpClient), option.WithEndpoint(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar o cliente do Apigee: %v", err)
+	}
+
+	return &Client{
+		Service:  service,
+		HTTP:     httpClient,
+		Endpoint: endpoint,
+	}, nil
+}
+
+// URL monta a URL de um recurso da API v1, ex: URL("organizations/%s", org).
+func (c *Client) URL(format string, args ...interface{}) string {
+	return c.Endpoint + "v1/" + fmt.Sprintf(format, args...)
+}
+
+func normalizeEndpoint(endpoint string) string {
+	if endpoint == "" {
+		return DefaultEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	endpoint = strings.TrimSuffix(endpoint, "/v1")
+	return endpoint + "/"
+}
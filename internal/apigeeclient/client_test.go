@@ -0,0 +1,37 @@
+package apigeeclient
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeEndpoint(t *testing.T) {
+	cases := map[string]string{
+		"":                                 DefaultEndpoint,
+		"https://apigee.googleapis.com":    "https://apigee.googleapis.com/",
+		"https://apigee.googleapis.com/v1": "https://apigee.googleapis.com/",
+		"http://localhost:8080/":           "http://localhost:8080/",
+		"https://hybrid.example.com/v1/":   "https://hybrid.example.com/",
+	}
+	for in, want := range cases {
+		if got := normalizeEndpoint(in); got != want {
+			t.Errorf("normalizeEndpoint(%q) = %q, esperado %q", in, got, want)
+		}
+	}
+}
+
+func TestURLUsesEndpoint(t *testing.T) {
+	client, err := NewWithHTTPClient(context.Background(), "http://localhost:8080", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "http://localhost:8080/v1/organizations/my-org/developers/a@b.com"
+	if got := client.URL("organizations/%s/developers/%s", "my-org", "a@b.com"); got != want {
+		t.Errorf("URL = %q, esperado %q", got, want)
+	}
+	if client.Service.BasePath != "http://localhost:8080/" {
+		t.Errorf("BasePath = %q", client.Service.BasePath)
+	}
+}
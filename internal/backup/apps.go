@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+// Output recebe o progresso do backup. O drift manda para o stderr para nao
+// misturar com o relatorio.
+var Output io.Writer = os.Stdout
+
+// Apps faz o backup de todos os apps da organizacao no snapshot, um YAML por
+// app. Erros em um developer ou app sao registrados no manifesto e o backup
+// segue; o developer fica fora do checkpoint e um snapshot.Resume tenta de
+// novo so ele e os que faltaram. Com filtro no snapshot
+// (snapshot.Options.Filter), so os apps que passam nele sao salvos. Num
+// snapshot incremental, so os apps cujo lastModifiedAt mudou desde a base sao
+// buscados e gravados, e os que sumiram viram tombstones. Devolve o numero de
+// apps salvos nesta execucao.
+func Apps(ctx context.Context, client *apigeeclient.Client, org string, snap *snapshot.Writer) (int, error) {
+	service := client.Service
+	snap.Expect(snapshot.KindApp)
+	f := snap.Filter()
+
+	var numApps int
+	finished := true
+
+	err := eachDeveloperPage(ctx, service, org, snap.Cursor(snapshot.KindApp), false, func(after string, page []*apigee.GoogleCloudApigeeV1Developer, last bool) error {
+		removedDevelopers(snap, snapshot.KindApp, after, page, last)
+
+		for _, developer := range page {
+			email := developer.Email
+			if !f.Developer(email) || snap.Done(snapshot.KindApp, email) {
+				continue
+			}
+
+			saved, ok := developerApps(ctx, client, org, email, snap)
+			numApps += saved
+			if !ok {
+				finished = false
+				continue
+			}
+			if err := snap.MarkDone(snapshot.KindApp, email); err != nil {
+				return err
+			}
+		}
+
+		// O cursor so avanca enquanto todos os developers anteriores
+		// terminaram; depois de uma falha, o progresso fica so no done.
+		if !finished || len(page) == 0 {
+			return nil
+		}
+		return snap.Advance(snapshot.KindApp, page[len(page)-1].Email)
+	})
+	if err != nil {
+		return numApps, err
+	}
+
+	if finished {
+		snap.Finish(snapshot.KindApp)
+	}
+	return numApps, nil
+}
+
+// developerApps salva os apps de um developer. Erros sao registrados no
+// manifesto e os outros apps seguem; ok diz se todos foram lidos. No backup
+// incremental a listagem vem com o lastModifiedAt (shallowExpand) e so os
+// apps que mudaram sao buscados.
+func developerApps(ctx context.Context, client *apigeeclient.Client, org, email string, snap *snapshot.Writer) (saved int, ok bool) {
+	service := client.Service
+	f := snap.Filter()
+
+	call := service.Organizations.Developers.Apps.List("organizations/" + org + "/developers/" + email).Context(ctx)
+	if snap.Incremental() {
+		call = call.ShallowExpand(true)
+	}
+	apps, err := call.Do()
+	if err != nil {
+		recordError(snap, fmt.Errorf("erro ao obter a lista de Apps do developer %s: %v", email, err))
+		return 0, false
+	}
+
+	var numApps, failed int
+	var listed []string
+	for _, app := range apps.App {
+		// Sem expand a API do Apigee X devolve o nome do app no campo appId.
+		name := app.Name
+		if name == "" {
+			name = app.AppId
+		}
+		if snap.Unchanged(snap.AppPath(email, name), app.LastModifiedAt) {
+			listed = append(listed, snap.AppPath(email, name))
+			continue
+		}
+
+		appDetails, err := service.Organizations.Developers.Apps.Get("organizations/" + org + "/developers/" + email + "/apps/" + name).Context(ctx).Do()
+		if err != nil {
+			recordError(snap, fmt.Errorf("erro ao obter os detalhes do App %s: %v", name, err))
+			failed++
+			continue
+		}
+
+		appBackup := model.AppFromApigee(appDetails, email)
+		if !f.App(appBackup) {
+			continue
+		}
+
+		err = snap.WriteApp(appBackup)
+		if err != nil {
+			recordError(snap, fmt.Errorf("erro ao salvar o arquivo YAML do App %s: %v", appDetails.Name, err))
+			failed++
+			continue
+		}
+		numApps++
+		listed = append(listed, snap.AppPath(email, appDetails.Name))
+		fmt.Fprintf(Output, " - Apps consumido: %s\n", appDetails.Name)
+	}
+
+	if failed > 0 {
+		return numApps, false
+	}
+	snap.Listed(snapshot.KindApp, email, listed)
+	return numApps, true
+}
+
+func recordError(snap *snapshot.Writer, err error) {
+	log.Print(err)
+	snap.RecordError(err)
+}
@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+// Developers faz o backup de todos os developers da organizacao no snapshot,
+// um JSON por developer. Com filtro no snapshot, so os developers cujo email
+// passa nele sao salvos. Num snapshot retomado (snapshot.Resume), a listagem
+// recomeca no checkpoint e os developers ja salvos sao pulados. Num snapshot
+// incremental, so os developers que mudaram desde a base sao buscados e
+// gravados, e os que sumiram viram tombstones. Devolve o numero de
+// developers salvos nesta execucao.
+func Developers(ctx context.Context, client *apigeeclient.Client, org string, snap *snapshot.Writer) (int, error) {
+	service := client.Service
+	snap.Expect(snapshot.KindDeveloper)
+	f := snap.Filter()
+
+	var numDevelopers int
+	finished := true
+
+	err := eachDeveloperPage(ctx, service, org, snap.Cursor(snapshot.KindDeveloper), snap.Incremental(), func(after string, page []*apigee.GoogleCloudApigeeV1Developer, last bool) error {
+		removedDevelopers(snap, snapshot.KindDeveloper, after, page, last)
+
+		// Percorre a pagina de developers buscando os detalhes de cada um
+		for _, developer := range page {
+			email := developer.Email
+			if !f.Developer(email) || snap.Done(snapshot.KindDeveloper, email) {
+				continue
+			}
+
+			if !unchangedDeveloper(snap, developer) {
+				developerDetails, err := service.Organizations.Developers.Get(fmt.Sprintf("organizations/%s/developers/%s", org, email)).Context(ctx).Do()
+				if err != nil {
+					return fmt.Errorf("erro ao obter a lista de developer %s: %v", email, err)
+				}
+
+				developerBackup := model.DeveloperFromApigee(developerDetails)
+
+				err = snap.WriteDeveloper(developerBackup)
+				if err != nil {
+					recordError(snap, fmt.Errorf("erro ao salvar o arquivo de backup para o developers %s: %v", email, err))
+					finished = false
+					continue
+				}
+				numDevelopers++
+			}
+			if err := snap.MarkDone(snapshot.KindDeveloper, email); err != nil {
+				return err
+			}
+		}
+
+		// O cursor so avanca enquanto todos os developers anteriores
+		// terminaram; depois de uma falha, o progresso fica so no done.
+		if !finished || len(page) == 0 {
+			return nil
+		}
+		return snap.Advance(snapshot.KindDeveloper, page[len(page)-1].Email)
+	})
+	if err != nil {
+		return numDevelopers, err
+	}
+
+	if finished {
+		snap.Finish(snapshot.KindDeveloper)
+	}
+	return numDevelopers, nil
+}
+
+// unchangedDeveloper diz se o developer listado esta igual na base do
+// snapshot incremental. Criar ou apagar um app nao muda o lastModifiedAt do
+// developer, entao a lista de apps tambem e comparada.
+func unchangedDeveloper(snap *snapshot.Writer, developer *apigee.GoogleCloudApigeeV1Developer) bool {
+	name := snap.DeveloperPath(developer.Email)
+	if !snap.Unchanged(name, developer.LastModifiedAt) {
+		return false
+	}
+
+	previous, err := snap.Base().ReadDeveloper(name)
+	if err != nil {
+		return false
+	}
+	apps := append([]string(nil), developer.Apps...)
+	sort.Strings(apps)
+	sort.Strings(previous.Apps)
+	return reflect.DeepEqual(apps, previous.Apps) || len(apps)+len(previous.Apps) == 0
+}
@@ -0,0 +1,79 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+// PageSize e quantos developers cada chamada da listagem devolve. Os testes
+// diminuem para exercitar a paginacao.
+var PageSize int64 = 1000
+
+// eachDeveloperPage lista os developers da organizacao em paginas, a partir
+// do email start (inclusive; vazio para comecar do inicio), e chama fn com
+// cada pagina, o email depois do qual ela comeca e se e a ultima. O startKey
+// da API e inclusivo: o ultimo email de uma pagina volta como o primeiro da
+// seguinte e e descartado. Com expand os developers vem com os detalhes,
+// inclusive o lastModifiedAt.
+func eachDeveloperPage(ctx context.Context, service *apigee.Service, org, start string, expand bool, fn func(after string, page []*apigee.GoogleCloudApigeeV1Developer, last bool) error) error {
+	for first := true; ; first = false {
+		call := service.Organizations.Developers.List("organizations/" + org).Count(PageSize).Context(ctx)
+		if start != "" {
+			call = call.StartKey(start)
+		}
+		if expand {
+			call = call.Expand(true)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return fmt.Errorf("erro ao obter a lista de developers: %v", err)
+		}
+
+		var page []*apigee.GoogleCloudApigeeV1Developer
+		for _, developer := range resp.Developer {
+			if !first && developer.Email == start {
+				continue
+			}
+			page = append(page, developer)
+		}
+
+		last := int64(len(resp.Developer)) < PageSize || len(page) == 0
+		if err := fn(start, page, last); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		start = page[len(page)-1].Email
+	}
+}
+
+// removedDevelopers registra como apagados, no backup incremental, os
+// developers da base que deveriam estar na pagina (depois de after e, se nao
+// e a ultima, ate o fim dela) e nao estao.
+func removedDevelopers(snap *snapshot.Writer, kind, after string, page []*apigee.GoogleCloudApigeeV1Developer, last bool) {
+	if !snap.Incremental() {
+		return
+	}
+
+	listed := map[string]bool{}
+	for _, developer := range page {
+		listed[strings.ToLower(developer.Email)] = true
+	}
+	after = strings.ToLower(after)
+	var until string
+	if len(page) > 0 {
+		until = strings.ToLower(page[len(page)-1].Email)
+	}
+
+	for _, email := range snap.BaseDevelopers(kind) {
+		if email > after && (last || email < until) && !listed[email] {
+			snap.Listed(kind, email, nil)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/snapshot"
+	"backup-restore-apigee/internal/storage"
+)
+
+// Memory faz o backup dos tipos pedidos (snapshot.KindDeveloper e
+// snapshot.KindApp) em um snapshot em memoria, sem passar pelo disco. Usado
+// pelo drift e pelo clone. Um erro em qualquer developer ou app invalida a
+// leitura: um estado incompleto pareceria uma diferenca.
+func Memory(ctx context.Context, client *apigeeclient.Client, org string, kinds map[string]bool) (*snapshot.Reader, error) {
+	mem := storage.NewMemory()
+	w, err := snapshot.CreateIn(ctx, mem, "live", org, snapshot.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	if kinds[snapshot.KindDeveloper] {
+		if _, err := Developers(ctx, client, org, w); err != nil {
+			return nil, err
+		}
+	}
+	if kinds[snapshot.KindApp] {
+		if _, err := Apps(ctx, client, org, w); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Errors) > 0 {
+		return nil, fmt.Errorf("nao foi possivel ler a organizacao %s por completo: %s", org, strings.Join(manifest.Errors, "; "))
+	}
+
+	return snapshot.OpenIn(ctx, mem, w.Key())
+}
@@ -0,0 +1,198 @@
+// Package clone copia developers, apps e chaves de uma organizacao para
+// outra sem arquivos intermediarios: a origem e lida pelo backup para um
+// snapshot em memoria e gravada no destino pelo plano do restore all.
+package clone
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+var everything = map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+
+// State e o progresso de um clone, gravado depois de cada passo para o
+// --resume. Guarda o plano e os passos feitos, nunca os secrets: na retomada
+// a origem e lida de novo.
+type State struct {
+	FromOrg string        `json:"fromOrg"`
+	ToOrg   string        `json:"toOrg"`
+	Plan    *restore.Plan `json:"plan"`
+	Done    []int         `json:"done"`
+}
+
+// Plan le as duas organizacoes e monta o plano do clone. Devolve tambem o
+// snapshot em memoria da origem, de onde o Apply le as credenciais.
+func Plan(ctx context.Context, source, target *apigeeclient.Client, fromOrg, toOrg string, opts restore.Options) (*restore.Plan, *snapshot.Reader, error) {
+	src, err := backup.Memory(ctx, source, fromOrg, everything)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := restore.CheckMapping(opts, src); err != nil {
+		return nil, nil, err
+	}
+
+	live, err := drift.Live(ctx, target, toOrg, everything)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := restore.AllPlan(toOrg, live, opts, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	// O snapshot em memoria nao existe fora deste processo.
+	plan.Snapshots = []restore.Source{{Path: "organizacao " + fromOrg}}
+	return plan, src, nil
+}
+
+// Apply executa o plano no destino. Com statePath, o progresso e gravado
+// depois de cada passo e o arquivo e apagado quando tudo termina sem erro.
+func Apply(ctx context.Context, target *apigeeclient.Client, fromOrg string, plan *restore.Plan, src *snapshot.Reader, statePath string, done map[int]bool, opts restore.Options) (*restore.Report, error) {
+	state := &State{FromOrg: fromOrg, ToOrg: plan.Organization, Plan: plan}
+	for i := range done {
+		state.Done = append(state.Done, i)
+	}
+
+	opts.Done = done
+	if statePath != "" {
+		if err := state.save(statePath); err != nil {
+			return nil, err
+		}
+		opts.Progress = func(step int, result restore.Result) error {
+			if result.Status != restore.ResultOK {
+				return nil
+			}
+			state.Done = append(state.Done, step)
+			return state.save(statePath)
+		}
+	}
+
+	report, err := restore.ApplyPlan(ctx, target, plan, []*snapshot.Reader{src}, opts)
+	if err == nil && statePath != "" {
+		if err := os.Remove(statePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	return report, err
+}
+
+// Resume retoma o clone do arquivo de estado: le as duas organizacoes de
+// novo e monta um plano novo contra o destino, que ja pula os developers,
+// apps e chaves criados antes. Assim um passo feito na API mas nao gravado
+// no estado (queda, timeout de uma criacao que deu certo) nao e repetido.
+func Resume(ctx context.Context, source, target *apigeeclient.Client, fromOrg, toOrg, statePath string, opts restore.Options) (*restore.Report, error) {
+	state, err := LoadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	if state.FromOrg != fromOrg || state.ToOrg != toOrg {
+		return nil, fmt.Errorf("o estado em %s e do clone de %s para %s, nao de %s para %s", statePath, state.FromOrg, state.ToOrg, fromOrg, toOrg)
+	}
+
+	plan, src, err := Plan(ctx, source, target, fromOrg, toOrg, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Steps = pendingDefaultKeys(state, plan.Steps)
+	return Apply(ctx, target, fromOrg, plan, src, statePath, nil, opts)
+}
+
+// pendingDefaultKeys devolve os passos do plano novo mais a remocao da chave
+// padrao dos apps que a execucao anterior criou sem chegar a apagar a chave:
+// o app ja existe, entao o plano novo nao tem mais a criacao nem a remocao.
+// Antes dessa remocao nenhuma chave do app foi importada, entao so a chave
+// padrao esta sem API products.
+func pendingDefaultKeys(state *State, steps []restore.Step) []restore.Step {
+	done := map[int]bool{}
+	for _, i := range state.Done {
+		done[i] = true
+	}
+	creating := map[string]bool{}
+	for _, step := range steps {
+		if step.Action == restore.ActionCreateApp {
+			creating[diff.AppKey(step.Developer, step.App)] = true
+		}
+	}
+
+	var pending []restore.Step
+	for i, step := range state.Plan.Steps {
+		if step.Action == restore.ActionDeleteDefaultKey && !done[i] && !creating[diff.AppKey(step.Developer, step.App)] {
+			pending = append(pending, step)
+		}
+	}
+	if len(pending) == 0 {
+		return steps
+	}
+
+	// Entram no fim da fase dos apps, antes das chaves.
+	at := 0
+	for at < len(steps) && beforeKeys(steps[at].Action) {
+		at++
+	}
+	out := append([]restore.Step(nil), steps[:at]...)
+	out = append(out, pending...)
+	return append(out, steps[at:]...)
+}
+
+// beforeKeys diz se a acao e das fases que vem antes das chaves: products,
+// developers e apps.
+func beforeKeys(action string) bool {
+	switch action {
+	case restore.ActionCheckProduct, restore.ActionCreateDeveloper, restore.ActionCreateApp, restore.ActionDeleteDefaultKey:
+		return true
+	}
+	return false
+}
+
+// LoadState le o arquivo de estado gravado pelo Apply.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("estado do clone invalido em %s: %v", path, err)
+	}
+	if state.Plan == nil {
+		return nil, fmt.Errorf("estado do clone sem plano em %s", path)
+	}
+	return &state, nil
+}
+
+// save grava o estado em um arquivo temporario e renomeia, para uma queda no
+// meio da escrita nao corromper o estado anterior.
+func (s *State) save(path string) error {
+	sort.Ints(s.Done)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,338 @@
+// Package diff compara dois estados de uma organizacao (snapshots ou o estado
+// ao vivo) e descreve o que mudou em developers e apps, campo a campo.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Tipos de mudanca.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// State e o conjunto de developers e apps de um lado da comparacao.
+type State struct {
+	// Developers pela chave DeveloperKey.
+	Developers map[string]model.DeveloperBackup
+	// Apps pela chave AppKey.
+	Apps map[string]model.AppBackup
+}
+
+// NewState devolve um State vazio.
+func NewState() *State {
+	return &State{
+		Developers: map[string]model.DeveloperBackup{},
+		Apps:       map[string]model.AppBackup{},
+	}
+}
+
+// DeveloperKey identifica um developer: o email, que a API trata sem
+// diferenciar maiusculas.
+func DeveloperKey(email string) string {
+	return strings.ToLower(email)
+}
+
+// AppKey identifica um app: nome de app so e unico por developer.
+func AppKey(developerEmail, appName string) string {
+	return DeveloperKey(developerEmail) + "/" + appName
+}
+
+// AddDeveloper inclui o developer no estado.
+func (s *State) AddDeveloper(developer model.DeveloperBackup) {
+	s.Developers[DeveloperKey(developer.Email)] = developer
+}
+
+// AddApp inclui o app no estado.
+func (s *State) AddApp(app model.AppBackup) {
+	s.Apps[AppKey(app.DeveloperID, app.Name)] = app
+}
+
+// Merge inclui no estado os developers e apps de other, como um snapshot de
+// developers e outro de apps do mesmo backup.
+func (s *State) Merge(other *State) {
+	for key, developer := range other.Developers {
+		s.Developers[key] = developer
+	}
+	for key, app := range other.Apps {
+		s.Apps[key] = app
+	}
+}
+
+// Load le os developers e apps do snapshot. Se os secrets estiverem cifrados
+// e o reader nao tiver a chave, os apps sao lidos sem decifrar e os secrets
+// ficam fora da comparacao.
+func Load(r *snapshot.Reader) (*State, error) {
+	state := NewState()
+
+	files, err := r.FilesOfKind(snapshot.KindDeveloper)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		developer, err := r.ReadDeveloper(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		state.AddDeveloper(developer)
+	}
+
+	files, err = r.FilesOfKind(snapshot.KindApp)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		app, err := r.ReadApp(name)
+		if errors.Is(err, snapshot.ErrNoKey) {
+			app, err = r.ReadAppSealed(name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		state.AddApp(app)
+	}
+
+	return state, nil
+}
+
+// Report e o resultado de Compare.
+type Report struct {
+	From       string   `json:"from"`
+	To         string   `json:"to"`
+	Developers []Change `json:"developers"`
+	Apps       []Change `json:"apps"`
+}
+
+// Change e um developer ou app adicionado, removido ou alterado.
+type Change struct {
+	// ID e o email do developer ou <email>/<app>.
+	ID     string        `json:"id"`
+	Type   string        `json:"type"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange e a mudanca de um campo de um developer ou app alterado. Field
+// usa caminhos como "attributes.tier" ou
+// "credentials[<consumerKey>].apiProducts[<product>].status". Consumer
+// secrets nunca aparecem: From e To ficam vazios.
+type FieldChange struct {
+	Field string `json:"field"`
+	Type  string `json:"type"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to,omitempty"`
+}
+
+// Empty informa se nao ha nenhuma diferenca.
+func (r *Report) Empty() bool {
+	return len(r.Developers) == 0 && len(r.Apps) == 0
+}
+
+// Counts devolve quantos recursos de cada lista foram adicionados,
+// removidos e alterados.
+func Counts(changes []Change) map[string]int {
+	counts := map[string]int{}
+	for _, c := range changes {
+		counts[c.Type]++
+	}
+	return counts
+}
+
+// Compare descreve o que mudou de a para b.
+func Compare(a, b *State) *Report {
+	report := &Report{}
+
+	for _, key := range unionKeys(a.Developers, b.Developers) {
+		before, inA := a.Developers[key]
+		after, inB := b.Developers[key]
+		if change, ok := compareResource(key, inA, inB, func() []FieldChange { return compareDevelopers(before, after) }); ok {
+			report.Developers = append(report.Developers, change)
+		}
+	}
+
+	for _, key := range unionKeys(a.Apps, b.Apps) {
+		before, inA := a.Apps[key]
+		after, inB := b.Apps[key]
+		if change, ok := compareResource(key, inA, inB, func() []FieldChange { return compareApps(before, after) }); ok {
+			report.Apps = append(report.Apps, change)
+		}
+	}
+
+	return report
+}
+
+func compareResource(id string, inA, inB bool, fields func() []FieldChange) (Change, bool) {
+	switch {
+	case !inA:
+		return Change{ID: id, Type: Added}, true
+	case !inB:
+		return Change{ID: id, Type: Removed}, true
+	}
+	changes := fields()
+	if len(changes) == 0 {
+		return Change{}, false
+	}
+	return Change{ID: id, Type: Modified, Fields: changes}, true
+}
+
+func compareDevelopers(a, b model.DeveloperBackup) []FieldChange {
+	var changes fieldChanges
+	changes.value("email", a.Email, b.Email)
+	changes.value("firstName", a.FirstName, b.FirstName)
+	changes.value("lastName", a.LastName, b.LastName)
+	changes.value("userName", a.UserName, b.UserName)
+	changes.value("status", a.Status, b.Status)
+	changes.set("apps", a.Apps, b.Apps)
+	return changes
+}
+
+func compareApps(a, b model.AppBackup) []FieldChange {
+	var changes fieldChanges
+	changes.value("status", a.Status, b.Status)
+	changes.value("appFamily", a.AppFamily, b.AppFamily)
+
+	attrsA, attrsB := attributeMap(a.Attributes), attributeMap(b.Attributes)
+	for _, name := range unionKeys(attrsA, attrsB) {
+		changes.entry("attributes."+name, attrsA, attrsB, name)
+	}
+
+	credsA, credsB := credentialMap(a.Credentials), credentialMap(b.Credentials)
+	for _, key := range unionKeys(credsA, credsB) {
+		field := "credentials[" + key + "]"
+		before, inA := credsA[key]
+		after, inB := credsB[key]
+		switch {
+		case !inA:
+			changes = append(changes, FieldChange{Field: field, Type: Added})
+		case !inB:
+			changes = append(changes, FieldChange{Field: field, Type: Removed})
+		default:
+			changes = append(changes, compareCredentials(field, before, after)...)
+		}
+	}
+
+	return changes
+}
+
+func compareCredentials(field string, a, b model.Credential) []FieldChange {
+	var changes fieldChanges
+	changes.value(field+".status", a.Status, b.Status)
+	changes.value(field+".expiresAt", strconv.FormatInt(a.ExpiresAt, 10), strconv.FormatInt(b.ExpiresAt, 10))
+	if secretChanged(a.ConsumerSecret, b.ConsumerSecret) {
+		changes = append(changes, FieldChange{Field: field + ".consumerSecret", Type: Modified})
+	}
+
+	productsA, productsB := productMap(a.APIProducts), productMap(b.APIProducts)
+	for _, name := range unionKeys(productsA, productsB) {
+		changes.entry(field+".apiProducts["+name+"]", productsA, productsB, name)
+	}
+	return changes
+}
+
+// secretChanged so compara o que da para comparar: texto claro com texto
+// claro ou com uma impressao digital. Secrets cifrados, ou duas impressoes
+// digitais (cada uma tem o seu salt), ficam de fora.
+func secretChanged(a, b string) bool {
+	switch {
+	case secrets.IsSealed(a) || secrets.IsSealed(b):
+		return false
+	case secrets.IsRedacted(a) && secrets.IsRedacted(b):
+		return false
+	case secrets.IsRedacted(a):
+		ok, err := secrets.MatchFingerprint(a, b)
+		return err == nil && !ok
+	case secrets.IsRedacted(b):
+		ok, err := secrets.MatchFingerprint(b, a)
+		return err == nil && !ok
+	}
+	return a != b
+}
+
+type fieldChanges []FieldChange
+
+func (c *fieldChanges) value(field, a, b string) {
+	if a != b {
+		*c = append(*c, FieldChange{Field: field, Type: Modified, From: a, To: b})
+	}
+}
+
+// entry compara uma entrada de mapa (atributo, product) presente em a, b ou
+// nos dois.
+func (c *fieldChanges) entry(field string, a, b map[string]string, key string) {
+	before, inA := a[key]
+	after, inB := b[key]
+	switch {
+	case !inA:
+		*c = append(*c, FieldChange{Field: field, Type: Added, To: after})
+	case !inB:
+		*c = append(*c, FieldChange{Field: field, Type: Removed, From: before})
+	case before != after:
+		*c = append(*c, FieldChange{Field: field, Type: Modified, From: before, To: after})
+	}
+}
+
+// set compara listas sem ordem, como os apps de um developer.
+func (c *fieldChanges) set(field string, a, b []string) {
+	inA, inB := map[string]string{}, map[string]string{}
+	for _, v := range a {
+		inA[v] = ""
+	}
+	for _, v := range b {
+		inB[v] = ""
+	}
+	for _, v := range unionKeys(inA, inB) {
+		if _, ok := inA[v]; !ok {
+			*c = append(*c, FieldChange{Field: field, Type: Added, To: v})
+		} else if _, ok := inB[v]; !ok {
+			*c = append(*c, FieldChange{Field: field, Type: Removed, From: v})
+		}
+	}
+}
+
+func attributeMap(attrs []model.Attribute) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		m[attr.Name] = attr.Value
+	}
+	return m
+}
+
+func credentialMap(creds []model.Credential) map[string]model.Credential {
+	m := make(map[string]model.Credential, len(creds))
+	for _, cred := range creds {
+		m[cred.ConsumerKey] = cred
+	}
+	return m
+}
+
+// productMap devolve o status da associacao de cada product.
+func productMap(products []model.APIProductRef) map[string]string {
+	m := make(map[string]string, len(products))
+	for _, product := range products {
+		m[product.APIProduct] = product.Status
+	}
+	return m
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
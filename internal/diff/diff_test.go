@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+)
+
+func states(t *testing.T) (*State, *State) {
+	t.Helper()
+
+	a, b := NewState(), NewState()
+
+	a.AddDeveloper(model.DeveloperBackup{Email: "alice@example.com", FirstName: "Alice", Status: "active", Apps: []string{"mobile", "batch"}})
+	a.AddDeveloper(model.DeveloperBackup{Email: "bob@example.com", FirstName: "Bob", Status: "active"})
+	b.AddDeveloper(model.DeveloperBackup{Email: "Alice@example.com", FirstName: "Alice", Status: "inactive", Apps: []string{"batch", "mobile", "web"}})
+	b.AddDeveloper(model.DeveloperBackup{Email: "carol@example.com", FirstName: "Carol", Status: "active"})
+
+	fingerprint, err := secrets.Fingerprint("secret-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.AddApp(model.AppBackup{
+		DeveloperID: "alice@example.com", Name: "mobile", Status: "approved",
+		Attributes: []model.Attribute{{Name: "tier", Value: "gold"}, {Name: "DisplayName", Value: "Mobile"}},
+		Credentials: []model.Credential{
+			{ConsumerKey: "key-1", ConsumerSecret: "secret-1", Status: "approved", APIProducts: []model.APIProductRef{{APIProduct: "payments", Status: "approved"}, {APIProduct: "catalog", Status: "approved"}}},
+			{ConsumerKey: "key-2", ConsumerSecret: "secret-2", Status: "approved"},
+		},
+	})
+	b.AddApp(model.AppBackup{
+		DeveloperID: "alice@example.com", Name: "mobile", Status: "approved",
+		// Ordem diferente nao e mudanca.
+		Attributes: []model.Attribute{{Name: "DisplayName", Value: "Mobile"}, {Name: "tier", Value: "silver"}, {Name: "region", Value: "br"}},
+		Credentials: []model.Credential{
+			{ConsumerKey: "key-3", ConsumerSecret: "secret-3", Status: "approved"},
+			{ConsumerKey: "key-1", ConsumerSecret: fingerprint, Status: "approved", APIProducts: []model.APIProductRef{{APIProduct: "payments", Status: "revoked"}}},
+		},
+	})
+
+	a.AddApp(model.AppBackup{DeveloperID: "bob@example.com", Name: "mobile", Status: "approved"})
+	b.AddApp(model.AppBackup{DeveloperID: "bob@example.com", Name: "mobile", Status: "revoked"})
+	a.AddApp(model.AppBackup{DeveloperID: "bob@example.com", Name: "batch"})
+	b.AddApp(model.AppBackup{DeveloperID: "carol@example.com", Name: "web"})
+
+	return a, b
+}
+
+func TestCompare(t *testing.T) {
+	a, b := states(t)
+	report := Compare(a, b)
+
+	wantDevelopers := []Change{
+		{ID: "alice@example.com", Type: Modified, Fields: []FieldChange{
+			{Field: "email", Type: Modified, From: "alice@example.com", To: "Alice@example.com"},
+			{Field: "status", Type: Modified, From: "active", To: "inactive"},
+			{Field: "apps", Type: Added, To: "web"},
+		}},
+		{ID: "bob@example.com", Type: Removed},
+		{ID: "carol@example.com", Type: Added},
+	}
+	if !reflect.DeepEqual(report.Developers, wantDevelopers) {
+		t.Errorf("developers =\n%+v\nesperado\n%+v", report.Developers, wantDevelopers)
+	}
+
+	wantApps := []Change{
+		{ID: "alice@example.com/mobile", Type: Modified, Fields: []FieldChange{
+			{Field: "attributes.region", Type: Added, To: "br"},
+			{Field: "attributes.tier", Type: Modified, From: "gold", To: "silver"},
+			{Field: "credentials[key-1].apiProducts[catalog]", Type: Removed, From: "approved"},
+			{Field: "credentials[key-1].apiProducts[payments]", Type: Modified, From: "approved", To: "revoked"},
+			{Field: "credentials[key-2]", Type: Removed},
+			{Field: "credentials[key-3]", Type: Added},
+		}},
+		{ID: "bob@example.com/batch", Type: Removed},
+		{ID: "bob@example.com/mobile", Type: Modified, Fields: []FieldChange{
+			{Field: "status", Type: Modified, From: "approved", To: "revoked"},
+		}},
+		{ID: "carol@example.com/web", Type: Added},
+	}
+	if !reflect.DeepEqual(report.Apps, wantApps) {
+		t.Errorf("apps =\n%+v\nesperado\n%+v", report.Apps, wantApps)
+	}
+
+	if !Compare(a, a).Empty() {
+		t.Error("estado comparado com ele mesmo tem diferencas")
+	}
+}
+
+func TestSecretChanged(t *testing.T) {
+	fingerprint, _ := secrets.Fingerprint("s1")
+	other, _ := secrets.Fingerprint("s1")
+
+	cases := []struct {
+		a, b string
+		want bool
+	}{
+		{"s1", "s1", false},
+		{"s1", "s2", true},
+		{fingerprint, "s1", false},
+		{"s2", fingerprint, true},
+		{fingerprint, other, false},
+		{secrets.Prefix + "abc", "s2", false},
+	}
+	for _, c := range cases {
+		if got := secretChanged(c.a, c.b); got != c.want {
+			t.Errorf("secretChanged(%q, %q) = %v", c.a, c.b, got)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	a, b := states(t)
+	report := Compare(a, b)
+	report.From, report.To = "apps_A", "apps_B"
+
+	var text bytes.Buffer
+	if err := Write(&text, report, FormatText); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Apps: 1 adicionado(s), 1 removido(s), 2 alterado(s)",
+		"~ app alice@example.com/mobile",
+		`    ~ attributes.tier: "gold" -> "silver"`,
+		"    - credentials[key-2]",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("texto sem %q:\n%s", want, text.String())
+		}
+	}
+	if strings.Contains(text.String(), "secret-") {
+		t.Errorf("texto com consumer secret:\n%s", text.String())
+	}
+
+	var md bytes.Buffer
+	Write(&md, report, FormatMarkdown)
+	if !strings.Contains(md.String(), "| alterado | `alice@example.com/mobile` | `attributes.tier` | `gold` | `silver` |") {
+		t.Errorf("markdown:\n%s", md.String())
+	}
+
+	var out bytes.Buffer
+	Write(&out, report, FormatJSON)
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, report) {
+		t.Errorf("JSON nao faz ida e volta:\n%s", out.String())
+	}
+
+	if err := Write(&out, report, "xml"); err == nil {
+		t.Error("formato desconhecido aceito")
+	}
+}
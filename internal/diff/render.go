@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formatos de saida aceitos por Write.
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// Write grava o relatorio no formato pedido.
+func Write(w io.Writer, report *Report, format string) error {
+	switch format {
+	case FormatText, "":
+		return writeText(w, report)
+	case FormatJSON:
+		return writeJSON(w, report)
+	case FormatMarkdown, "md":
+		return writeMarkdown(w, report)
+	}
+	return fmt.Errorf("formato desconhecido: %s (use %s, %s ou %s)", format, FormatText, FormatJSON, FormatMarkdown)
+}
+
+var symbols = map[string]string{Added: "+", Removed: "-", Modified: "~"}
+
+var labels = map[string]string{Added: "adicionado", Removed: "removido", Modified: "alterado"}
+
+func writeText(w io.Writer, report *Report) error {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", report.From, report.To)
+	if report.Empty() {
+		_, err := fmt.Fprintln(w, "Nenhuma diferenca.")
+		return err
+	}
+
+	for _, section := range sections(report) {
+		counts := Counts(section.changes)
+		fmt.Fprintf(w, "\n%s: %d adicionado(s), %d removido(s), %d alterado(s)\n", section.title, counts[Added], counts[Removed], counts[Modified])
+		for _, change := range section.changes {
+			fmt.Fprintf(w, "%s %s %s\n", symbols[change.Type], section.kind, change.ID)
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "    %s %s\n", symbols[field.Type], describe(field))
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, report *Report) error {
+	out := *report
+	// Listas vazias saem como [] e nao null.
+	if out.Developers == nil {
+		out.Developers = []Change{}
+	}
+	if out.Apps == nil {
+		out.Apps = []Change{}
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func writeMarkdown(w io.Writer, report *Report) error {
+	fmt.Fprintf(w, "## Diff `%s` -> `%s`\n\n", report.From, report.To)
+	if report.Empty() {
+		_, err := fmt.Fprintln(w, "Nenhuma diferenca.")
+		return err
+	}
+
+	for _, section := range sections(report) {
+		counts := Counts(section.changes)
+		fmt.Fprintf(w, "### %s\n\n", section.title)
+		fmt.Fprintf(w, "%d adicionado(s), %d removido(s), %d alterado(s)\n\n", counts[Added], counts[Removed], counts[Modified])
+		fmt.Fprintln(w, "| | Recurso | Campo | Antes | Depois |")
+		fmt.Fprintln(w, "|---|---|---|---|---|")
+		for _, change := range section.changes {
+			if len(change.Fields) == 0 {
+				fmt.Fprintf(w, "| %s | `%s` | | | |\n", labels[change.Type], markdownEscape(change.ID))
+				continue
+			}
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "| %s | `%s` | `%s` | %s | %s |\n", labels[field.Type], markdownEscape(change.ID),
+					markdownEscape(field.Field), markdownValue(field.From), markdownValue(field.To))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+type section struct {
+	title   string
+	kind    string
+	changes []Change
+}
+
+func sections(report *Report) []section {
+	var out []section
+	if len(report.Developers) > 0 {
+		out = append(out, section{"Developers", "developer", report.Developers})
+	}
+	if len(report.Apps) > 0 {
+		out = append(out, section{"Apps", "app", report.Apps})
+	}
+	return out
+}
+
+func describe(field FieldChange) string {
+	switch {
+	case field.Type == Added && field.To != "":
+		return fmt.Sprintf("%s: %q", field.Field, field.To)
+	case field.Type == Removed && field.From != "":
+		return fmt.Sprintf("%s: %q", field.Field, field.From)
+	case field.Type == Modified && field.From == "" && field.To == "":
+		return field.Field + " alterado"
+	case field.Type == Modified:
+		return fmt.Sprintf("%s: %q -> %q", field.Field, field.From, field.To)
+	}
+	return field.Field
+}
+
+func markdownValue(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "`" + markdownEscape(v) + "`"
+}
+
+func markdownEscape(v string) string {
+	return strings.NewReplacer("|", `\|`, "`", "'", "\n", " ").Replace(v)
+}
@@ -0,0 +1,124 @@
+// Package drift compara um snapshot (por exemplo um snapshot "golden"
+// versionado no git) com o estado atual da organizacao, para pegar mudancas
+// feitas por fora, como edicoes no console.
+package drift
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Check compara os snapshots com a organizacao. So os tipos de recurso
+// presentes nos snapshots sao comparados: um snapshot so de apps nao acusa
+// todos os developers como extras. No relatorio, From e o snapshot e To e a
+// organizacao: Added e o que existe so na organizacao e Removed o que falta
+// nela. Um snapshot feito com filtro so e comparado com a parte da
+// organizacao que passa no filtro.
+func Check(ctx context.Context, client *apigeeclient.Client, org string, snaps []*snapshot.Reader) (*diff.Report, error) {
+	want := diff.NewState()
+	kinds := map[string]bool{}
+	var filters []*filter.Filter
+	for _, r := range snaps {
+		state, err := diff.Load(r)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler o snapshot %s: %v", r.Path(), err)
+		}
+		want.Merge(state)
+
+		for _, kind := range Kinds(r) {
+			kinds[kind] = true
+		}
+		filters = append(filters, snapshotFilter(r))
+	}
+
+	live, err := Live(ctx, client, org, kinds)
+	if err != nil {
+		return nil, err
+	}
+	live = selected(live, filters)
+
+	report := diff.Compare(want, live)
+	var paths []string
+	for _, r := range snaps {
+		paths = append(paths, r.Path())
+	}
+	report.From = strings.Join(paths, ", ")
+	report.To = "organizacao " + org
+	return report, nil
+}
+
+// Kinds devolve os tipos de recurso do snapshot: os do manifesto e, nos
+// snapshots sem contagem no manifesto, os que tem arquivos.
+func Kinds(r *snapshot.Reader) []string {
+	var kinds []string
+	if manifest, err := r.Manifest(); err == nil {
+		for kind := range manifest.Counts {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		for _, kind := range []string{snapshot.KindDeveloper, snapshot.KindApp} {
+			if files, _ := r.FilesOfKind(kind); len(files) > 0 {
+				kinds = append(kinds, kind)
+			}
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// snapshotFilter devolve o filtro gravado no manifesto do snapshot, ou nil.
+func snapshotFilter(r *snapshot.Reader) *filter.Filter {
+	manifest, err := r.Manifest()
+	if err != nil {
+		return nil
+	}
+	return manifest.Filter
+}
+
+// selected devolve so os developers e apps do estado que passam em algum dos
+// filtros. Um snapshot sem filtro cobre a organizacao inteira.
+func selected(state *diff.State, filters []*filter.Filter) *diff.State {
+	for _, f := range filters {
+		if f.Empty() {
+			return state
+		}
+	}
+
+	out := diff.NewState()
+	for _, developer := range state.Developers {
+		for _, f := range filters {
+			if f.Developer(developer.Email) {
+				out.AddDeveloper(developer)
+				break
+			}
+		}
+	}
+	for _, app := range state.Apps {
+		for _, f := range filters {
+			if f.App(app) {
+				out.AddApp(app)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Live le o estado atual da organizacao pelo mesmo caminho do backup
+// (backup.Memory).
+func Live(ctx context.Context, client *apigeeclient.Client, org string, kinds map[string]bool) (*diff.State, error) {
+	r, err := backup.Memory(ctx, client, org, kinds)
+	if err != nil {
+		return nil, err
+	}
+	return diff.Load(r)
+}
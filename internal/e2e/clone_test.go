@@ -0,0 +1,164 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/clone"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestCloneWithResume(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	// Cada lado com o seu cliente, como com service accounts diferentes.
+	source, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dest = "clone-org"
+	// Sem o product payments o clone nao termina.
+	fake.AddProduct(dest, "catalog")
+
+	statePath := filepath.Join(t.TempDir(), "clone.json")
+	opts := restore.Options{Policy: restore.PolicyContinue}
+
+	plan, src, err := clone.Plan(ctx, source, target, org, dest, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := clone.Apply(ctx, target, org, plan, src, statePath, nil, opts)
+	if err == nil {
+		t.Fatal("clone sem o product payments nao falhou")
+	}
+
+	state, err := clone.LoadState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Done) != report.Count(restore.ResultOK) || len(state.Done) == 0 || len(state.Done) == len(plan.Steps) {
+		t.Errorf("estado com %d passos feitos, relatorio com %d ok de %d", len(state.Done), report.Count(restore.ResultOK), len(plan.Steps))
+	}
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Error("o estado do clone tem consumer secrets")
+	}
+
+	fake.AddProduct(dest, "payments")
+
+	report, err = clone.Resume(ctx, source, target, org, dest, statePath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) == 0 || report.Count(restore.ResultOK) != len(report.Results) {
+		t.Errorf("%d de %d passos ok depois do resume", report.Count(restore.ResultOK), len(report.Results))
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("o estado nao foi apagado no final: %v", err)
+	}
+
+	assertCloned(t, source, target, dest)
+}
+
+// assertCloned confere que o destino ficou igual a origem.
+func assertCloned(t *testing.T, source, target *apigeeclient.Client, dest string) {
+	t.Helper()
+
+	ctx := context.Background()
+	everything := map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+	from, err := drift.Live(ctx, source, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := drift.Live(ctx, target, dest, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gaps := restoreGaps(diff.Compare(from, to)); len(gaps) > 0 {
+		t.Errorf("destino difere da origem: %v", gaps)
+	}
+}
+
+func TestCloneResumeAfterUnsavedSteps(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	source, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const dest = "clone-org"
+	fake.AddProduct(dest, "catalog")
+	fake.AddProduct(dest, "payments")
+
+	opts := restore.Options{Policy: restore.PolicyContinue}
+	plan, _, err := clone.Plan(ctx, source, target, org, dest, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A execucao anterior criou a alice e o app mobile, com a chave padrao,
+	// e caiu antes de gravar esses passos no estado.
+	if err := fake.AddDeveloper(dest, apigee.GoogleCloudApigeeV1Developer{Email: "alice@example.com", FirstName: "Alice", LastName: "Silva", UserName: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	app := apigee.GoogleCloudApigeeV1DeveloperApp{
+		Name:        "mobile",
+		Attributes:  []*apigee.GoogleCloudApigeeV1Attribute{{Name: "DisplayName", Value: "Mobile"}, {Name: "tier", Value: "gold"}},
+		Credentials: []*apigee.GoogleCloudApigeeV1Credential{credential("default-key", "default-secret")},
+	}
+	if err := fake.AddApp(dest, "alice@example.com", app); err != nil {
+		t.Fatal(err)
+	}
+
+	statePath := filepath.Join(t.TempDir(), "clone.json")
+	data, err := json.Marshal(clone.State{FromOrg: org, ToOrg: dest, Plan: plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(statePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := clone.Resume(ctx, source, target, org, dest, statePath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range report.Results {
+		if result.Status != restore.ResultOK {
+			t.Errorf("%s %s/%s: %s %s", result.Step.Action, result.Step.Developer, result.Step.App, result.Status, result.Error)
+		}
+	}
+	assertCloned(t, source, target, dest)
+}
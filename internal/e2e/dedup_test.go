@@ -0,0 +1,121 @@
+package e2e
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/snapshot"
+	"backup-restore-apigee/internal/storage"
+)
+
+func TestDedupStore(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	objects := func() []string {
+		t.Helper()
+		keys, err := storage.Local{}.List(ctx, filepath.ToSlash(root)+"/"+snapshot.ObjectsDir+"/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+
+	first := runBackup(t, ctx, client, root, snapshot.Options{Dedup: true})
+	stored := len(objects())
+	if stored != 6 {
+		t.Fatalf("objetos = %d, esperado 6 (2 developers e 4 apps)", stored)
+	}
+
+	// A segunda noite, sem mudancas, nao grava nenhum documento novo: os
+	// snapshots so tem o manifesto.
+	second := runBackup(t, ctx, client, root, snapshot.Options{Dedup: true})
+	if n := len(objects()); n != stored {
+		t.Errorf("objetos depois do segundo backup = %d, esperado %d", n, stored)
+	}
+	entries, err := os.ReadDir(filepath.FromSlash(strings.TrimPrefix(second.appSnap.Path(), "file://")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != snapshot.ManifestFile {
+		t.Errorf("o snapshot deduplicado tem %v", entries)
+	}
+
+	// diff le o formato sem saber dele.
+	var states [2]*diff.State
+	for i, r := range []backupResult{first, second} {
+		if states[i], err = diff.Load(r.developerSnap); err != nil {
+			t.Fatal(err)
+		}
+		apps, err := diff.Load(r.appSnap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states[i].Merge(apps)
+	}
+	if report := diff.Compare(states[0], states[1]); !report.Empty() {
+		t.Errorf("diff entre noites iguais: %+v", report)
+	}
+
+	// O prune apaga o primeiro snapshot e os objetos continuam, usados
+	// pelo segundo; sem nenhum snapshot, a coleta apaga todos.
+	deleteSnapshots(t, ctx, root, first)
+	removed, err := snapshot.CollectGarbage(ctx, storage.Join(root, "apps"))
+	if err != nil || removed != 0 {
+		t.Errorf("coleta com o segundo snapshot ainda la: %d, %v", removed, err)
+	}
+	problems, err := snapshot.Verify(ctx, second.appSnap.Path(), snapshot.VerifyOptions{})
+	if err != nil || len(problems) > 0 {
+		t.Errorf("verify depois do prune: %v %v", err, problems)
+	}
+
+	// Um objeto corrompido aparece no verify.
+	keys := objects()
+	if err := os.WriteFile(filepath.FromSlash(keys[0]), []byte("corrompido"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	problems, _ = snapshot.Verify(ctx, second.developerSnap.Path(), snapshot.VerifyOptions{})
+	problems2, _ := snapshot.Verify(ctx, second.appSnap.Path(), snapshot.VerifyOptions{})
+	if len(problems)+len(problems2) == 0 {
+		t.Error("o verify nao viu o objeto corrompido")
+	}
+
+	deleteSnapshots(t, ctx, root, second)
+	removed, err = snapshot.CollectGarbage(ctx, storage.Join(root, "apps"))
+	if err != nil || removed != stored || len(objects()) != 0 {
+		t.Errorf("coleta sem snapshots: apagou %d de %d, %v", removed, stored, err)
+	}
+}
+
+// deleteSnapshots apaga os dois snapshots de r, como o prune.
+func deleteSnapshots(t *testing.T, ctx context.Context, root string, r backupResult) {
+	t.Helper()
+	for base, path := range map[string]string{"developers": r.developerSnap.Path(), "apps": r.appSnap.Path()} {
+		snaps, err := snapshot.List(ctx, storage.Join(root, base))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, snap := range snaps {
+			if snap.Path != path {
+				continue
+			}
+			if err := snapshot.Delete(ctx, snap); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestDiffBetweenNightlyBackups(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cifrado e sem a chave na leitura: o diff tem que funcionar mesmo assim.
+	kms, err := secrets.NewLocalKey(bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms})
+
+	err = fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: "carol@example.com", FirstName: "Carol", LastName: "Lima", UserName: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Service.Organizations.Developers.Apps.GenerateKeyPairOrUpdateDeveloperAppStatus(
+		"organizations/"+org+"/developers/bob@example.com/apps/web", &apigee.GoogleCloudApigeeV1DeveloperApp{}).Action("revoke").Context(ctx).Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms})
+
+	load := func(r *snapshot.Reader) *diff.State {
+		locked, err := snapshot.Open(ctx, r.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := diff.Load(locked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	a, b := load(before.developerSnap), load(after.developerSnap)
+	for key, app := range load(before.appSnap).Apps {
+		a.Apps[key] = app
+	}
+	for key, app := range load(after.appSnap).Apps {
+		b.Apps[key] = app
+	}
+
+	report := diff.Compare(a, b)
+	if len(report.Developers) != 1 || report.Developers[0].ID != "carol@example.com" || report.Developers[0].Type != diff.Added {
+		t.Errorf("developers = %+v", report.Developers)
+	}
+	if len(report.Apps) != 1 || report.Apps[0].ID != "bob@example.com/web" {
+		t.Fatalf("apps = %+v", report.Apps)
+	}
+	if fields := report.Apps[0].Fields; len(fields) != 1 || fields[0].Field != "status" || fields[0].To != "revoked" {
+		t.Errorf("mudancas do app = %+v", fields)
+	}
+}
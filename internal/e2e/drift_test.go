@@ -0,0 +1,85 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestDriftAgainstGoldenSnapshot(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{golden.developerSnap, golden.appSnap}
+
+	report, err := drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Empty() {
+		t.Fatalf("drift logo apos o backup: %+v", report)
+	}
+
+	// Mudancas feitas por fora: developer novo, chave apagada e produto
+	// desassociado de uma chave.
+	err = fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: "carol@example.com", FirstName: "Carol", LastName: "Lima", UserName: "carol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := client.Service.Organizations.Developers.Apps
+	if _, err := apps.Keys.Delete("organizations/" + org + "/developers/bob@example.com/apps/web/keys/bob-key-2").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := apps.Keys.Apiproducts.Delete("organizations/" + org + "/developers/alice@example.com/apps/mobile/keys/alice-key-1/apiproducts/catalog").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Developers) != 1 || report.Developers[0].ID != "carol@example.com" || report.Developers[0].Type != diff.Added {
+		t.Errorf("developers = %+v", report.Developers)
+	}
+
+	fields := map[string]diff.FieldChange{}
+	for _, change := range report.Apps {
+		for _, field := range change.Fields {
+			fields[change.ID+" "+field.Field] = field
+		}
+	}
+	if len(fields) != 2 {
+		t.Errorf("apps = %+v", report.Apps)
+	}
+	if f, ok := fields["bob@example.com/web credentials[bob-key-2]"]; !ok || f.Type != diff.Removed {
+		t.Errorf("chave apagada nao apareceu: %+v", fields)
+	}
+	if f, ok := fields["alice@example.com/mobile credentials[alice-key-1].apiProducts[catalog]"]; !ok || f.Type != diff.Removed {
+		t.Errorf("produto desassociado nao apareceu: %+v", fields)
+	}
+
+	// So com o snapshot de apps o developer novo nao conta como drift.
+	report, err = drift.Check(ctx, client, org, []*snapshot.Reader{golden.appSnap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Developers) != 0 || len(report.Apps) != 2 {
+		t.Errorf("so apps: developers = %+v, apps = %+v", report.Developers, report.Apps)
+	}
+}
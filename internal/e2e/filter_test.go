@@ -0,0 +1,91 @@
+package e2e
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func TestFilteredBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Backup so de um parceiro: os developers bob@* sem o app mobile.
+	f := &filter.Filter{Developers: []string{"bob@*"}, ExcludeApps: []string{"^mobile$"}}
+	partial := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{Filter: f})
+
+	if len(partial.developers) != 1 || partial.developers[0].Email != "bob@example.com" {
+		t.Errorf("developers = %+v", partial.developers)
+	}
+	if len(partial.apps) != 1 || partial.apps[0].Name != "web" {
+		t.Errorf("apps = %+v", partial.apps)
+	}
+	manifest, err := partial.appSnap.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest.Filter.ExcludeApps, f.ExcludeApps) {
+		t.Errorf("filtro no manifesto = %+v", manifest.Filter)
+	}
+
+	// O drift so olha a parte da organizacao que passa no filtro.
+	report, err := drift.Check(ctx, client, org, []*snapshot.Reader{partial.developerSnap, partial.appSnap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Empty() {
+		t.Errorf("drift de um snapshot filtrado: %+v", report)
+	}
+
+	// Restore filtrado de um backup completo: so a alice e os apps dela com
+	// o product payments.
+	full := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{full.developerSnap, full.appSnap}
+	fake.Wipe(org)
+
+	everything := map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+	live, err := drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := restore.Options{Filter: &filter.Filter{ExcludeDevelopers: []string{"bob@*"}, Products: []string{"payments"}}}
+	plan, err := restore.AllPlan(org, live, opts, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restore.ApplyPlan(ctx, client, plan, snaps, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err = drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var developers, apps []string
+	for key := range live.Developers {
+		developers = append(developers, key)
+	}
+	for key := range live.Apps {
+		apps = append(apps, key)
+	}
+	if !reflect.DeepEqual(developers, []string{"alice@example.com"}) {
+		t.Errorf("developers restaurados = %v", developers)
+	}
+	if !reflect.DeepEqual(apps, []string{"alice@example.com/mobile"}) {
+		t.Errorf("apps restaurados = %v", apps)
+	}
+}
@@ -0,0 +1,146 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/snapshot"
+	"backup-restore-apigee/internal/storage"
+)
+
+func TestFormatsAndConvert(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	original := runBackup(t, ctx, client, root, snapshot.Options{Format: snapshot.FormatNDJSON})
+
+	// Um arquivo por tipo, um documento por linha.
+	for _, c := range []struct {
+		snap  *snapshot.Reader
+		kind  string
+		lines int
+	}{{original.developerSnap, snapshot.KindDeveloper, 2}, {original.appSnap, snapshot.KindApp, 4}} {
+		dir := strings.TrimPrefix(c.snap.Path(), "file://")
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		if want := []string{snapshot.StreamPath(c.kind), snapshot.ManifestFile}; !reflect.DeepEqual(names, want) {
+			t.Errorf("%s tem %v, esperado %v", dir, names, want)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, snapshot.StreamPath(c.kind)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(data, []byte("\n")); n != c.lines {
+			t.Errorf("%s tem %d linhas, esperado %d", snapshot.StreamPath(c.kind), n, c.lines)
+		}
+	}
+
+	want := loadState(t, original)
+
+	// ndjson -> yaml -> json -> ndjson: o conteudo nao muda e, como os
+	// documentos sao canonicos, o ndjson final tem as mesmas linhas do
+	// original (a ordem e a da gravacao).
+	snaps := original
+	for _, format := range []string{snapshot.FormatYAML, snapshot.FormatJSON, snapshot.FormatNDJSON} {
+		var next backupResult
+		for i, r := range []*snapshot.Reader{snaps.developerSnap, snaps.appSnap} {
+			base := []string{"developers", "apps"}[i]
+			w, err := snapshot.Create(ctx, storage.Join(root, format, base), org, snapshot.Options{Format: format})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := snapshot.Materialize(r, w); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if problems, err := snapshot.Verify(ctx, w.Path(), snapshot.VerifyOptions{}); err != nil || len(problems) > 0 {
+				t.Fatalf("%s: %v %v", w.Path(), err, problems)
+			}
+			converted, err := snapshot.Open(ctx, w.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				next.developerSnap = converted
+			} else {
+				next.appSnap = converted
+			}
+		}
+
+		if report := diff.Compare(want, loadState(t, next)); !report.Empty() {
+			t.Errorf("a conversao para %s mudou o conteudo: %+v", format, report)
+		}
+		files, _ := next.appSnap.FilesOfKind(snapshot.KindApp)
+		if format != snapshot.FormatNDJSON && (len(files) != 4 || filepath.Ext(files[0]) != "."+format) {
+			t.Errorf("apps no formato %s: %v", format, files)
+		}
+		snaps = next
+	}
+
+	for _, kind := range []string{snapshot.KindDeveloper, snapshot.KindApp} {
+		r := original.developerSnap
+		converted := snaps.developerSnap
+		if kind == snapshot.KindApp {
+			r, converted = original.appSnap, snaps.appSnap
+		}
+		before := sortedLines(streamFile(t, r, kind))
+		after := sortedLines(streamFile(t, converted, kind))
+		if !bytes.Equal(before, after) {
+			t.Errorf("%s mudou na volta ao ndjson:\n%s\n%s", snapshot.StreamPath(kind), before, after)
+		}
+	}
+}
+
+func loadState(t *testing.T, r backupResult) *diff.State {
+	t.Helper()
+	state, err := diff.Load(r.developerSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := diff.Load(r.appSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Merge(apps)
+	return state
+}
+
+func streamFile(t *testing.T, r *snapshot.Reader, kind string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(strings.TrimPrefix(r.Path(), "file://"), snapshot.StreamPath(kind)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func sortedLines(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	sort.Slice(lines, func(i, j int) bool { return bytes.Compare(lines[i], lines[j]) < 0 })
+	return bytes.Join(lines, nil)
+}
@@ -0,0 +1,179 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/history"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func TestGitHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git nao esta instalado")
+	}
+
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kms, err := secrets.NewLocalKey(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := t.TempDir()
+	gitRun(t, remote, "init", "-q", "--bare")
+	repo := t.TempDir()
+	gitRun(t, repo, "init", "-q")
+	gitRun(t, repo, "config", "user.name", "Backup")
+	gitRun(t, repo, "config", "user.email", "backup@example.com")
+	gitRun(t, repo, "remote", "add", "origin", remote)
+	opts := history.Options{Dir: repo, KMS: kms, Remote: "origin"}
+
+	commit := func(r backupResult) []*history.Result {
+		t.Helper()
+		var results []*history.Result
+		for _, snap := range []*snapshot.Reader{r.developerSnap, r.appSnap} {
+			result, err := history.Commit(ctx, snap, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, result)
+		}
+		return results
+	}
+
+	// Secrets em texto claro nao vao para o git.
+	plain := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	if _, err := history.Commit(ctx, plain.appSnap, opts); err == nil || !strings.Contains(err.Error(), "texto claro") {
+		t.Fatalf("snapshot em texto claro aceito: %v", err)
+	}
+
+	first := commit(runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms}))
+	if first[0].Commit == "" || first[1].Commit == "" {
+		t.Fatalf("o primeiro backup nao gerou commits: %+v", first)
+	}
+	files := strings.Fields(gitRun(t, repo, "ls-files"))
+	want := []string{
+		org + "/apps/alice@example.com/batch.yaml",
+		org + "/apps/alice@example.com/mobile.yaml",
+		org + "/apps/bob@example.com/mobile.yaml",
+		org + "/apps/bob@example.com/web.yaml",
+		org + "/developers/alice@example.com.json",
+		org + "/developers/bob@example.com.json",
+		org + "/" + snapshot.ManifestFile,
+	}
+	if strings.Join(files, "\n") != strings.Join(want, "\n") {
+		t.Errorf("arquivos no git:\n%s\nesperado:\n%s", strings.Join(files, "\n"), strings.Join(want, "\n"))
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(repo, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("-secret-")) {
+			t.Errorf("%s tem consumer secret em texto claro", file)
+		}
+	}
+
+	// Um backup novo sem mudancas, com outra chave de snapshot, nao muda
+	// nenhum arquivo.
+	again := commit(runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms}))
+	if again[0].Commit != "" || again[1].Commit != "" {
+		t.Errorf("backup sem mudancas gerou commit: %+v", again)
+	}
+
+	// So o developer alterado muda, e a mensagem resume a mudanca.
+	developers := client.Service.Organizations.Developers
+	if _, err := developers.SetDeveloperStatus("organizations/" + org + "/developers/bob@example.com").Action("inactive").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	changed := commit(runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms}))
+	if changed[0].Commit == "" || changed[1].Commit != "" {
+		t.Fatalf("commits depois de mudar o bob: %+v", changed)
+	}
+	if got := strings.Fields(gitRun(t, repo, "diff", "--name-only", "HEAD~1", "HEAD")); strings.Join(got, " ") != org+"/developers/bob@example.com.json "+org+"/"+snapshot.ManifestFile {
+		t.Errorf("arquivos alterados no ultimo commit: %s", got)
+	}
+	message := gitRun(t, repo, "log", "-1", "--format=%B")
+	if !strings.Contains(message, "developers +0 -0 ~1") || !strings.Contains(message, "~ developer bob@example.com") {
+		t.Errorf("mensagem do commit:\n%s", message)
+	}
+
+	if got := gitRun(t, remote, "rev-parse", "HEAD"); strings.TrimSpace(got) != changed[0].Commit {
+		t.Errorf("o remote esta em %s, esperado %s", got, changed[0].Commit)
+	}
+	if got := strings.TrimSpace(gitRun(t, repo, "rev-list", "--count", "HEAD")); got != "3" {
+		t.Errorf("commits no repositorio: %s, esperado 3", got)
+	}
+
+	// <repo>/<org> e um snapshot: verify, drift e restore leem direto, com a
+	// chave do repositorio que esta no manifesto.
+	tracked, err := snapshot.Open(ctx, filepath.Join(repo, org))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked.SetKMS(kms)
+	if problems, err := snapshot.Verify(ctx, tracked.Path(), snapshot.VerifyOptions{}); err != nil || len(problems) > 0 {
+		t.Errorf("verify do repositorio: %v %v", err, problems)
+	}
+	snaps := []*snapshot.Reader{tracked}
+	report, err := drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Empty() {
+		t.Errorf("drift contra o repositorio: %+v", report)
+	}
+
+	fake.Wipe(org)
+	everything := map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+	live, err := drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := restore.AllPlan(org, live, restore.Options{}, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restore.ApplyPlan(ctx, client, plan, snaps, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gaps := restoreGaps(report); len(gaps) > 0 {
+		t.Errorf("organizacao difere do repositorio depois do restore: %v", gaps)
+	}
+	if got := fake.App(org, "bob@example.com", "web"); got == nil || len(got.Credentials) != 2 || got.Credentials[0].ConsumerSecret != "bob-secret-1" {
+		t.Errorf("app web restaurado do repositorio = %+v", got)
+	}
+}
+
+func gitRun(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
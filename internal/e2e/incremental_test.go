@@ -0,0 +1,229 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+	"backup-restore-apigee/internal/storage"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestIncrementalBackup(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	// Relogio que anda a cada chamada, para toda alteracao mudar o
+	// lastModifiedAt.
+	clock := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	fake.Now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	seed(t, fake)
+	if err := fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: "dave@example.com", FirstName: "Dave", LastName: "Lima", UserName: "dave"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddApp(org, "dave@example.com", apigee.GoogleCloudApigeeV1DeveloperApp{Name: "old", Credentials: []*apigee.GoogleCloudApigeeV1Credential{credential("dave-key", "dave-secret", "catalog")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	runBackup(t, ctx, client, root, snapshot.Options{})
+
+	// Mudancas depois do backup completo: bob inativo, app web com outro
+	// atributo, app batch da alice e o developer dave apagados e um
+	// developer novo.
+	developers := client.Service.Organizations.Developers
+	prefix := "organizations/" + org + "/developers/"
+	if _, err := developers.SetDeveloperStatus(prefix + "bob@example.com").Action("inactive").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	web := &apigee.GoogleCloudApigeeV1DeveloperApp{Name: "web", Attributes: []*apigee.GoogleCloudApigeeV1Attribute{{Name: "DisplayName", Value: "Web 2"}}}
+	if _, err := developers.Apps.Update(prefix+"bob@example.com/apps/web", web).Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Apps.Delete(prefix + "alice@example.com/apps/batch").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Delete(prefix + "dave@example.com").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: "carol@example.com", FirstName: "Carol", LastName: "Reis", UserName: "carol"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.AddApp(org, "carol@example.com", apigee.GoogleCloudApigeeV1DeveloperApp{Name: "new", Credentials: []*apigee.GoogleCloudApigeeV1Credential{credential("carol-key", "carol-secret", "payments")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	gets := map[string]int{}
+	fake.Fault = func(r *http.Request) int {
+		if r.Method == http.MethodGet {
+			gets[r.URL.Path]++
+		}
+		return 0
+	}
+
+	incremental := func() (*snapshot.Manifest, *snapshot.Manifest) {
+		t.Helper()
+		devSnap, err := snapshot.Create(ctx, storage.Join(root, "developers"), org, snapshot.Options{Incremental: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		appSnap, err := snapshot.Create(ctx, storage.Join(root, "apps"), org, snapshot.Options{Incremental: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backup.Developers(ctx, client, org, devSnap); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backup.Apps(ctx, client, org, appSnap); err != nil {
+			t.Fatal(err)
+		}
+		devManifest, err := devSnap.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		appManifest, err := appSnap.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range []*snapshot.Writer{devSnap, appSnap} {
+			problems, err := snapshot.Verify(ctx, w.Path(), snapshot.VerifyOptions{})
+			if err != nil || len(problems) > 0 {
+				t.Fatalf("snapshot %s invalido: %v %v", w.Path(), err, problems)
+			}
+		}
+		return devManifest, appManifest
+	}
+
+	devManifest, appManifest := incremental()
+
+	if got := manifestPaths(devManifest); !reflect.DeepEqual(got, []string{"alice@example.com.json", "bob@example.com.json", "carol@example.com.json"}) {
+		t.Errorf("developers gravados = %v", got)
+	}
+	if got := devManifest.Incremental.Deleted; !reflect.DeepEqual(got, []string{"dave@example.com.json"}) {
+		t.Errorf("developers apagados = %v", got)
+	}
+	if got := manifestPaths(appManifest); !reflect.DeepEqual(got, []string{"apps/bob@example.com/web.yaml", "apps/carol@example.com/new.yaml"}) {
+		t.Errorf("apps gravados = %v", got)
+	}
+	if got := appManifest.Incremental.Deleted; !reflect.DeepEqual(got, []string{"apps/alice@example.com/batch.yaml", "apps/dave@example.com/old.yaml"}) {
+		t.Errorf("apps apagados = %v", got)
+	}
+	for _, path := range []string{"alice@example.com/apps/mobile", "bob@example.com/apps/mobile"} {
+		if n := gets["/v1/organizations/"+org+"/developers/"+path]; n != 0 {
+			t.Errorf("o app sem mudanca %s foi buscado %d vez(es)", path, n)
+		}
+	}
+
+	// A visao do incremental (base + incremento) e igual a um backup
+	// completo feito agora.
+	full := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	latest := func(base string) *snapshot.Reader {
+		t.Helper()
+		entry, err := snapshot.AsOf(ctx, storage.Join(root, base), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := snapshot.Open(ctx, entry.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	compareView(t, latest("developers"), latest("apps"), full)
+
+	// Um segundo incremental sem mudancas nao grava nada e a visao continua
+	// a mesma, agora com dois niveis.
+	devManifest, appManifest = incremental()
+	if len(devManifest.Files)+len(appManifest.Files)+len(devManifest.Incremental.Deleted)+len(appManifest.Incremental.Deleted) != 0 {
+		t.Errorf("incremental sem mudancas gravou %v %v", manifestPaths(devManifest), manifestPaths(appManifest))
+	}
+	compareView(t, latest("developers"), latest("apps"), full)
+
+	// A visao materializada e um snapshot completo.
+	w, err := snapshot.Create(ctx, storage.Join(t.TempDir(), "apps"), org, snapshot.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Materialize(latest("apps"), w); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Incremental != nil || manifest.Counts[snapshot.KindApp] != 4 {
+		t.Errorf("materializado: %+v", manifest)
+	}
+	materialized, err := snapshot.Open(ctx, w.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareView(t, latest("developers"), materialized, full)
+}
+
+func manifestPaths(manifest *snapshot.Manifest) []string {
+	var paths []string
+	for _, f := range manifest.Files {
+		paths = append(paths, f.Path)
+	}
+	return paths
+}
+
+func compareView(t *testing.T, devSnap, appSnap *snapshot.Reader, full backupResult) {
+	t.Helper()
+
+	var developers []model.DeveloperBackup
+	files, err := devSnap.FilesOfKind(snapshot.KindDeveloper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		doc, err := devSnap.ReadDeveloper(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		developers = append(developers, doc)
+	}
+
+	var apps []model.AppBackup
+	files, err = appSnap.FilesOfKind(snapshot.KindApp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		doc, err := appSnap.ReadApp(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, doc)
+	}
+
+	if got, want := normalizeDevelopers(developers), normalizeDevelopers(full.developers); !reflect.DeepEqual(got, want) {
+		t.Errorf("developers da visao:\n%+v\nbackup completo:\n%+v", got, want)
+	}
+	if got, want := normalizeApps(apps), normalizeApps(full.apps); !reflect.DeepEqual(got, want) {
+		var names []string
+		for name := range got {
+			names = append(names, name)
+		}
+		t.Errorf("apps da visao %s difere do backup completo", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,176 @@
+package e2e
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func TestFailedAppIsRolledBack(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	fake.Wipe(org)
+
+	var alice model.DeveloperBackup
+	for _, developer := range result.developers {
+		if developer.Email == "alice@example.com" {
+			alice = developer
+		}
+	}
+	if err := restore.Developer(ctx, client, org, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// A segunda chave usa um API product que nao existe: a associacao falha
+	// depois do app criado, da chave padrao apagada e da primeira chave
+	// importada.
+	var app model.AppBackup
+	for _, doc := range result.apps {
+		if doc.DeveloperID == "alice@example.com" && doc.Name == "mobile" {
+			app = doc
+		}
+	}
+	app.Credentials = append(app.Credentials, model.Credential{
+		ConsumerKey:    "alice-key-9",
+		ConsumerSecret: "alice-secret-9",
+		APIProducts:    []model.APIProductRef{{APIProduct: "missing", Status: "approved"}},
+	})
+
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := restore.CreateJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restore.App(ctx, client, org, app, restore.Options{Journal: journal})
+	if err == nil || !strings.Contains(err.Error(), "alice-key-9") {
+		t.Fatalf("erro = %v", err)
+	}
+	journal.Close()
+
+	if fake.App(org, "alice@example.com", "mobile") != nil {
+		t.Error("o app que falhou no meio ficou na organizacao")
+	}
+	if developers := fake.Developers(org); len(developers) != 1 {
+		t.Errorf("developers = %v", developers)
+	}
+
+	// O journal guarda o que foi feito e o que foi desfeito.
+	reopened, err := restore.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if pending := reopened.Pending(); len(pending) != 0 {
+		t.Errorf("pendentes depois do rollback = %+v", pending)
+	}
+}
+
+func TestRollbackJournal(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	developers := client.Service.Organizations.Developers
+	prefix := "organizations/" + org + "/developers/"
+	if _, err := developers.SetDeveloperStatus(prefix + "bob@example.com").Action("inactive").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	golden := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{golden.developerSnap, golden.appSnap}
+
+	// A organizacao muda depois do backup: bob volta a ativo e o app batch
+	// some. O restore recria o app e volta o status do bob.
+	if _, err := developers.SetDeveloperStatus(prefix + "bob@example.com").Action("active").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Apps.Delete(prefix + "alice@example.com/apps/batch").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	everything := map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+	before, err := drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := restore.CreateJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := restore.Options{Journal: journal}
+	plan, err := restore.AllPlan(org, before, opts, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restore.ApplyPlan(ctx, client, plan, snaps, opts); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	if fake.App(org, "alice@example.com", "batch") == nil {
+		t.Fatal("o restore nao recriou o app batch")
+	}
+
+	// rollback <journal>
+	reopened, err := restore.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]bool{}
+	for _, entry := range reopened.Pending() {
+		actions[entry.Action] = true
+	}
+	for _, action := range []string{restore.ActionCreateApp, restore.ActionImportKey, restore.ActionDeveloperStatus} {
+		if !actions[action] {
+			t.Errorf("journal sem %s: %v", action, actions)
+		}
+	}
+	if err := reopened.Rollback(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Close()
+
+	after, err := drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gaps := restoreGaps(diff.Compare(before, after)); len(gaps) > 0 {
+		t.Errorf("a organizacao nao voltou ao estado anterior ao restore: %v", gaps)
+	}
+
+	// Um segundo rollback nao tem nada a fazer.
+	again, err := restore.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if pending := again.Pending(); len(pending) != 0 {
+		t.Errorf("pendentes depois do rollback = %+v", pending)
+	}
+}
@@ -0,0 +1,95 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func TestRestoreIntoAnotherOrgWithMapping(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{golden.developerSnap, golden.appSnap}
+
+	const staging = "staging-org"
+	fake.AddProduct(staging, "payments-stg")
+	fake.AddProduct(staging, "catalog-stg")
+
+	m := &mapping.Mapping{
+		Products:   map[string]string{"payments": "payments-stg", "catalog": "catalog-stg"},
+		Developers: map[string]string{"alice@example.com": "alice@staging.example.com"},
+		Attributes: map[string]map[string]string{"DisplayName": {"Mobile": "Mobile (staging)"}},
+	}
+
+	// bob e os DisplayName dos outros apps nao estao no mapeamento.
+	if err := restore.CheckMapping(restore.Options{Mapping: m}, snaps...); err == nil {
+		t.Fatal("CheckMapping aceitou referencias sem mapeamento")
+	}
+	unmapped := m.Unmapped(golden.developers, golden.apps)
+	want := []string{"atributo DisplayName=Mobile do Bob", "atributo DisplayName=Web", "developer bob@example.com"}
+	if len(unmapped) != len(want) {
+		t.Fatalf("sem mapeamento = %v", unmapped)
+	}
+	for i := range want {
+		if unmapped[i] != want[i] {
+			t.Errorf("sem mapeamento = %v, esperado %v", unmapped, want)
+		}
+	}
+
+	m.AllowUnmapped = true
+	if err := restore.CheckMapping(restore.Options{Mapping: m}, snaps...); err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := drift.Live(ctx, client, staging, map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := restore.AllPlan(staging, live, restore.Options{Mapping: m}, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// O --apply-plan usa o mapeamento gravado no plano.
+	if _, err := restore.ApplyPlan(ctx, client, plan, snaps, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := fake.Developers(staging); len(got) != 2 || got[0] != "alice@staging.example.com" || got[1] != "bob@example.com" {
+		t.Errorf("developers em %s = %v", staging, got)
+	}
+	app := fake.App(staging, "alice@staging.example.com", "mobile")
+	if app == nil {
+		t.Fatal("app mobile da alice nao foi restaurado")
+	}
+	if app.Attributes[0].Value != "Mobile (staging)" {
+		t.Errorf("atributos = %+v", app.Attributes)
+	}
+	// O backup grava os produtos em ordem alfabetica.
+	if len(app.Credentials) != 1 || len(app.Credentials[0].ApiProducts) != 2 ||
+		app.Credentials[0].ApiProducts[0].Apiproduct != "catalog-stg" || app.Credentials[0].ApiProducts[1].Apiproduct != "payments-stg" {
+		t.Errorf("credenciais = %+v", app.Credentials)
+	}
+	if got := fake.App(staging, "bob@example.com", "web"); got == nil || len(got.Credentials) != 2 {
+		t.Errorf("app web do bob = %+v", got)
+	}
+
+	// A org de origem nao foi tocada.
+	if got := fake.Developers(org); len(got) != 2 || got[0] != "alice@example.com" {
+		t.Errorf("developers em %s = %v", org, got)
+	}
+}
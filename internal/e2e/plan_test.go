@@ -0,0 +1,171 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestDryRunPlanAndApply(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{golden.developerSnap, golden.appSnap}
+
+	fake.Wipe(org)
+	err = fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: "alice@example.com", FirstName: "Alice", LastName: "Silva", UserName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := drift.Live(ctx, client, org, map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devPlan, err := restore.SnapshotPlan(org, live, restore.Options{}, golden.developerSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appPlan, err := restore.SnapshotPlan(org, live, restore.Options{}, golden.appSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devPlan.Steps) != 1 || devPlan.Steps[0].String() != "criar developer bob@example.com" {
+		t.Errorf("plano de developers = %+v", devPlan.Steps)
+	}
+	if len(devPlan.Skipped) != 1 {
+		t.Errorf("pulados = %v", devPlan.Skipped)
+	}
+	// 4 apps: criar, apagar a chave padrao e, por chave, importar e associar.
+	if len(appPlan.Steps) != 4*2+5*2 {
+		t.Errorf("plano de apps com %d passos: %+v", len(appPlan.Steps), appPlan.Steps)
+	}
+	want := []restore.Step{
+		{Action: restore.ActionCreateApp, Developer: "alice@example.com", App: "batch"},
+		{Action: restore.ActionDeleteDefaultKey, Developer: "alice@example.com", App: "batch"},
+		{Action: restore.ActionImportKey, Developer: "alice@example.com", App: "batch", Key: "alice-key-2"},
+		{Action: restore.ActionAssociateProducts, Developer: "alice@example.com", App: "batch", Key: "alice-key-2", Products: []string{"catalog"}},
+	}
+	for i, step := range want {
+		if got := appPlan.Steps[i]; got.String() != step.String() {
+			t.Errorf("passo %d = %s, esperado %s", i+1, got, step)
+		}
+	}
+	// bob ainda nao existe: o plano de apps avisa, ja que ele esta em outro plano.
+	if len(appPlan.Warnings) != 2 {
+		t.Errorf("avisos = %v", appPlan.Warnings)
+	}
+
+	// O dry-run nao escreve nada.
+	if got := fake.Developers(org); len(got) != 1 {
+		t.Fatalf("developers depois do dry-run = %v", got)
+	}
+
+	// O --apply-plan le o JSON gravado pelo --dry-run.
+	for _, plan := range []*restore.Plan{devPlan, appPlan} {
+		var buf bytes.Buffer
+		if err := restore.WritePlan(&buf, plan, restore.FormatJSON); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "plan.json")
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		saved, err := restore.ReadPlan(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snaps, err := restore.OpenSnapshots(ctx, saved, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := restore.ApplyPlan(ctx, client, saved, snaps, restore.Options{})
+		if err != nil || report.Count(restore.ResultOK) != len(plan.Steps) {
+			t.Fatalf("apply: %+v, %v", report, err)
+		}
+	}
+
+	report, err := drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := restoreGaps(report); len(missing) > 0 {
+		t.Fatalf("organizacao difere do backup depois do apply: %v", missing)
+	}
+
+	// App que ja existe recebe so o que falta.
+	apps := client.Service.Organizations.Developers.Apps
+	if _, err := apps.Keys.Delete("organizations/" + org + "/developers/bob@example.com/apps/web/keys/bob-key-2").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	live, err = drift.Live(ctx, client, org, map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appPlan, err = restore.SnapshotPlan(org, live, restore.Options{}, golden.appSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []restore.Step{
+		{Action: restore.ActionImportKey, Developer: "bob@example.com", App: "web", Key: "bob-key-2"},
+		{Action: restore.ActionAssociateProducts, Developer: "bob@example.com", App: "web", Key: "bob-key-2", Products: []string{"catalog"}},
+	}
+	if len(appPlan.Steps) != len(want) || appPlan.Steps[0].String() != want[0].String() || appPlan.Steps[1].String() != want[1].String() {
+		t.Errorf("plano = %+v", appPlan.Steps)
+	}
+	if len(appPlan.Skipped) != 3 {
+		t.Errorf("pulados = %v", appPlan.Skipped)
+	}
+	if _, err := restore.ApplyPlan(ctx, client, appPlan, []*snapshot.Reader{golden.appSnap}, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := restoreGaps(report); len(missing) > 0 {
+		t.Errorf("organizacao difere do backup: %v", missing)
+	}
+}
+
+// restoreGaps lista as diferencas entre o backup e a organizacao, menos o
+// expiresAt: a chave importada volta com -1 (nunca expira) no lugar de 0.
+func restoreGaps(report *diff.Report) []string {
+	var gaps []string
+	for _, change := range report.Developers {
+		gaps = append(gaps, change.Type+" "+change.ID)
+	}
+	for _, change := range report.Apps {
+		if change.Type != diff.Modified {
+			gaps = append(gaps, change.Type+" "+change.ID)
+			continue
+		}
+		for _, field := range change.Fields {
+			if !strings.HasSuffix(field.Field, ".expiresAt") {
+				gaps = append(gaps, change.ID+" "+field.Field)
+			}
+		}
+	}
+	return gaps
+}
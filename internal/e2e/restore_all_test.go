@@ -0,0 +1,154 @@
+package e2e
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestRestoreAllInDependencyOrder(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Status diferentes do padrao, para a ultima fase ter o que fazer.
+	developers := client.Service.Organizations.Developers
+	prefix := "organizations/" + org + "/developers/"
+	if _, err := developers.SetDeveloperStatus(prefix + "bob@example.com").Action("inactive").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Apps.GenerateKeyPairOrUpdateDeveloperAppStatus(prefix+"bob@example.com/apps/web", &apigee.GoogleCloudApigeeV1DeveloperApp{}).Action("revoke").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Apps.Keys.UpdateDeveloperAppKey(prefix+"alice@example.com/apps/batch/keys/alice-key-2", &apigee.GoogleCloudApigeeV1DeveloperAppKey{}).Action("revoke").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := developers.Apps.Keys.Apiproducts.UpdateDeveloperAppKeyApiProduct(prefix + "alice@example.com/apps/mobile/keys/alice-key-1/apiproducts/catalog").Action("revoke").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+
+	golden := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+	snaps := []*snapshot.Reader{golden.developerSnap, golden.appSnap}
+	everything := map[string]bool{snapshot.KindDeveloper: true, snapshot.KindApp: true}
+
+	fake.Wipe(org)
+
+	live, err := drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := restore.AllPlan(org, live, restore.Options{}, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Warnings) > 0 {
+		t.Errorf("avisos = %v", plan.Warnings)
+	}
+
+	// As fases nunca voltam: todos os developers antes de qualquer app etc.
+	order := []string{restore.PhaseProducts, restore.PhaseDevelopers, restore.PhaseApps, restore.PhaseKeys, restore.PhaseBindings, restore.PhaseStatuses}
+	report, err := restore.ApplyPlan(ctx, client, plan, snaps, restore.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := 0
+	for _, result := range report.Results {
+		i := indexOf(order, result.Phase)
+		if i < last {
+			t.Fatalf("fase %s depois de %s", result.Phase, order[last])
+		}
+		last = i
+	}
+	if report.Count(restore.ResultOK) != len(plan.Steps) {
+		t.Errorf("%d de %d passos ok", report.Count(restore.ResultOK), len(plan.Steps))
+	}
+
+	after, err := drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gaps := restoreGaps(after); len(gaps) > 0 {
+		t.Fatalf("organizacao difere do backup depois do restore all: %v", gaps)
+	}
+
+	// Sem o product catalog, com continue: so o que depende dele fica de fora.
+	fake.Wipe(org)
+	if _, err := client.Service.Organizations.Apiproducts.Delete("organizations/" + org + "/apiproducts/catalog").Context(ctx).Do(); err != nil {
+		t.Fatal(err)
+	}
+	live, err = drift.Live(ctx, client, org, everything)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err = restore.AllPlan(org, live, restore.Options{}, snaps...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = restore.ApplyPlan(ctx, client, plan, snaps, restore.Options{Policy: restore.PolicyContinue})
+	if err == nil {
+		t.Fatal("restore sem o product catalog nao falhou")
+	}
+	if report.Count(restore.ResultFailed) != 1 {
+		t.Errorf("falhas = %d", report.Count(restore.ResultFailed))
+	}
+	// A associacao e uma chamada so por chave: a de alice-key-1 (payments e
+	// catalog) fica toda de fora, e com ela o status de payments nessa chave.
+	catalogKeys := map[string]bool{}
+	for _, step := range plan.Steps {
+		if step.Action == restore.ActionAssociateProducts && strings.Contains(strings.Join(step.Products, ","), "catalog") {
+			catalogKeys[step.Key] = true
+		}
+	}
+	for _, result := range report.Results {
+		usesCatalog := (result.Step.Action == restore.ActionAssociateProducts || result.Step.Action == restore.ActionProductStatus) && catalogKeys[result.Step.Key]
+		if result.Step.Action == restore.ActionCheckProduct {
+			continue
+		}
+		if usesCatalog && result.Status != restore.ResultSkipped {
+			t.Errorf("%s: %s, esperado pulado", result.Step, result.Status)
+		}
+		if !usesCatalog && result.Status != restore.ResultOK {
+			t.Errorf("%s: %s %s", result.Step, result.Status, result.Error)
+		}
+	}
+	if got := fake.App(org, "bob@example.com", "web"); got == nil || len(got.Credentials) != 2 {
+		t.Errorf("app web depois do restore com continue = %+v", got)
+	}
+
+	// Com fail-fast nada e feito depois da falha.
+	fake.Wipe(org)
+	report, err = restore.ApplyPlan(ctx, client, plan, snaps, restore.Options{Policy: restore.PolicyFailFast})
+	if err == nil {
+		t.Fatal("restore sem o product catalog nao falhou")
+	}
+	if report.Count(restore.ResultOK)+report.Count(restore.ResultFailed) != 1 || report.Results[0].Status != restore.ResultFailed {
+		t.Errorf("fail-fast executou %d passos", report.Count(restore.ResultOK))
+	}
+	if got := fake.Developers(org); len(got) != 0 {
+		t.Errorf("developers criados depois da falha: %v", got)
+	}
+}
+
+func indexOf(list []string, value string) int {
+	for i, item := range list {
+		if item == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,134 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+func TestResumeInterruptedBackup(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) { testResume(t, snapshot.Options{}) })
+	// No ndjson as linhas sao acrescentadas a cada checkpoint, e o backup
+	// retomado corta o arquivo no tamanho do ultimo.
+	t.Run("ndjson", func(t *testing.T) { testResume(t, snapshot.Options{Format: snapshot.FormatNDJSON}) })
+}
+
+func testResume(t *testing.T, opts snapshot.Options) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+	for _, name := range []string{"carol", "dave", "erin"} {
+		email := name + "@example.com"
+		if err := fake.AddDeveloper(org, apigee.GoogleCloudApigeeV1Developer{Email: email, FirstName: name, LastName: "Teste", UserName: name}); err != nil {
+			t.Fatal(err)
+		}
+		app := apigee.GoogleCloudApigeeV1DeveloperApp{
+			Name:        "app",
+			Credentials: []*apigee.GoogleCloudApigeeV1Credential{credential(name+"-key", name+"-secret", "payments")},
+		}
+		if err := fake.AddApp(org, email, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(size int64) { backup.PageSize = size }(backup.PageSize)
+	backup.PageSize = 2
+
+	// A quota estoura no developer carol, na segunda pagina (carol, dave).
+	quota := true
+	requests := map[string]int{}
+	fake.Fault = func(r *http.Request) int {
+		requests[r.URL.Path]++
+		if quota && strings.Contains(r.URL.Path, "/developers/carol@example.com") {
+			return http.StatusTooManyRequests
+		}
+		return 0
+	}
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	w, err := snapshot.Create(ctx, filepath.Join(root, "apps"), org, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Apps(ctx, client, org, w); err != nil {
+		t.Fatal(err)
+	}
+	first, err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Status != snapshot.StatusPartial || len(first.Errors) != 1 {
+		t.Fatalf("status = %s, erros = %v", first.Status, first.Errors)
+	}
+	if _, err := os.Stat(filepath.Join(w.Path(), snapshot.CheckpointFile)); err != nil {
+		t.Fatalf("o snapshot parcial ficou sem checkpoint: %v", err)
+	}
+
+	// Um processo morto no meio da gravacao do checkpoint deixa so o
+	// temporario truncado; o checkpoint anterior continua inteiro.
+	checkpoint, err := os.ReadFile(filepath.Join(w.Path(), snapshot.CheckpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := filepath.Join(w.Path(), snapshot.CheckpointFile+".123.tmp")
+	if err := os.WriteFile(torn, checkpoint[:len(checkpoint)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// --resume: so a carol e os developers que faltaram sao lidos de novo.
+	quota = false
+	requests = map[string]int{}
+	resumed, err := snapshot.Resume(ctx, w.Path(), org, snapshot.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Apps(ctx, client, org, resumed); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := resumed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, email := range []string{"alice@example.com", "bob@example.com", "dave@example.com"} {
+		if n := requests["/v1/organizations/"+org+"/developers/"+email+"/apps"]; n != 0 {
+			t.Errorf("os apps de %s foram listados de novo %d vez(es)", email, n)
+		}
+	}
+	if manifest.ID != first.ID || manifest.Status != snapshot.StatusComplete || len(manifest.Errors) != 0 {
+		t.Errorf("manifesto retomado: id %s (era %s), status %s, erros %v", manifest.ID, first.ID, manifest.Status, manifest.Errors)
+	}
+	if manifest.Counts[snapshot.KindApp] != 7 || len(manifest.Files) != 7 {
+		t.Errorf("contagem = %v, arquivos = %d", manifest.Counts, len(manifest.Files))
+	}
+	if _, err := os.Stat(filepath.Join(w.Path(), snapshot.CheckpointFile)); !os.IsNotExist(err) {
+		t.Errorf("o checkpoint ficou no snapshot completo: %v", err)
+	}
+	if _, err := os.Stat(torn); !os.IsNotExist(err) {
+		t.Errorf("o checkpoint truncado ficou no snapshot: %v", err)
+	}
+	problems, err := snapshot.Verify(ctx, w.Path(), snapshot.VerifyOptions{})
+	if err != nil || len(problems) > 0 {
+		t.Errorf("verify: %v %v", err, problems)
+	}
+
+	if _, err := snapshot.Resume(ctx, w.Path(), org, snapshot.Options{}); err == nil {
+		t.Error("um snapshot completo foi retomado")
+	}
+}
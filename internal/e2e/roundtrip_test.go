@@ -0,0 +1,444 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/fakeapigee"
+	"backup-restore-apigee/internal/fakestorage"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/restore"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+	"backup-restore-apigee/internal/storage"
+
+	"google.golang.org/api/apigee/v1"
+	"gopkg.in/yaml.v2"
+)
+
+const org = "test-org"
+
+func TestBackupWipeRestoreRoundTrip(t *testing.T) {
+	kms, err := secrets.NewLocalKey(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]snapshot.Options{
+		"dir":       {},
+		"tar.gz":    {Archive: snapshot.FormatTarGz},
+		"tar.zst":   {Archive: snapshot.FormatTarZstd},
+		"encrypted": {Archive: snapshot.FormatTarGz, KMS: kms},
+		"dedup":     {Dedup: true},
+		"yaml":      {Format: snapshot.FormatYAML},
+		"json":      {Format: snapshot.FormatJSON},
+		"ndjson":    {Format: snapshot.FormatNDJSON},
+		"ndjson.gz": {Archive: snapshot.FormatTarGz, KMS: kms, Format: snapshot.FormatNDJSON},
+	}
+	for name, opts := range cases {
+		t.Run(name, func(t *testing.T) {
+			testRoundTrip(t, t.TempDir(), opts)
+		})
+	}
+}
+
+func TestRoundTripRemoteStorage(t *testing.T) {
+	t.Run("gcs", func(t *testing.T) {
+		server := fakestorage.NewGCS()
+		defer server.Close()
+		t.Setenv(storage.EmulatorEnv, server.URL)
+
+		testRoundTrip(t, "gs://backups/nightly", snapshot.Options{})
+		testRoundTrip(t, "gs://backups/nightly", snapshot.Options{Archive: snapshot.FormatTarZstd})
+		if len(server.Keys("backups")) == 0 {
+			t.Error("nada foi gravado no bucket")
+		}
+	})
+
+	t.Run("s3", func(t *testing.T) {
+		server := fakestorage.NewS3()
+		defer server.Close()
+		t.Setenv(storage.S3EndpointEnv, server.URL)
+		t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+
+		testRoundTrip(t, "s3://backups/nightly", snapshot.Options{})
+		testRoundTrip(t, "s3://backups/nightly", snapshot.Options{Archive: snapshot.FormatTarGz})
+		testRoundTrip(t, "s3://backups/nightly", snapshot.Options{Dedup: true})
+		if len(server.Keys("backups")) == 0 {
+			t.Error("nada foi gravado no bucket")
+		}
+	})
+}
+
+func TestEncryptedBackupHasNoPlaintextSecrets(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kms, err := secrets.NewPassphrase("senha de teste")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{KMS: kms})
+
+	files, _ := result.appSnap.FilesOfKind(snapshot.KindApp)
+	for _, file := range files {
+		data, _ := result.appSnap.ReadFile(file)
+		if bytes.Contains(data, []byte("-secret-")) {
+			t.Errorf("%s tem consumer secret em texto claro:\n%s", file, data)
+		}
+	}
+
+	locked, err := snapshot.Open(ctx, result.appSnap.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locked.ReadApp(files[0]); err == nil || !strings.Contains(err.Error(), "--secrets-") {
+		t.Errorf("ler sem chave deveria pedir a chave, erro: %v", err)
+	}
+}
+
+func testRoundTrip(t *testing.T, root string, opts snapshot.Options) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := runBackup(t, ctx, client, storage.Join(root, "before"), opts)
+	if len(before.developers) != 2 || len(before.apps) != 4 {
+		t.Fatalf("backup incompleto: %d developers, %d apps", len(before.developers), len(before.apps))
+	}
+
+	fake.Wipe(org)
+	if got := fake.Developers(org); len(got) != 0 {
+		t.Fatalf("wipe deixou developers: %v", got)
+	}
+
+	if _, err := restore.Developers(ctx, client, org, before.developerSnap, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restore.Apps(ctx, client, org, before.appSnap, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	after := runBackup(t, ctx, client, storage.Join(root, "after"), opts)
+
+	if !reflect.DeepEqual(normalizeDevelopers(before.developers), normalizeDevelopers(after.developers)) {
+		t.Errorf("developers diferentes apos o restore:\nantes:  %+v\ndepois: %+v", normalizeDevelopers(before.developers), normalizeDevelopers(after.developers))
+	}
+	if !reflect.DeepEqual(normalizeApps(before.apps), normalizeApps(after.apps)) {
+		t.Errorf("apps diferentes apos o restore:\nantes:  %+v\ndepois: %+v", normalizeApps(before.apps), normalizeApps(after.apps))
+	}
+}
+
+// Snapshots gravados antes do layout apps/<email>/<app>.yaml tem os apps
+// soltos na raiz e continuam restaurando.
+func TestRestoreLegacyFlatLayout(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+
+	legacy, err := snapshot.Create(ctx, storage.Join(t.TempDir(), "apps"), org, snapshot.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, app := range result.apps {
+		// No layout antigo o mobile do bob sobrescrevia o da alice.
+		if app.DeveloperID == "bob@example.com" && app.Name == "mobile" {
+			continue
+		}
+		data, err := yaml.Marshal(app)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := legacy.WriteFile(snapshot.KindApp, app.Name+".yaml", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := legacy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Wipe(org)
+	if _, err := restore.Developers(ctx, client, org, result.developerSnap, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := snapshot.Open(ctx, legacy.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := restore.Apps(ctx, client, org, snap, restore.Options{})
+	if err != nil || restored != 3 {
+		t.Fatalf("restore do layout antigo: %d, %v", restored, err)
+	}
+	if app := fake.App(org, "alice@example.com", "mobile"); app == nil || app.Credentials[0].ConsumerKey != "alice-key-1" {
+		t.Errorf("mobile da alice nao foi restaurado: %+v", app)
+	}
+}
+
+func TestSameAppNameForTwoDevelopers(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{})
+
+	files, _ := result.appSnap.FilesOfKind(snapshot.KindApp)
+	want := map[string]bool{
+		"apps/alice@example.com/mobile.yaml": true,
+		"apps/bob@example.com/mobile.yaml":   true,
+	}
+	for _, file := range files {
+		delete(want, file)
+	}
+	if len(want) > 0 {
+		t.Errorf("faltam %v em %v", want, files)
+	}
+}
+
+func seed(t *testing.T, fake *fakeapigee.Server) {
+	t.Helper()
+
+	fake.AddProduct(org, "payments")
+	fake.AddProduct(org, "catalog")
+
+	for _, d := range []apigee.GoogleCloudApigeeV1Developer{
+		{Email: "alice@example.com", FirstName: "Alice", LastName: "Silva", UserName: "alice"},
+		{Email: "bob@example.com", FirstName: "Bob", LastName: "Souza", UserName: "bob"},
+	} {
+		if err := fake.AddDeveloper(org, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apps := map[string][]apigee.GoogleCloudApigeeV1DeveloperApp{
+		"alice@example.com": {
+			{
+				Name:       "mobile",
+				Attributes: []*apigee.GoogleCloudApigeeV1Attribute{{Name: "DisplayName", Value: "Mobile"}, {Name: "tier", Value: "gold"}},
+				Credentials: []*apigee.GoogleCloudApigeeV1Credential{
+					credential("alice-key-1", "alice-secret-1", "payments", "catalog"),
+				},
+			},
+			{
+				Name: "batch",
+				Credentials: []*apigee.GoogleCloudApigeeV1Credential{
+					credential("alice-key-2", "alice-secret-2", "catalog"),
+				},
+			},
+		},
+		"bob@example.com": {
+			{
+				Name:       "web",
+				Attributes: []*apigee.GoogleCloudApigeeV1Attribute{{Name: "DisplayName", Value: "Web"}},
+				Credentials: []*apigee.GoogleCloudApigeeV1Credential{
+					credential("bob-key-1", "bob-secret-1", "payments"),
+					credential("bob-key-2", "bob-secret-2", "catalog"),
+				},
+			},
+			// Mesmo nome de um app da alice: nomes so sao unicos por developer.
+			{
+				Name:       "mobile",
+				Attributes: []*apigee.GoogleCloudApigeeV1Attribute{{Name: "DisplayName", Value: "Mobile do Bob"}},
+				Credentials: []*apigee.GoogleCloudApigeeV1Credential{
+					credential("bob-key-3", "bob-secret-3", "payments"),
+				},
+			},
+		},
+	}
+	for email, list := range apps {
+		for _, app := range list {
+			if err := fake.AddApp(org, email, app); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func credential(key, secret string, products ...string) *apigee.GoogleCloudApigeeV1Credential {
+	cred := &apigee.GoogleCloudApigeeV1Credential{ConsumerKey: key, ConsumerSecret: secret}
+	for _, p := range products {
+		cred.ApiProducts = append(cred.ApiProducts, &apigee.GoogleCloudApigeeV1ApiProductRef{Apiproduct: p, Status: "approved"})
+	}
+	return cred
+}
+
+type backupResult struct {
+	developerSnap *snapshot.Reader
+	appSnap       *snapshot.Reader
+	developers    []model.DeveloperBackup
+	apps          []model.AppBackup
+}
+
+// runBackup grava os snapshots de developers e apps em root, que pode ser um
+// diretorio ou uma URL gs:// / s3://.
+func runBackup(t *testing.T, ctx context.Context, client *apigeeclient.Client, root string, opts snapshot.Options) backupResult {
+	t.Helper()
+
+	devSnap, err := snapshot.Create(ctx, storage.Join(root, "developers"), org, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appSnap, err := snapshot.Create(ctx, storage.Join(root, "apps"), org, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Developers(ctx, client, org, devSnap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Apps(ctx, client, org, appSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	var result backupResult
+	for _, w := range []*snapshot.Writer{devSnap, appSnap} {
+		if _, err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		problems, err := snapshot.Verify(ctx, w.Path(), snapshot.VerifyOptions{})
+		if err != nil || len(problems) > 0 {
+			t.Fatalf("snapshot %s invalido: %v %v", w.Path(), err, problems)
+		}
+	}
+
+	if result.developerSnap, err = snapshot.Open(ctx, devSnap.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if result.appSnap, err = snapshot.Open(ctx, appSnap.Path()); err != nil {
+		t.Fatal(err)
+	}
+	result.appSnap.SetKMS(opts.KMS)
+
+	files, _ := result.developerSnap.FilesOfKind(snapshot.KindDeveloper)
+	for _, file := range files {
+		doc, err := result.developerSnap.ReadDeveloper(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.developers = append(result.developers, doc)
+	}
+
+	files, _ = result.appSnap.FilesOfKind(snapshot.KindApp)
+	for _, file := range files {
+		doc, err := result.appSnap.ReadApp(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.apps = append(result.apps, doc)
+	}
+
+	return result
+}
+
+// normalizeDevelopers zera os campos gerados pelo servidor, que mudam a cada criacao.
+func normalizeDevelopers(docs []model.DeveloperBackup) map[string]model.DeveloperBackup {
+	out := map[string]model.DeveloperBackup{}
+	for _, d := range docs {
+		d.DeveloperID, d.CreatedAt, d.LastModifiedAt = "", 0, 0
+		sort.Strings(d.Apps)
+		out[d.Email] = d
+	}
+	return out
+}
+
+func normalizeApps(docs []model.AppBackup) map[string]model.AppBackup {
+	out := map[string]model.AppBackup{}
+	for _, a := range docs {
+		a.AppID, a.CreatedAt, a.LastModifiedAt = "", 0, 0
+		for i := range a.Credentials {
+			a.Credentials[i].IssuedAt, a.Credentials[i].ExpiresAt = 0, 0
+		}
+		out[a.DeveloperID+"/"+a.Name] = a
+	}
+	return out
+}
+
+func TestRedactedBackup(t *testing.T) {
+	ctx := context.Background()
+	fake := fakeapigee.New()
+	defer fake.Close()
+
+	seed(t, fake)
+
+	client, err := fake.Client(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := runBackup(t, ctx, client, t.TempDir(), snapshot.Options{RedactSecrets: true})
+
+	live := fake.App(org, "alice@example.com", "mobile")
+	for _, app := range result.apps {
+		for _, cred := range app.Credentials {
+			if !secrets.IsRedacted(cred.ConsumerSecret) {
+				t.Fatalf("secret de %s nao foi removido: %s", cred.ConsumerKey, cred.ConsumerSecret)
+			}
+			if cred.ConsumerKey != live.Credentials[0].ConsumerKey {
+				continue
+			}
+			if ok, err := secrets.MatchFingerprint(cred.ConsumerSecret, live.Credentials[0].ConsumerSecret); err != nil || !ok {
+				t.Errorf("impressao digital de %s nao confere com o secret atual", cred.ConsumerKey)
+			}
+		}
+	}
+
+	fake.Wipe(org)
+	if _, err := restore.Developers(ctx, client, org, result.developerSnap, restore.Options{}); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := restore.Apps(ctx, client, org, result.appSnap, restore.Options{})
+	if err == nil || restored != 0 {
+		t.Fatalf("restore de backup sem secrets deveria ser recusado, restaurados %d", restored)
+	}
+
+	restored, err = restore.Apps(ctx, client, org, result.appSnap, restore.Options{RegenerateRedacted: true})
+	if err != nil || restored != 4 {
+		t.Fatalf("restore com secrets novos: %d, %v", restored, err)
+	}
+
+	app := fake.App(org, "alice@example.com", "mobile")
+	if app == nil || len(app.Credentials) != 1 || app.Credentials[0].ConsumerKey != "alice-key-1" {
+		t.Fatalf("app restaurado sem a chave original: %+v", app)
+	}
+	if app.Credentials[0].ConsumerSecret == "alice-secret-1" || len(app.Credentials[0].ConsumerSecret) != 64 {
+		t.Errorf("secret nao foi regenerado: %s", app.Credentials[0].ConsumerSecret)
+	}
+}
@@ -0,0 +1,729 @@
+// Package fakeapigee implementa em memoria os endpoints da API de
+// gerenciamento do Apigee usados pelos comandos de backup e restore.
+// Serve para testes de integracao sem tocar em uma organizacao real.
+package fakeapigee
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backup-restore-apigee/internal/apigeeclient"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+type Server struct {
+	*httptest.Server
+
+	// Now e usado nos campos createdAt/lastModifiedAt e nas chaves geradas.
+	Now func() time.Time
+
+	// Fault, se definido, e chamado antes de cada requisicao; um status
+	// diferente de zero e devolvido como erro no lugar da resposta. Simula
+	// quota estourada ou queda no meio de um backup. Chamado com o servidor
+	// travado: nao pode usar os outros metodos do Server.
+	Fault func(r *http.Request) int
+
+	mu   sync.Mutex
+	orgs map[string]*org
+	seq  int
+}
+
+type org struct {
+	name       string
+	developers []*developer
+	products   []*apigee.GoogleCloudApigeeV1ApiProduct
+}
+
+type developer struct {
+	info *apigee.GoogleCloudApigeeV1Developer
+	apps []*apigee.GoogleCloudApigeeV1DeveloperApp
+}
+
+// New sobe o servidor fake. Chame Close ao final do teste.
+func New() *Server {
+	s := &Server{
+		Now:  time.Now,
+		orgs: map[string]*org{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client devolve um cliente apontando para o fake, sem autenticacao.
+func (s *Server) Client(ctx context.Context) (*apigeeclient.Client, error) {
+	return apigeeclient.NewWithHTTPClient(ctx, s.URL, s.Server.Client())
+}
+
+// AddProduct cadastra um API product na organizacao.
+func (s *Server) AddProduct(orgName, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.org(orgName)
+	if o.product(name) == nil {
+		o.products = append(o.products, &apigee.GoogleCloudApigeeV1ApiProduct{
+			Name:        name,
+			DisplayName: name,
+			CreatedAt:   s.now(),
+		})
+	}
+}
+
+// AddDeveloper cadastra um developer como se tivesse sido criado no console.
+func (s *Server) AddDeveloper(orgName string, d apigee.GoogleCloudApigeeV1Developer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.createDeveloper(s.org(orgName), &d)
+	return err
+}
+
+// AddApp cadastra um app com as credenciais informadas, sem gerar a chave padrao.
+// Os API products das credenciais precisam existir.
+func (s *Server) AddApp(orgName, email string, app apigee.GoogleCloudApigeeV1DeveloperApp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.org(orgName)
+	d := o.developer(email)
+	if d == nil {
+		return fmt.Errorf("developer %s nao existe", email)
+	}
+	for _, cred := range app.Credentials {
+		for _, ref := range cred.ApiProducts {
+			if o.product(ref.Apiproduct) == nil {
+				return fmt.Errorf("api product %s nao existe", ref.Apiproduct)
+			}
+		}
+	}
+
+	credentials := app.Credentials
+	app.Credentials = nil
+	created, err := s.createApp(d, &app, false)
+	if err != nil {
+		return err
+	}
+	for _, cred := range credentials {
+		c := *cred
+		if c.IssuedAt == 0 {
+			c.IssuedAt = s.now()
+		}
+		if c.Status == "" {
+			c.Status = "approved"
+		}
+		created.Credentials = append(created.Credentials, &c)
+	}
+	return nil
+}
+
+// Wipe remove todos os developers, apps e chaves da organizacao. Os API
+// products sao mantidos, como acontece quando so os developers sao apagados.
+func (s *Server) Wipe(orgName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.org(orgName).developers = nil
+}
+
+// Developers devolve os emails cadastrados, na ordem de criacao.
+func (s *Server) Developers(orgName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var emails []string
+	for _, d := range s.org(orgName).developers {
+		emails = append(emails, d.info.Email)
+	}
+	return emails
+}
+
+// App devolve uma copia do app, ou nil se nao existir.
+func (s *Server) App(orgName, email, name string) *apigee.GoogleCloudApigeeV1DeveloperApp {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.org(orgName).developer(email)
+	if d == nil {
+		return nil
+	}
+	app := d.app(name)
+	if app == nil {
+		return nil
+	}
+	return copyApp(app)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Fault != nil {
+		if code := s.Fault(r); code != 0 {
+			writeError(w, code, "falha simulada em %s %s", r.Method, r.URL.Path)
+			return
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/organizations/")
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, "recurso desconhecido: %s", r.URL.Path)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	o := s.org(parts[0])
+	parts = parts[1:]
+
+	if len(parts) > 0 && parts[0] == "apiproducts" {
+		s.serveProducts(w, r, o, parts[1:])
+		return
+	}
+	if len(parts) > 0 && parts[0] == "developers" {
+		s.serveDevelopers(w, r, o, parts[1:])
+		return
+	}
+
+	writeError(w, http.StatusNotFound, "recurso desconhecido: %s", r.URL.Path)
+}
+
+func (s *Server) serveProducts(w http.ResponseWriter, r *http.Request, o *org, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		resp := &apigee.GoogleCloudApigeeV1ListApiProductsResponse{}
+		for _, p := range o.products {
+			if r.URL.Query().Get("expand") == "true" {
+				resp.ApiProduct = append(resp.ApiProduct, p)
+			} else {
+				resp.ApiProduct = append(resp.ApiProduct, &apigee.GoogleCloudApigeeV1ApiProduct{Name: p.Name})
+			}
+		}
+		writeJSON(w, resp)
+
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		var p apigee.GoogleCloudApigeeV1ApiProduct
+		if !readJSON(w, r, &p) {
+			return
+		}
+		if p.Name == "" {
+			writeError(w, http.StatusBadRequest, "name obrigatorio")
+			return
+		}
+		if o.product(p.Name) != nil {
+			writeError(w, http.StatusConflict, "api product %s ja existe", p.Name)
+			return
+		}
+		p.CreatedAt = s.now()
+		p.LastModifiedAt = p.CreatedAt
+		o.products = append(o.products, &p)
+		writeJSON(w, &p)
+
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		p := o.product(parts[0])
+		if p == nil {
+			writeError(w, http.StatusNotFound, "api product %s nao existe", parts[0])
+			return
+		}
+		writeJSON(w, p)
+
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		for i, p := range o.products {
+			if p.Name == parts[0] {
+				o.products = append(o.products[:i], o.products[i+1:]...)
+				writeJSON(w, p)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "api product %s nao existe", parts[0])
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+	}
+}
+
+func (s *Server) serveDevelopers(w http.ResponseWriter, r *http.Request, o *org, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			// Como na API, a lista vem em ordem de email; startKey e
+			// inclusivo, count limita a pagina e expand traz os detalhes.
+			developers := append([]*developer(nil), o.developers...)
+			sort.Slice(developers, func(i, j int) bool {
+				return developers[i].info.Email < developers[j].info.Email
+			})
+			count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+			start := r.URL.Query().Get("startKey")
+
+			resp := &apigee.GoogleCloudApigeeV1ListOfDevelopersResponse{}
+			for _, d := range developers {
+				if d.info.Email < start || (count > 0 && len(resp.Developer) == count) {
+					continue
+				}
+				if r.URL.Query().Get("expand") == "true" {
+					resp.Developer = append(resp.Developer, s.developerView(d))
+				} else {
+					resp.Developer = append(resp.Developer, &apigee.GoogleCloudApigeeV1Developer{Email: d.info.Email})
+				}
+			}
+			writeJSON(w, resp)
+		case http.MethodPost:
+			var d apigee.GoogleCloudApigeeV1Developer
+			if !readJSON(w, r, &d) {
+				return
+			}
+			created, err := s.createDeveloper(o, &d)
+			if err != nil {
+				writeError(w, http.StatusConflict, "%v", err)
+				return
+			}
+			writeJSON(w, s.developerView(created))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+		}
+		return
+	}
+
+	d := o.developer(parts[0])
+	if d == nil {
+		writeError(w, http.StatusNotFound, "developer %s nao existe", parts[0])
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, s.developerView(d))
+		case http.MethodDelete:
+			for i, other := range o.developers {
+				if other == d {
+					o.developers = append(o.developers[:i], o.developers[i+1:]...)
+				}
+			}
+			writeJSON(w, s.developerView(d))
+		case http.MethodPost:
+			switch r.URL.Query().Get("action") {
+			case "active", "inactive":
+				d.info.Status = r.URL.Query().Get("action")
+				d.info.LastModifiedAt = s.now()
+				writeJSON(w, &apigee.GoogleProtobufEmpty{})
+			default:
+				writeError(w, http.StatusBadRequest, "action invalida")
+			}
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+		}
+		return
+	}
+
+	if parts[1] != "apps" {
+		writeError(w, http.StatusNotFound, "recurso desconhecido: %s", r.URL.Path)
+		return
+	}
+	s.serveApps(w, r, o, d, parts[2:])
+}
+
+func (s *Server) serveApps(w http.ResponseWriter, r *http.Request, o *org, d *developer, parts []string) {
+	if len(parts) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			// Sem expand a API do Apigee X devolve o nome do app no campo
+			// appId; com shallowExpand vem o app sem as credenciais.
+			resp := &apigee.GoogleCloudApigeeV1ListDeveloperAppsResponse{}
+			for _, app := range d.apps {
+				if r.URL.Query().Get("shallowExpand") == "true" {
+					shallow := *app
+					shallow.Credentials = nil
+					resp.App = append(resp.App, &shallow)
+				} else {
+					resp.App = append(resp.App, &apigee.GoogleCloudApigeeV1DeveloperApp{AppId: app.Name})
+				}
+			}
+			writeJSON(w, resp)
+		case http.MethodPost:
+			var app apigee.GoogleCloudApigeeV1DeveloperApp
+			if !readJSON(w, r, &app) {
+				return
+			}
+			for _, name := range app.ApiProducts {
+				if o.product(name) == nil {
+					writeError(w, http.StatusBadRequest, "api product %s nao existe", name)
+					return
+				}
+			}
+			created, err := s.createApp(d, &app, true)
+			if err != nil {
+				writeError(w, http.StatusConflict, "%v", err)
+				return
+			}
+			writeJSON(w, created)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+		}
+		return
+	}
+
+	app := d.app(parts[0])
+	if app == nil {
+		writeError(w, http.StatusNotFound, "app %s nao existe", parts[0])
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, app)
+		case http.MethodDelete:
+			for i, other := range d.apps {
+				if other == app {
+					d.apps = append(d.apps[:i], d.apps[i+1:]...)
+				}
+			}
+			writeJSON(w, app)
+		case http.MethodPut:
+			var update apigee.GoogleCloudApigeeV1DeveloperApp
+			if !readJSON(w, r, &update) {
+				return
+			}
+			app.Attributes = update.Attributes
+			app.CallbackUrl = update.CallbackUrl
+			app.LastModifiedAt = s.now()
+			writeJSON(w, app)
+		case http.MethodPost:
+			switch r.URL.Query().Get("action") {
+			case "approve":
+				app.Status = "approved"
+			case "revoke":
+				app.Status = "revoked"
+			default:
+				writeError(w, http.StatusBadRequest, "action invalida")
+				return
+			}
+			app.LastModifiedAt = s.now()
+			writeJSON(w, app)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+		}
+		return
+	}
+
+	if parts[1] != "keys" {
+		writeError(w, http.StatusNotFound, "recurso desconhecido: %s", r.URL.Path)
+		return
+	}
+	s.serveKeys(w, r, o, app, parts[2:])
+}
+
+func (s *Server) serveKeys(w http.ResponseWriter, r *http.Request, o *org, app *apigee.GoogleCloudApigeeV1DeveloperApp, parts []string) {
+	if len(parts) == 0 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+			return
+		}
+		var key apigee.GoogleCloudApigeeV1DeveloperAppKey
+		if !readJSON(w, r, &key) {
+			return
+		}
+		if key.ConsumerKey == "" || key.ConsumerSecret == "" {
+			writeError(w, http.StatusBadRequest, "consumerKey e consumerSecret obrigatorios")
+			return
+		}
+		if findKey(app, key.ConsumerKey) != nil {
+			writeError(w, http.StatusConflict, "chave %s ja existe", key.ConsumerKey)
+			return
+		}
+		cred := &apigee.GoogleCloudApigeeV1Credential{
+			ConsumerKey:    key.ConsumerKey,
+			ConsumerSecret: key.ConsumerSecret,
+			IssuedAt:       s.now(),
+			ExpiresAt:      -1,
+			Status:         "approved",
+		}
+		app.Credentials = append(app.Credentials, cred)
+		app.LastModifiedAt = s.now()
+		writeJSON(w, credentialToKey(cred))
+		return
+	}
+
+	cred := findKey(app, parts[0])
+	if cred == nil {
+		writeError(w, http.StatusNotFound, "chave %s nao existe", parts[0])
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, credentialToKey(cred))
+		case http.MethodDelete:
+			for i, other := range app.Credentials {
+				if other == cred {
+					app.Credentials = append(app.Credentials[:i], app.Credentials[i+1:]...)
+				}
+			}
+			app.LastModifiedAt = s.now()
+			writeJSON(w, credentialToKey(cred))
+		case http.MethodPost:
+			switch r.URL.Query().Get("action") {
+			case "approve":
+				cred.Status = "approved"
+			case "revoke":
+				cred.Status = "revoked"
+			case "":
+				var body struct {
+					APIProducts []string `json:"apiProducts"`
+				}
+				if !readJSON(w, r, &body) {
+					return
+				}
+				for _, name := range body.APIProducts {
+					if o.product(name) == nil {
+						writeError(w, http.StatusBadRequest, "api product %s nao existe", name)
+						return
+					}
+				}
+				for _, name := range body.APIProducts {
+					if findProductRef(cred, name) == nil {
+						cred.ApiProducts = append(cred.ApiProducts, &apigee.GoogleCloudApigeeV1ApiProductRef{
+							Apiproduct: name,
+							Status:     "approved",
+						})
+					}
+				}
+			default:
+				writeError(w, http.StatusBadRequest, "action invalida")
+				return
+			}
+			app.LastModifiedAt = s.now()
+			writeJSON(w, credentialToKey(cred))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+		}
+		return
+	}
+
+	if len(parts) != 3 || parts[1] != "apiproducts" {
+		writeError(w, http.StatusNotFound, "recurso desconhecido: %s", r.URL.Path)
+		return
+	}
+
+	ref := findProductRef(cred, parts[2])
+	if ref == nil {
+		writeError(w, http.StatusNotFound, "api product %s nao associado a chave", parts[2])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		for i, other := range cred.ApiProducts {
+			if other == ref {
+				cred.ApiProducts = append(cred.ApiProducts[:i], cred.ApiProducts[i+1:]...)
+			}
+		}
+		app.LastModifiedAt = s.now()
+		writeJSON(w, credentialToKey(cred))
+	case http.MethodPost:
+		switch r.URL.Query().Get("action") {
+		case "approve":
+			ref.Status = "approved"
+		case "revoke":
+			ref.Status = "revoked"
+		default:
+			writeError(w, http.StatusBadRequest, "action invalida")
+			return
+		}
+		app.LastModifiedAt = s.now()
+		writeJSON(w, &apigee.GoogleProtobufEmpty{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s nao suportado", r.Method)
+	}
+}
+
+func (s *Server) createDeveloper(o *org, d *apigee.GoogleCloudApigeeV1Developer) (*developer, error) {
+	if d.Email == "" {
+		return nil, fmt.Errorf("email obrigatorio")
+	}
+	if o.developer(d.Email) != nil {
+		return nil, fmt.Errorf("developer %s ja existe", d.Email)
+	}
+
+	info := *d
+	info.Apps = nil
+	info.DeveloperId = s.id("dev")
+	info.OrganizationName = o.name
+	if info.Status == "" {
+		info.Status = "active"
+	}
+	info.CreatedAt = s.now()
+	info.LastModifiedAt = info.CreatedAt
+
+	created := &developer{info: &info}
+	o.developers = append(o.developers, created)
+	return created, nil
+}
+
+// createApp cria o app. Como na API real, se defaultKey for true e gerada uma
+// chave com os apiProducts informados no corpo (normalmente nenhum).
+func (s *Server) createApp(d *developer, app *apigee.GoogleCloudApigeeV1DeveloperApp, defaultKey bool) (*apigee.GoogleCloudApigeeV1DeveloperApp, error) {
+	if app.Name == "" {
+		return nil, fmt.Errorf("name obrigatorio")
+	}
+	if d.app(app.Name) != nil {
+		return nil, fmt.Errorf("app %s ja existe", app.Name)
+	}
+
+	created := *app
+	created.AppId = s.id("app")
+	created.DeveloperId = d.info.DeveloperId
+	if created.Status == "" {
+		created.Status = "approved"
+	}
+	created.CreatedAt = s.now()
+	created.LastModifiedAt = created.CreatedAt
+	created.Credentials = nil
+
+	if defaultKey {
+		cred := &apigee.GoogleCloudApigeeV1Credential{
+			ConsumerKey:    s.id("key"),
+			ConsumerSecret: s.id("secret"),
+			IssuedAt:       s.now(),
+			ExpiresAt:      -1,
+			Status:         "approved",
+		}
+		for _, name := range app.ApiProducts {
+			cred.ApiProducts = append(cred.ApiProducts, &apigee.GoogleCloudApigeeV1ApiProductRef{Apiproduct: name, Status: "approved"})
+		}
+		created.Credentials = append(created.Credentials, cred)
+	}
+	created.ApiProducts = nil
+
+	d.apps = append(d.apps, &created)
+	return &created, nil
+}
+
+func (s *Server) developerView(d *developer) *apigee.GoogleCloudApigeeV1Developer {
+	view := *d.info
+	view.Apps = nil
+	for _, app := range d.apps {
+		view.Apps = append(view.Apps, app.Name)
+	}
+	return &view
+}
+
+func (s *Server) org(name string) *org {
+	o, ok := s.orgs[name]
+	if !ok {
+		o = &org{name: name}
+		s.orgs[name] = o
+	}
+	return o
+}
+
+func (s *Server) now() int64 {
+	return s.Now().UnixMilli()
+}
+
+func (s *Server) id(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s-%06d", prefix, s.seq)
+}
+
+func (o *org) developer(email string) *developer {
+	for _, d := range o.developers {
+		if strings.EqualFold(d.info.Email, email) {
+			return d
+		}
+	}
+	return nil
+}
+
+func (o *org) product(name string) *apigee.GoogleCloudApigeeV1ApiProduct {
+	for _, p := range o.products {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (d *developer) app(name string) *apigee.GoogleCloudApigeeV1DeveloperApp {
+	for _, app := range d.apps {
+		if app.Name == name {
+			return app
+		}
+	}
+	return nil
+}
+
+func findKey(app *apigee.GoogleCloudApigeeV1DeveloperApp, consumerKey string) *apigee.GoogleCloudApigeeV1Credential {
+	for _, cred := range app.Credentials {
+		if cred.ConsumerKey == consumerKey {
+			return cred
+		}
+	}
+	return nil
+}
+
+func findProductRef(cred *apigee.GoogleCloudApigeeV1Credential, name string) *apigee.GoogleCloudApigeeV1ApiProductRef {
+	for _, ref := range cred.ApiProducts {
+		if ref.Apiproduct == name {
+			return ref
+		}
+	}
+	return nil
+}
+
+func credentialToKey(cred *apigee.GoogleCloudApigeeV1Credential) *apigee.GoogleCloudApigeeV1DeveloperAppKey {
+	key := &apigee.GoogleCloudApigeeV1DeveloperAppKey{
+		ConsumerKey:    cred.ConsumerKey,
+		ConsumerSecret: cred.ConsumerSecret,
+		IssuedAt:       cred.IssuedAt,
+		ExpiresAt:      cred.ExpiresAt,
+		Status:         cred.Status,
+	}
+	for _, ref := range cred.ApiProducts {
+		key.ApiProducts = append(key.ApiProducts, ref)
+	}
+	return key
+}
+
+func copyApp(app *apigee.GoogleCloudApigeeV1DeveloperApp) *apigee.GoogleCloudApigeeV1DeveloperApp {
+	data, _ := json.Marshal(app)
+	var out apigee.GoogleCloudApigeeV1DeveloperApp
+	_ = json.Unmarshal(data, &out)
+	return &out
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "corpo invalido: %v", err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responde no formato de erro das APIs do Google, que o cliente
+// gerado converte em *googleapi.Error.
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": fmt.Sprintf(format, args...),
+			"status":  http.StatusText(code),
+		},
+	})
+}
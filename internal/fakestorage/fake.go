@@ -0,0 +1,207 @@
+// Package fakestorage implementa em memoria o subconjunto das APIs do Google
+// Cloud Storage e do S3 usado pelos backends de internal/storage, no lugar do
+// fake-gcs-server e do MinIO nos testes.
+package fakestorage
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Server guarda os objetos de todos os buckets em memoria.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte // "<bucket>/<chave>"
+}
+
+// NewGCS sobe um servidor com a API JSON do Cloud Storage. Use a URL em
+// STORAGE_EMULATOR_HOST.
+func NewGCS() *Server {
+	s := &Server{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveGCS))
+	return s
+}
+
+// NewS3 sobe um servidor S3 em path-style. Use a URL em S3_ENDPOINT.
+// Requisicoes sem assinatura SigV4 sao recusadas.
+func NewS3() *Server {
+	s := &Server{objects: map[string][]byte{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveS3))
+	return s
+}
+
+// Keys lista as chaves gravadas em bucket, em ordem.
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(bucket, "", "")
+}
+
+// Object devolve o conteudo de um objeto.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[bucket+"/"+key]
+	return data, ok
+}
+
+func (s *Server) list(bucket, prefix, after string) []string {
+	var keys []string
+	for name := range s.objects {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if ok && strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) serveGCS(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// /upload/storage/v1/b/{bucket}/o e /storage/v1/b/{bucket}/o[/{objeto}]
+	path := strings.TrimPrefix(r.URL.Path, "/upload")
+	rest, ok := strings.CutPrefix(path, "/storage/v1/b/")
+	if !ok {
+		http.Error(w, "nao suportado", http.StatusNotImplemented)
+		return
+	}
+	bucket, rest, _ := strings.Cut(rest, "/")
+	object, hasObject := strings.CutPrefix(rest, "o/")
+
+	switch {
+	case r.Method == http.MethodPost && rest == "o":
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var meta struct {
+			Name string `json:"name"`
+		}
+		part, err := mr.NextPart()
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&meta)
+		}
+		if err == nil {
+			part, err = mr.NextPart()
+		}
+		if err != nil {
+			gcsError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		data, _ := io.ReadAll(part)
+		s.objects[bucket+"/"+meta.Name] = data
+		json.NewEncoder(w).Encode(map[string]string{"bucket": bucket, "name": meta.Name})
+
+	case r.Method == http.MethodGet && rest == "o":
+		var items []map[string]string
+		for _, key := range s.list(bucket, r.URL.Query().Get("prefix"), "") {
+			items = append(items, map[string]string{"name": key})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+
+	case hasObject:
+		data, ok := s.objects[bucket+"/"+object]
+		if !ok {
+			gcsError(w, http.StatusNotFound, "No such object: "+bucket+"/"+object)
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(s.objects, bucket+"/"+object)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Query().Get("alt") == "media" {
+			w.Write(data)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"bucket": bucket, "name": object, "size": len(data)})
+
+	default:
+		http.Error(w, "nao suportado", http.StatusNotImplemented)
+	}
+}
+
+func gcsError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	})
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	IsTruncated           bool     `xml:"IsTruncated"`
+	NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+	Contents              []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+}
+
+// s3PageSize e pequeno para exercitar a paginacao do ListObjectsV2.
+const s3PageSize = 2
+
+func (s *Server) serveS3(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=") {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[bucket+"/"+key] = data
+	case http.MethodDelete:
+		delete(s.objects, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		query := r.URL.Query()
+		if key == "" && query.Get("list-type") == "2" {
+			keys := s.list(bucket, query.Get("prefix"), query.Get("continuation-token"))
+			var result listBucketResult
+			if len(keys) > s3PageSize {
+				keys = keys[:s3PageSize]
+				result.IsTruncated = true
+				result.NextContinuationToken = keys[len(keys)-1]
+			}
+			for _, k := range keys {
+				result.Contents = append(result.Contents, struct {
+					Key string `xml:"Key"`
+				}{k})
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			xml.NewEncoder(w).Encode(result)
+			return
+		}
+		data, ok := s.objects[bucket+"/"+key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(data)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func s3Error(w http.ResponseWriter, code int, s3Code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	io.WriteString(w, "<Error><Code>"+s3Code+"</Code></Error>")
+}
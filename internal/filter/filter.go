@@ -0,0 +1,258 @@
+// Package filter seleciona os developers e apps de um backup ou restore, para
+// trabalhar so com os apps de um parceiro em vez da organizacao inteira.
+package filter
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"backup-restore-apigee/internal/model"
+)
+
+// Filter e o conjunto de filtros de include/exclude. Cada lista vazia deixa
+// passar tudo; um documento entra quando passa em todos os includes
+// informados e em nenhum exclude. Os filtros sao gravados no manifesto do
+// snapshot, para o drift e o verify saberem que ele e parcial.
+type Filter struct {
+	// Developers e ExcludeDevelopers sao globs de email (path.Match, sem
+	// diferenciar maiusculas), ex: *@parceiro.com. Valem para developers e
+	// para os apps deles.
+	Developers        []string `json:"developers,omitempty"`
+	ExcludeDevelopers []string `json:"excludeDevelopers,omitempty"`
+
+	// Apps e ExcludeApps sao expressoes regulares do nome do app.
+	Apps        []string `json:"apps,omitempty"`
+	ExcludeApps []string `json:"excludeApps,omitempty"`
+
+	// Products e ExcludeProducts sao nomes de API products: o app entra se
+	// alguma credencial usa um dos Products e nenhuma usa um dos
+	// ExcludeProducts.
+	Products        []string `json:"products,omitempty"`
+	ExcludeProducts []string `json:"excludeProducts,omitempty"`
+
+	// Statuses e ExcludeStatuses sao status do app (approved, revoked).
+	Statuses        []string `json:"statuses,omitempty"`
+	ExcludeStatuses []string `json:"excludeStatuses,omitempty"`
+
+	// Attributes e ExcludeAttributes sao atributos do app no formato
+	// nome=valor.
+	Attributes        []string `json:"attributes,omitempty"`
+	ExcludeAttributes []string `json:"excludeAttributes,omitempty"`
+
+	apps, excludeApps []*regexp.Regexp
+}
+
+// Flags registra os filtros no FlagSet padrao; todas as flags podem ser
+// repetidas. A funcao devolvida valida os filtros depois do flag.Parse, ou
+// devolve nil se nenhum foi informado.
+func Flags() func() (*Filter, error) {
+	f := &Filter{}
+	flag.Var((*list)(&f.Developers), "include-developer", "Glob do email dos developers incluidos, ex: *@parceiro.com (pode repetir)")
+	flag.Var((*list)(&f.ExcludeDevelopers), "exclude-developer", "Glob do email dos developers excluidos (pode repetir)")
+	flag.Var((*list)(&f.Apps), "include-app", "Expressao regular do nome dos apps incluidos (pode repetir)")
+	flag.Var((*list)(&f.ExcludeApps), "exclude-app", "Expressao regular do nome dos apps excluidos (pode repetir)")
+	flag.Var((*list)(&f.Products), "include-product", "Inclui os apps com alguma credencial neste API product (pode repetir)")
+	flag.Var((*list)(&f.ExcludeProducts), "exclude-product", "Exclui os apps com alguma credencial neste API product (pode repetir)")
+	flag.Var((*list)(&f.Statuses), "include-status", "Status dos apps incluidos: approved ou revoked (pode repetir)")
+	flag.Var((*list)(&f.ExcludeStatuses), "exclude-status", "Status dos apps excluidos (pode repetir)")
+	flag.Var((*list)(&f.Attributes), "include-attribute", "Inclui os apps com o atributo nome=valor (pode repetir)")
+	flag.Var((*list)(&f.ExcludeAttributes), "exclude-attribute", "Exclui os apps com o atributo nome=valor (pode repetir)")
+
+	return func() (*Filter, error) {
+		if f.Empty() {
+			return nil, nil
+		}
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+// Empty diz se nenhum filtro foi informado.
+func (f *Filter) Empty() bool {
+	return f == nil || !f.SelectsDevelopers() && !f.SelectsApps()
+}
+
+// SelectsDevelopers diz se ha filtro de email.
+func (f *Filter) SelectsDevelopers() bool {
+	return f != nil && len(f.Developers)+len(f.ExcludeDevelopers) > 0
+}
+
+// SelectsApps diz se ha filtro que olha o proprio app (nome, API product,
+// status ou atributo). Com ele um developer pode ter apps fora do snapshot.
+func (f *Filter) SelectsApps() bool {
+	return f != nil && len(f.Apps)+len(f.ExcludeApps)+len(f.Products)+len(f.ExcludeProducts)+
+		len(f.Statuses)+len(f.ExcludeStatuses)+len(f.Attributes)+len(f.ExcludeAttributes) > 0
+}
+
+// Validate confere os globs, as expressoes regulares e os atributos.
+func (f *Filter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Developers...), f.ExcludeDevelopers...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("glob de developer invalido %q: %v", pattern, err)
+		}
+	}
+	for _, attr := range append(append([]string(nil), f.Attributes...), f.ExcludeAttributes...) {
+		if !strings.Contains(attr, "=") {
+			return fmt.Errorf("filtro de atributo invalido %q: use nome=valor", attr)
+		}
+	}
+	return f.compile()
+}
+
+// Developer diz se o developer com este email passa no filtro. Sem filtro
+// (nil) tudo passa.
+func (f *Filter) Developer(email string) bool {
+	if f == nil {
+		return true
+	}
+	email = strings.ToLower(email)
+	return (len(f.Developers) == 0 || matchGlob(f.Developers, email)) && !matchGlob(f.ExcludeDevelopers, email)
+}
+
+// App diz se o app passa no filtro, inclusive o filtro de email do dono
+// (DeveloperID). Sem filtro (nil) tudo passa.
+func (f *Filter) App(app model.AppBackup) bool {
+	if f == nil {
+		return true
+	}
+	if !f.Developer(app.DeveloperID) {
+		return false
+	}
+	if f.compile() != nil {
+		return false
+	}
+
+	if len(f.apps) > 0 && !matchRegexp(f.apps, app.Name) || matchRegexp(f.excludeApps, app.Name) {
+		return false
+	}
+
+	products := map[string]bool{}
+	for _, cred := range app.Credentials {
+		for _, name := range cred.ProductNames() {
+			products[name] = true
+		}
+	}
+	if len(f.Products) > 0 && !anyIn(f.Products, products) || anyIn(f.ExcludeProducts, products) {
+		return false
+	}
+
+	if len(f.Statuses) > 0 && !matchFold(f.Statuses, app.Status) || matchFold(f.ExcludeStatuses, app.Status) {
+		return false
+	}
+
+	attributes := map[string]bool{}
+	for _, attr := range app.Attributes {
+		attributes[attr.Name+"="+attr.Value] = true
+	}
+	if len(f.Attributes) > 0 && !anyIn(f.Attributes, attributes) || anyIn(f.ExcludeAttributes, attributes) {
+		return false
+	}
+	return true
+}
+
+// String resume os filtros para o log, ex: developers=*@parceiro.com apps=^checkout.
+func (f *Filter) String() string {
+	if f.Empty() {
+		return "nenhum"
+	}
+	var parts []string
+	add := func(name string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, name+"="+strings.Join(values, ","))
+		}
+	}
+	add("developers", f.Developers)
+	add("-developers", f.ExcludeDevelopers)
+	add("apps", f.Apps)
+	add("-apps", f.ExcludeApps)
+	add("products", f.Products)
+	add("-products", f.ExcludeProducts)
+	add("status", f.Statuses)
+	add("-status", f.ExcludeStatuses)
+	add("attributes", f.Attributes)
+	add("-attributes", f.ExcludeAttributes)
+	return strings.Join(parts, " ")
+}
+
+// compile compila as expressoes regulares na primeira chamada. Um Filter
+// lido do manifesto nao passa pelo Validate.
+func (f *Filter) compile() error {
+	if len(f.apps) == len(f.Apps) && len(f.excludeApps) == len(f.ExcludeApps) {
+		return nil
+	}
+	apps, err := compileAll(f.Apps)
+	if err != nil {
+		return err
+	}
+	excludeApps, err := compileAll(f.ExcludeApps)
+	if err != nil {
+		return err
+	}
+	f.apps, f.excludeApps = apps, excludeApps
+	return nil
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("expressao regular de app invalida %q: %v", pattern, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func matchGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegexp(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyIn(values []string, set map[string]bool) bool {
+	for _, v := range values {
+		if set[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// list e uma flag que pode ser repetida.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
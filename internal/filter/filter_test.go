@@ -0,0 +1,105 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+)
+
+func app(name, developer, status string, products []string, attrs ...model.Attribute) model.AppBackup {
+	var refs []model.APIProductRef
+	for _, p := range products {
+		refs = append(refs, model.APIProductRef{APIProduct: p, Status: "approved"})
+	}
+	return model.AppBackup{
+		Name:        name,
+		DeveloperID: developer,
+		Status:      status,
+		Attributes:  attrs,
+		Credentials: []model.Credential{{ConsumerKey: name + "-key", APIProducts: refs}},
+	}
+}
+
+func TestNilFilterMatchesEverything(t *testing.T) {
+	var f *Filter
+	if !f.Developer("alice@example.com") || !f.App(app("mobile", "alice@example.com", "approved", nil)) {
+		t.Error("filtro nil deveria deixar tudo passar")
+	}
+	if !f.Empty() {
+		t.Error("filtro nil deveria ser vazio")
+	}
+}
+
+func TestDeveloperGlobs(t *testing.T) {
+	f := &Filter{Developers: []string{"*@Partner.com"}, ExcludeDevelopers: []string{"test-*"}}
+	cases := map[string]bool{
+		"alice@partner.com":    true,
+		"Bob@PARTNER.com":      true,
+		"test-1@partner.com":   false,
+		"carol@example.com":    false,
+		"alice@partner.com.br": false,
+	}
+	for email, want := range cases {
+		if got := f.Developer(email); got != want {
+			t.Errorf("Developer(%s) = %v, esperado %v", email, got, want)
+		}
+	}
+}
+
+func TestApp(t *testing.T) {
+	tier := model.Attribute{Name: "tier", Value: "gold"}
+	cases := []struct {
+		name   string
+		filter Filter
+		app    model.AppBackup
+		want   bool
+	}{
+		{"regex", Filter{Apps: []string{"^checkout-"}}, app("checkout-web", "a@x.com", "approved", nil), true},
+		{"regex sem match", Filter{Apps: []string{"^checkout-"}}, app("mobile", "a@x.com", "approved", nil), false},
+		{"exclude regex", Filter{ExcludeApps: []string{"-test$"}}, app("mobile-test", "a@x.com", "approved", nil), false},
+		{"product", Filter{Products: []string{"payments"}}, app("mobile", "a@x.com", "approved", []string{"catalog", "payments"}), true},
+		{"product ausente", Filter{Products: []string{"payments"}}, app("mobile", "a@x.com", "approved", []string{"catalog"}), false},
+		{"exclude product", Filter{ExcludeProducts: []string{"internal"}}, app("mobile", "a@x.com", "approved", []string{"catalog", "internal"}), false},
+		{"status", Filter{Statuses: []string{"approved"}}, app("mobile", "a@x.com", "APPROVED", nil), true},
+		{"exclude status", Filter{ExcludeStatuses: []string{"revoked"}}, app("mobile", "a@x.com", "revoked", nil), false},
+		{"atributo", Filter{Attributes: []string{"tier=gold"}}, app("mobile", "a@x.com", "approved", nil, tier), true},
+		{"atributo com outro valor", Filter{Attributes: []string{"tier=silver"}}, app("mobile", "a@x.com", "approved", nil, tier), false},
+		{"exclude atributo", Filter{ExcludeAttributes: []string{"tier=gold"}}, app("mobile", "a@x.com", "approved", nil, tier), false},
+		{"developer do app", Filter{Developers: []string{"*@partner.com"}}, app("mobile", "a@x.com", "approved", nil), false},
+	}
+	for _, c := range cases {
+		if got := c.filter.App(c.app); got != c.want {
+			t.Errorf("%s: App = %v, esperado %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, f := range []Filter{
+		{Developers: []string{"[a-"}},
+		{Apps: []string{"("}},
+		{Attributes: []string{"tier"}},
+	} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%s: esperado erro", f.String())
+		}
+	}
+}
+
+func TestFromManifest(t *testing.T) {
+	data, err := json.Marshal(&Filter{Apps: []string{"^checkout-"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f Filter
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.App(app("mobile", "a@x.com", "approved", nil)) || !f.App(app("checkout-web", "a@x.com", "approved", nil)) {
+		t.Error("filtro lido do JSON deveria compilar as expressoes regulares")
+	}
+	if !f.SelectsApps() || f.SelectsDevelopers() {
+		t.Error("SelectsApps/SelectsDevelopers errados")
+	}
+}
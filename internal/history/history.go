@@ -0,0 +1,576 @@
+// Package history grava os snapshots em um repositorio git local, um commit
+// por backup, para usar blame, log e revert na configuracao da organizacao.
+//
+// O layout e deterministico e nao tem nada que mude de um backup para outro
+// sem mudar na API:
+//
+//	<org>/manifest.json
+//	<org>/developers/<email>.json
+//	<org>/apps/<email>/<app>.yaml
+//
+// <org> e um snapshot: snapshot.Open, diff, drift e restore leem o diretorio
+// direto. O manifesto nao tem ID nem datas, so os documentos com os checksums
+// e, quando os secrets sao cifrados, a chave do repositorio (Encryption).
+//
+// Os consumer secrets nunca entram em texto claro: snapshots redigidos perdem
+// o secret (a impressao digital muda a cada backup) e snapshots cifrados sao
+// cifrados de novo com a chave do repositorio, mantendo o texto cifrado dos
+// secrets que nao mudaram.
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Diretorios de cada tipo de recurso dentro de <org>/ e o arquivo em que as
+// primeiras versoes guardavam a chave dos secrets cifrados, hoje no manifesto.
+const (
+	DevelopersDir = "developers"
+	AppsDir       = snapshot.AppsDir
+	SecretsFile   = "secrets.json"
+)
+
+// Options configura o Commit.
+type Options struct {
+	// Dir e um diretorio dentro do working tree do repositorio git.
+	Dir string
+	// KMS abre os secrets de um snapshot cifrado e a chave do repositorio.
+	// Obrigatorio para snapshots cifrados.
+	KMS secrets.KMS
+	// Remote, se informado, recebe um git push do branch atual depois do
+	// commit.
+	Remote string
+}
+
+// Result e o que o Commit gravou.
+type Result struct {
+	// Commit e o hash do commit criado, vazio se nada mudou.
+	Commit string
+	Report *diff.Report
+	Counts map[string]int
+}
+
+// Commit grava a visao completa do snapshot r em opts.Dir/<org> e faz um
+// commit com as contagens e as mudancas desde o commit anterior. So os tipos
+// de recurso do snapshot sao trocados: o backup de developers nao mexe nos
+// apps. Snapshots parciais, com filtro ou com secrets em texto claro sao
+// recusados.
+func Commit(ctx context.Context, r *snapshot.Reader, opts Options) (*Result, error) {
+	manifest, err := r.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Status == snapshot.StatusPartial {
+		return nil, fmt.Errorf("o snapshot %s e parcial; retome o backup antes de gravar no git", r.Path())
+	}
+	if manifest.Filter != nil {
+		return nil, fmt.Errorf("o snapshot %s tem filtro (%s); o git guarda a organizacao inteira", r.Path(), manifest.Filter)
+	}
+	if _, ok := manifest.Counts[snapshot.KindApp]; ok && manifest.Encryption == nil && !manifest.Redacted {
+		return nil, errors.New("os consumer secrets nao podem ir para o git em texto claro: use --secrets-key-file, --secrets-passphrase-env ou --redact-secrets")
+	}
+	if manifest.Encryption != nil && opts.KMS == nil {
+		return nil, errors.New("o snapshot tem secrets cifrados: informe a chave ou a senha para cifra-los no git")
+	}
+	if manifest.Organization == "" {
+		return nil, fmt.Errorf("o manifesto de %s nao tem a organizacao", r.Path())
+	}
+
+	if _, err := git(ctx, opts.Dir, nil, "rev-parse", "--show-toplevel"); err != nil {
+		return nil, fmt.Errorf("%s nao esta em um repositorio git: %v", opts.Dir, err)
+	}
+	t := &tree{root: filepath.Join(opts.Dir, manifest.Organization), kms: opts.KMS, files: map[string][]snapshot.File{}}
+	if err := os.MkdirAll(t.root, 0o755); err != nil {
+		return nil, err
+	}
+	if err := t.loadManifest(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Counts: map[string]int{}}
+	before, after := diff.NewState(), diff.NewState()
+	for _, kind := range []string{snapshot.KindDeveloper, snapshot.KindApp} {
+		if _, ok := manifest.Counts[kind]; !ok {
+			continue
+		}
+		files, err := r.FilesOfKind(kind)
+		if err != nil {
+			return nil, err
+		}
+		result.Counts[kind] = len(files)
+
+		if kind == snapshot.KindDeveloper {
+			err = t.syncDevelopers(r, files, before, after)
+		} else {
+			t.redacted = manifest.Redacted
+			err = t.syncApps(r, files, before, after)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := t.writeManifest(manifest.Organization); err != nil {
+		return nil, err
+	}
+
+	result.Report = diff.Compare(before, after)
+
+	if _, err := git(ctx, t.root, nil, "add", "-A", "--", "."); err != nil {
+		return nil, err
+	}
+	status, err := git(ctx, t.root, nil, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(status) == "" {
+		return result, nil
+	}
+
+	message := commitMessage(manifest, result)
+	if _, err := git(ctx, t.root, strings.NewReader(message), "commit", "-q", "-F", "-", "--", "."); err != nil {
+		return nil, err
+	}
+	head, err := git(ctx, t.root, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	result.Commit = strings.TrimSpace(head)
+
+	if opts.Remote != "" {
+		if _, err := git(ctx, t.root, nil, "push", "-q", opts.Remote, "HEAD"); err != nil {
+			return result, fmt.Errorf("commit %s criado, mas o push para %s falhou: %v", result.Commit, opts.Remote, err)
+		}
+	}
+	return result, nil
+}
+
+// tree e o diretorio <org> no working tree.
+type tree struct {
+	root string
+	kms  secrets.KMS
+
+	// previous e o manifesto do commit anterior, se houver; os tipos que o
+	// snapshot nao tem continuam com as entradas dele.
+	previous *snapshot.Manifest
+	// files sao as entradas do manifesto dos tipos gravados agora.
+	files    map[string][]snapshot.File
+	redacted bool
+
+	// envelope e a chave dos secrets cifrados no repositorio e sealer, ela
+	// aberta no primeiro app.
+	envelope *secrets.Envelope
+	sealer   *secrets.Sealer
+}
+
+// loadManifest le o manifesto do commit anterior. A chave dos repositorios
+// gravados antes do manifesto, em SecretsFile, passa para ele.
+func (t *tree) loadManifest() error {
+	data, err := os.ReadFile(filepath.Join(t.root, snapshot.ManifestFile))
+	if err == nil {
+		var manifest snapshot.Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Join(t.root, snapshot.ManifestFile), err)
+		}
+		t.previous = &manifest
+		t.envelope = manifest.Encryption
+		t.redacted = manifest.Redacted
+	} else if errors.Is(err, os.ErrNotExist) {
+		if err := t.scanDocuments(); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	legacy := filepath.Join(t.root, SecretsFile)
+	data, err = os.ReadFile(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t.envelope == nil {
+		var envelope secrets.Envelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return fmt.Errorf("%s: %v", legacy, err)
+		}
+		t.envelope = &envelope
+	}
+	return os.Remove(legacy)
+}
+
+// scanDocuments monta o manifesto anterior dos repositorios gravados antes
+// do manifesto a partir dos documentos que ja estao em <org>.
+func (t *tree) scanDocuments() error {
+	previous := &snapshot.Manifest{Counts: map[string]int{}}
+	for _, dir := range []struct{ kind, name string }{{snapshot.KindDeveloper, DevelopersDir}, {snapshot.KindApp, AppsDir}} {
+		files, err := existing(filepath.Join(t.root, dir.name))
+		if err != nil {
+			return err
+		}
+		for name := range files {
+			data, err := os.ReadFile(filepath.Join(t.root, dir.name, name))
+			if err != nil {
+				return err
+			}
+			previous.Counts[dir.kind]++
+			previous.Files = append(previous.Files, snapshot.File{
+				Path:   dir.name + "/" + filepath.ToSlash(name),
+				Kind:   dir.kind,
+				Size:   int64(len(data)),
+				SHA256: snapshot.Checksum(data),
+			})
+		}
+	}
+	if len(previous.Files) > 0 {
+		t.previous = previous
+	}
+	return nil
+}
+
+// writeManifest grava o manifesto de <org>: as entradas dos tipos gravados
+// agora e, dos outros, as do commit anterior. Sem ID, datas nem versao, o
+// manifesto so muda quando algum documento muda.
+func (t *tree) writeManifest(org string) error {
+	manifest := snapshot.Manifest{
+		Organization: org,
+		Counts:       map[string]int{},
+		Status:       snapshot.StatusComplete,
+		Encryption:   t.envelope,
+		Redacted:     t.redacted,
+	}
+	if t.previous != nil {
+		for kind := range t.previous.Counts {
+			manifest.Counts[kind] = 0
+		}
+		for _, f := range t.previous.Files {
+			if _, ok := t.files[f.Kind]; !ok {
+				manifest.Files = append(manifest.Files, f)
+			}
+		}
+	}
+	for kind, files := range t.files {
+		manifest.Counts[kind] = 0
+		manifest.Files = append(manifest.Files, files...)
+	}
+	for _, f := range manifest.Files {
+		manifest.Counts[f.Kind]++
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(t.root, snapshot.ManifestFile), append(data, '\n'))
+}
+
+// record registra no manifesto o documento gravado em path (relativo a
+// <org>, com "/").
+func (t *tree) record(kind, path string, data []byte, modified int64) {
+	t.files[kind] = append(t.files[kind], snapshot.File{
+		Path:     path,
+		Kind:     kind,
+		Size:     int64(len(data)),
+		SHA256:   snapshot.Checksum(data),
+		Modified: modified,
+	})
+}
+
+func (t *tree) syncDevelopers(r *snapshot.Reader, files []string, before, after *diff.State) error {
+	dir := filepath.Join(t.root, DevelopersDir)
+	old, err := existing(dir)
+	if err != nil {
+		return err
+	}
+	for name := range old {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		var developer model.DeveloperBackup
+		if err := json.Unmarshal(data, &developer); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Join(dir, name), err)
+		}
+		before.AddDeveloper(developer)
+	}
+
+	keep := map[string]bool{}
+	t.files[snapshot.KindDeveloper] = []snapshot.File{}
+	for _, name := range files {
+		developer, err := r.ReadDeveloper(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		after.AddDeveloper(developer)
+
+		data, err := snapshot.EncodeDeveloper(developer, "")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+		rel := snapshot.DeveloperPath(developer.Email)
+		path := filepath.FromSlash(rel)
+		keep[path] = true
+		if err := writeFile(filepath.Join(dir, path), data); err != nil {
+			return err
+		}
+		t.record(snapshot.KindDeveloper, DevelopersDir+"/"+rel, data, developer.LastModifiedAt)
+	}
+
+	return removeOthers(dir, old, keep)
+}
+
+func (t *tree) syncApps(r *snapshot.Reader, files []string, before, after *diff.State) error {
+	dir := filepath.Join(t.root, AppsDir)
+	old, err := existing(dir)
+	if err != nil {
+		return err
+	}
+
+	// Os secrets do commit anterior, abertos e cifrados, para manter o texto
+	// cifrado dos que nao mudaram.
+	previous := map[string]sealedSecret{}
+	for name := range old {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		var app model.AppBackup
+		if err := yaml.Unmarshal(data, &app); err != nil {
+			return fmt.Errorf("%s: %v", filepath.Join(dir, name), err)
+		}
+		for i, cred := range app.Credentials {
+			if !secrets.IsSealed(cred.ConsumerSecret) {
+				continue
+			}
+			if err := t.openSealer(); err != nil {
+				return err
+			}
+			plain, err := t.sealer.Open(cred.ConsumerSecret, cred.ConsumerKey)
+			if err != nil {
+				return fmt.Errorf("%s: consumerSecret de %s: %v", filepath.Join(dir, name), cred.ConsumerKey, err)
+			}
+			previous[name+"\x00"+cred.ConsumerKey] = sealedSecret{plain: plain, sealed: cred.ConsumerSecret}
+			app.Credentials[i].ConsumerSecret = plain
+		}
+		before.AddApp(app)
+	}
+
+	if r.Encrypted() {
+		r.SetKMS(t.kms)
+	}
+
+	keep := map[string]bool{}
+	t.files[snapshot.KindApp] = []snapshot.File{}
+	for _, name := range files {
+		app, err := r.ReadApp(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for i, cred := range app.Credentials {
+			if secrets.IsRedacted(cred.ConsumerSecret) {
+				app.Credentials[i].ConsumerSecret = ""
+			}
+		}
+		after.AddApp(app)
+
+		rel := snapshot.AppPath(app.DeveloperID, app.Name)
+		path := filepath.FromSlash(strings.TrimPrefix(rel, AppsDir+"/"))
+		keep[path] = true
+
+		app.Credentials = append([]model.Credential(nil), app.Credentials...)
+		for i, cred := range app.Credentials {
+			if cred.ConsumerSecret == "" {
+				continue
+			}
+			old := previous[path+"\x00"+cred.ConsumerKey]
+			if old.sealed != "" && old.plain == cred.ConsumerSecret {
+				app.Credentials[i].ConsumerSecret = old.sealed
+				continue
+			}
+			app.Credentials[i].ConsumerSecret, err = t.seal(cred)
+			if err != nil {
+				return err
+			}
+		}
+
+		data, err := snapshot.EncodeApp(app, "")
+		if err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(dir, path), data); err != nil {
+			return err
+		}
+		t.record(snapshot.KindApp, rel, data, app.LastModifiedAt)
+	}
+
+	return removeOthers(dir, old, keep)
+}
+
+// sealedSecret e um consumer secret do commit anterior.
+type sealedSecret struct {
+	plain  string
+	sealed string
+}
+
+// seal cifra o secret com a chave do repositorio.
+func (t *tree) seal(cred model.Credential) (string, error) {
+	if err := t.openSealer(); err != nil {
+		return "", err
+	}
+	sealed, err := t.sealer.Seal(cred.ConsumerSecret, cred.ConsumerKey)
+	if err != nil {
+		return "", fmt.Errorf("erro ao cifrar o consumerSecret de %s: %v", cred.ConsumerKey, err)
+	}
+	return sealed, nil
+}
+
+// openSealer abre a chave do repositorio, guardada no manifesto, ou, na
+// primeira vez, cria uma nova com o KMS.
+func (t *tree) openSealer() error {
+	if t.sealer != nil {
+		return nil
+	}
+	if t.kms == nil {
+		return errors.New("o repositorio tem secrets cifrados: informe a chave ou a senha")
+	}
+
+	if t.envelope == nil {
+		sealer, err := secrets.NewSealer(t.kms)
+		if err != nil {
+			return err
+		}
+		envelope := sealer.Envelope()
+		t.envelope = &envelope
+		t.sealer = sealer
+		return nil
+	}
+
+	sealer, err := secrets.OpenEnvelope(t.kms, *t.envelope)
+	if err != nil {
+		return fmt.Errorf("a chave informada nao abre a chave do repositorio em %s: %v", filepath.Join(t.root, snapshot.ManifestFile), err)
+	}
+	t.sealer = sealer
+	return nil
+}
+
+// existing lista os arquivos em dir, relativos a ele.
+func existing(dir string) (map[string]bool, error) {
+	files := map[string]bool{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	return files, err
+}
+
+// removeOthers apaga de dir os arquivos de old que o snapshot nao tem mais,
+// e os diretorios que ficarem vazios.
+func removeOthers(dir string, old, keep map[string]bool) error {
+	for name := range old {
+		if keep[name] {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		for parent := filepath.Dir(path); parent != dir; parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// commitMessage resume o backup: organizacao, ID e mudancas no titulo, as
+// contagens e o relatorio do diff no corpo.
+func commitMessage(manifest *snapshot.Manifest, result *Result) string {
+	var kinds []string
+	for kind := range result.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var changes []string
+	for _, section := range []struct {
+		label   string
+		changes []diff.Change
+	}{{"developers", result.Report.Developers}, {"apps", result.Report.Apps}} {
+		counts := diff.Counts(section.changes)
+		if len(section.changes) > 0 {
+			changes = append(changes, fmt.Sprintf("%s +%d -%d ~%d", section.label, counts[diff.Added], counts[diff.Removed], counts[diff.Modified]))
+		}
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "sem mudancas")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Backup %s %s: %s\n\n", manifest.Organization, manifest.ID, strings.Join(changes, ", "))
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "%s: %d\n", kind, result.Counts[kind])
+	}
+
+	report := *result.Report
+	report.From, report.To = "HEAD", manifest.ID
+	if !report.Empty() {
+		b.WriteString("\n")
+		diff.Write(&b, &report, diff.FormatText)
+	}
+	return b.String()
+}
+
+// git roda o git em dir e devolve a saida padrao.
+func git(ctx context.Context, dir string, stdin *strings.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
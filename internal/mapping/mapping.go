@@ -0,0 +1,232 @@
+// Package mapping renomeia as referencias de um snapshot para restaurar em
+// outra organizacao, como um backup de producao em uma org de staging cujos
+// API products tem outro sufixo.
+package mapping
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/model"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Mapping e o arquivo de mapeamento (YAML ou JSON). Exemplo:
+//
+//	products:
+//	  payments: payments-stg
+//	environments:
+//	  prod: staging
+//	developers:
+//	  alice@example.com: alice@staging.example.com
+//	attributes:
+//	  callbackUrl:
+//	    https://api.example.com/cb: https://api.staging.example.com/cb
+//
+// O nome de um ambiente e trocado onde aparece como palavra inteira (entre
+// caracteres que nao sao letra ou numero) nos nomes de API products sem
+// mapeamento proprio e nos valores de atributos: payments-prod vira
+// payments-staging.
+type Mapping struct {
+	Products     map[string]string            `yaml:"products" json:"products"`
+	Environments map[string]string            `yaml:"environments" json:"environments"`
+	Developers   map[string]string            `yaml:"developers" json:"developers"`
+	Attributes   map[string]map[string]string `yaml:"attributes" json:"attributes"`
+
+	// AllowUnmapped deixa restaurar com as referencias sem mapeamento com o
+	// nome original.
+	AllowUnmapped bool `yaml:"-" json:"-"`
+}
+
+// Load le o arquivo de mapeamento.
+func Load(path string) (*Mapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Mapping
+	if err := yaml.UnmarshalStrict(data, &m); err != nil {
+		return nil, fmt.Errorf("arquivo de mapeamento %s invalido: %v", path, err)
+	}
+	return &m, nil
+}
+
+// Flags registra --mapping e --allow-unmapped no FlagSet padrao. A funcao
+// devolvida le o arquivo depois do flag.Parse, ou devolve nil se --mapping
+// nao foi informado.
+func Flags() func() (*Mapping, error) {
+	path := flag.String("mapping", "", "Arquivo YAML que renomeia API products, ambientes, emails de developers e valores de atributos")
+	allowUnmapped := flag.Bool("allow-unmapped", false, "Restaura mesmo com referencias fora do --mapping, mantendo o nome original")
+
+	return func() (*Mapping, error) {
+		if *path == "" {
+			return nil, nil
+		}
+		m, err := Load(*path)
+		if err != nil {
+			return nil, err
+		}
+		m.AllowUnmapped = *allowUnmapped
+		return m, nil
+	}
+}
+
+// Product devolve o nome do API product na organizacao de destino e se ele
+// e coberto pelo mapeamento.
+func (m *Mapping) Product(name string) (string, bool) {
+	if to, ok := m.Products[name]; ok {
+		return to, true
+	}
+	if to, ok := m.replaceEnvironments(name); ok {
+		return to, true
+	}
+	return name, len(m.Products) == 0 && len(m.Environments) == 0
+}
+
+// Developer devolve o email do developer na organizacao de destino e se ele
+// e coberto pelo mapeamento. Emails sao comparados sem diferenciar
+// maiusculas, como na API.
+func (m *Mapping) Developer(email string) (string, bool) {
+	for from, to := range m.Developers {
+		if strings.EqualFold(from, email) {
+			return to, true
+		}
+	}
+	return email, len(m.Developers) == 0
+}
+
+// Attribute devolve o valor do atributo na organizacao de destino e se ele e
+// coberto pelo mapeamento. So os atributos listados em attributes precisam
+// de mapeamento.
+func (m *Mapping) Attribute(name, value string) (string, bool) {
+	values, listed := m.Attributes[name]
+	if to, ok := values[value]; ok {
+		return to, true
+	}
+	to, _ := m.replaceEnvironments(value)
+	if !listed {
+		return to, true
+	}
+	return to, to != value
+}
+
+// App devolve o app com as referencias renomeadas. Sem mapeamento (nil)
+// devolve o app como esta.
+func (m *Mapping) App(app model.AppBackup) model.AppBackup {
+	if m == nil {
+		return app
+	}
+	app.DeveloperID, _ = m.Developer(app.DeveloperID)
+
+	attributes := make([]model.Attribute, len(app.Attributes))
+	for i, attr := range app.Attributes {
+		attributes[i] = attr
+		attributes[i].Value, _ = m.Attribute(attr.Name, attr.Value)
+	}
+	if app.Attributes != nil {
+		app.Attributes = attributes
+	}
+
+	credentials := make([]model.Credential, len(app.Credentials))
+	for i, cred := range app.Credentials {
+		products := make([]model.APIProductRef, len(cred.APIProducts))
+		for j, product := range cred.APIProducts {
+			products[j] = product
+			products[j].APIProduct, _ = m.Product(product.APIProduct)
+		}
+		cred.APIProducts = products
+		credentials[i] = cred
+	}
+	if app.Credentials != nil {
+		app.Credentials = credentials
+	}
+	return app
+}
+
+// DeveloperDoc devolve o developer com o email renomeado. O id e a
+// organizacao sao da org de origem e ficam vazios. Sem mapeamento (nil)
+// devolve o developer como esta.
+func (m *Mapping) DeveloperDoc(developer model.DeveloperBackup) model.DeveloperBackup {
+	if m == nil {
+		return developer
+	}
+	developer.Email, _ = m.Developer(developer.Email)
+	developer.DeveloperID = ""
+	developer.OrganizationName = ""
+	developer.Apps = append([]string(nil), developer.Apps...)
+	return developer
+}
+
+// Unmapped lista, sem repeticao e em ordem, as referencias dos documentos
+// que o mapeamento nao cobre.
+func (m *Mapping) Unmapped(developers []model.DeveloperBackup, apps []model.AppBackup) []string {
+	found := map[string]bool{}
+	for _, developer := range developers {
+		if _, ok := m.Developer(developer.Email); !ok {
+			found["developer "+developer.Email] = true
+		}
+	}
+	for _, app := range apps {
+		if _, ok := m.Developer(app.DeveloperID); !ok {
+			found["developer "+app.DeveloperID] = true
+		}
+		for _, attr := range app.Attributes {
+			if _, ok := m.Attribute(attr.Name, attr.Value); !ok {
+				found[fmt.Sprintf("atributo %s=%s", attr.Name, attr.Value)] = true
+			}
+		}
+		for _, cred := range app.Credentials {
+			for _, product := range cred.APIProducts {
+				if _, ok := m.Product(product.APIProduct); !ok {
+					found["API product "+product.APIProduct] = true
+				}
+			}
+		}
+	}
+
+	list := make([]string, 0, len(found))
+	for ref := range found {
+		list = append(list, ref)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// replaceEnvironments troca os nomes de ambiente que aparecem como palavra
+// inteira em value.
+func (m *Mapping) replaceEnvironments(value string) (string, bool) {
+	if len(m.Environments) == 0 {
+		return value, false
+	}
+
+	var out strings.Builder
+	changed := false
+	for i := 0; i < len(value); {
+		if !isWordChar(value[i]) {
+			out.WriteByte(value[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(value) && isWordChar(value[j]) {
+			j++
+		}
+		word := value[i:j]
+		if to, ok := m.Environments[word]; ok {
+			word = to
+			changed = true
+		}
+		out.WriteString(word)
+		i = j
+	}
+	return out.String(), changed
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,104 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+)
+
+func TestApp(t *testing.T) {
+	m := &Mapping{
+		Products:     map[string]string{"payments": "payments-stg"},
+		Environments: map[string]string{"prod": "staging"},
+		Developers:   map[string]string{"Alice@Example.com": "alice@staging.example.com"},
+		Attributes:   map[string]map[string]string{"tier": {"gold": "silver"}},
+	}
+
+	app := model.AppBackup{
+		Name:        "mobile",
+		DeveloperID: "alice@example.com",
+		Attributes: []model.Attribute{
+			{Name: "tier", Value: "gold"},
+			{Name: "callback", Value: "https://prod.api.example.com/cb"},
+			{Name: "note", Value: "production"},
+		},
+		Credentials: []model.Credential{{
+			ConsumerKey: "k1",
+			APIProducts: []model.APIProductRef{{APIProduct: "payments", Status: "approved"}, {APIProduct: "catalog-prod", Status: "revoked"}},
+		}},
+	}
+
+	got := m.App(app)
+	if got.DeveloperID != "alice@staging.example.com" {
+		t.Errorf("developerId = %s", got.DeveloperID)
+	}
+	wantAttrs := []model.Attribute{
+		{Name: "tier", Value: "silver"},
+		{Name: "callback", Value: "https://staging.api.example.com/cb"},
+		// So palavras inteiras: production nao e o ambiente prod.
+		{Name: "note", Value: "production"},
+	}
+	if !reflect.DeepEqual(got.Attributes, wantAttrs) {
+		t.Errorf("atributos = %+v", got.Attributes)
+	}
+	wantProducts := []model.APIProductRef{{APIProduct: "payments-stg", Status: "approved"}, {APIProduct: "catalog-staging", Status: "revoked"}}
+	if !reflect.DeepEqual(got.Credentials[0].APIProducts, wantProducts) {
+		t.Errorf("products = %+v", got.Credentials[0].APIProducts)
+	}
+
+	// O documento original nao muda.
+	if app.Credentials[0].APIProducts[0].APIProduct != "payments" || app.Attributes[0].Value != "gold" {
+		t.Errorf("o app original foi alterado: %+v", app)
+	}
+}
+
+func TestUnmapped(t *testing.T) {
+	m := &Mapping{
+		Products:   map[string]string{"payments": "payments-stg"},
+		Developers: map[string]string{"alice@example.com": "alice@staging.example.com"},
+		Attributes: map[string]map[string]string{"tier": {"gold": "silver"}},
+	}
+
+	developers := []model.DeveloperBackup{{Email: "alice@example.com"}, {Email: "bob@example.com"}}
+	apps := []model.AppBackup{{
+		Name:        "web",
+		DeveloperID: "bob@example.com",
+		Attributes:  []model.Attribute{{Name: "tier", Value: "bronze"}, {Name: "DisplayName", Value: "Web"}},
+		Credentials: []model.Credential{{APIProducts: []model.APIProductRef{{APIProduct: "payments"}, {APIProduct: "catalog"}}}},
+	}}
+
+	want := []string{"API product catalog", "atributo tier=bronze", "developer bob@example.com"}
+	if got := m.Unmapped(developers, apps); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unmapped = %v, esperado %v", got, want)
+	}
+
+	// Secoes vazias nao exigem mapeamento.
+	if got := (&Mapping{}).Unmapped(developers, apps); len(got) != 0 {
+		t.Errorf("mapeamento vazio: %v", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.yaml")
+	data := "products:\n  payments: payments-stg\ndevelopers:\n  alice@example.com: alice@staging.example.com\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to, ok := m.Product("payments"); !ok || to != "payments-stg" {
+		t.Errorf("Product = %s %v", to, ok)
+	}
+
+	if err := os.WriteFile(path, []byte("produtos:\n  a: b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load aceitou uma secao desconhecida")
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"sort"
+)
+
+// Canonical devolve uma copia do app com as listas na ordem canonica, que nao
+// depende da ordem da resposta da API: atributos pelo nome, credentials pelo
+// consumerKey e produtos de cada credential pelo nome. Dois estados iguais da
+// organizacao geram o mesmo documento.
+func (a AppBackup) Canonical() AppBackup {
+	a.Attributes = sortedAttributes(a.Attributes)
+
+	if a.Credentials != nil {
+		credentials := make([]Credential, len(a.Credentials))
+		for i, cred := range a.Credentials {
+			products := append([]APIProductRef(nil), cred.APIProducts...)
+			sort.SliceStable(products, func(i, j int) bool {
+				if products[i].APIProduct != products[j].APIProduct {
+					return products[i].APIProduct < products[j].APIProduct
+				}
+				return products[i].Status < products[j].Status
+			})
+			if cred.APIProducts != nil {
+				cred.APIProducts = products
+			}
+			credentials[i] = cred
+		}
+		sort.SliceStable(credentials, func(i, j int) bool {
+			if credentials[i].ConsumerKey != credentials[j].ConsumerKey {
+				return credentials[i].ConsumerKey < credentials[j].ConsumerKey
+			}
+			return credentials[i].IssuedAt < credentials[j].IssuedAt
+		})
+		a.Credentials = credentials
+	}
+
+	return a
+}
+
+// Canonical devolve uma copia do developer com os apps em ordem alfabetica.
+// Sem apps, a lista fica vazia ([]) e nao nula, para um developer sem apps
+// gerar sempre o mesmo JSON.
+func (d DeveloperBackup) Canonical() DeveloperBackup {
+	apps := append([]string{}, d.Apps...)
+	sort.Strings(apps)
+	d.Apps = apps
+	return d
+}
+
+func sortedAttributes(attributes []Attribute) []Attribute {
+	if attributes == nil {
+		return nil
+	}
+	sorted := append([]Attribute(nil), attributes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"google.golang.org/api/apigee/v1"
+)
+
+type Attribute struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+type APIProductRef struct {
+	APIProduct string `json:"apiproduct" yaml:"apiproduct"`
+	Status     string `json:"status" yaml:"status"`
+}
+
+type Credential struct {
+	APIProducts    []APIProductRef `json:"apiProducts" yaml:"apiProducts"`
+	ConsumerKey    string          `json:"consumerKey" yaml:"consumerKey"`
+	ConsumerSecret string          `json:"consumerSecret" yaml:"consumerSecret"`
+	ExpiresAt      int64           `json:"expiresAt" yaml:"expiresAt"`
+	IssuedAt       int64           `json:"issuedAt" yaml:"issuedAt"`
+	Status         string          `json:"status" yaml:"status"`
+}
+
+// AppBackup e o documento gravado por app no backup (YAML, ou JSON nos
+// formatos json e ndjson).
+type AppBackup struct {
+	AppID          string       `json:"appId" yaml:"appId"`
+	Attributes     []Attribute  `json:"attributes" yaml:"attributes"`
+	CreatedAt      int64        `json:"createdAt" yaml:"createdAt"`
+	Credentials    []Credential `json:"credentials" yaml:"credentials"`
+	DeveloperID    string       `json:"developerId" yaml:"developerId"`
+	LastModifiedAt int64        `json:"lastModifiedAt" yaml:"lastModifiedAt"`
+	Name           string       `json:"name" yaml:"name"`
+	Status         string       `json:"status" yaml:"status"`
+	AppFamily      string       `json:"appFamily" yaml:"appFamily"`
+}
+
+// DeveloperBackup e o documento gravado por developer no backup (JSON, ou
+// YAML no formato yaml).
+type DeveloperBackup struct {
+	Email            string   `json:"email" yaml:"email"`
+	FirstName        string   `json:"firstName" yaml:"firstName"`
+	LastName         string   `json:"lastName" yaml:"lastName"`
+	UserName         string   `json:"userName" yaml:"userName"`
+	Apps             []string `json:"apps" yaml:"apps"`
+	DeveloperID      string   `json:"developerId" yaml:"developerId"`
+	OrganizationName string   `json:"organizationName" yaml:"organizationName"`
+	Status           string   `json:"status" yaml:"status"`
+	CreatedAt        int64    `json:"createdAt" yaml:"createdAt"`
+	LastModifiedAt   int64    `json:"lastModifiedAt" yaml:"lastModifiedAt"`
+}
+
+// AppFromApigee converte o app retornado pela API. O DeveloperID do backup e o
+// email do developer, que e o que a API aceita no path na hora do restore.
+func AppFromApigee(app *apigee.GoogleCloudApigeeV1DeveloperApp, developerEmail string) AppBackup {
+	var attributes []Attribute
+	for _, attr := range app.Attributes {
+		attributes = append(attributes, Attribute{
+			Name:  attr.Name,
+			Value: attr.Value,
+		})
+	}
+
+	var credentials []Credential
+	for _, cred := range app.Credentials {
+		apiProducts := make([]APIProductRef, len(cred.ApiProducts))
+		for i, product := range cred.ApiProducts {
+			apiProducts[i] = APIProductRef{
+				APIProduct: product.Apiproduct,
+				Status:     product.Status,
+			}
+		}
+
+		credentials = append(credentials, Credential{
+			APIProducts:    apiProducts,
+			ConsumerKey:    cred.ConsumerKey,
+			ConsumerSecret: cred.ConsumerSecret,
+			ExpiresAt:      cred.ExpiresAt,
+			IssuedAt:       cred.IssuedAt,
+			Status:         cred.Status,
+		})
+	}
+
+	return AppBackup{
+		AppID:          app.AppId,
+		Attributes:     attributes,
+		CreatedAt:      app.CreatedAt,
+		Credentials:    credentials,
+		DeveloperID:    developerEmail,
+		LastModifiedAt: app.LastModifiedAt,
+		Name:           app.Name,
+		Status:         app.Status,
+		AppFamily:      app.AppFamily,
+	}
+}
+
+func DeveloperFromApigee(developer *apigee.GoogleCloudApigeeV1Developer) DeveloperBackup {
+	return DeveloperBackup{
+		Email:            developer.Email,
+		FirstName:        developer.FirstName,
+		LastName:         developer.LastName,
+		UserName:         developer.UserName,
+		Apps:             developer.Apps,
+		DeveloperID:      developer.DeveloperId,
+		OrganizationName: developer.OrganizationName,
+		Status:           developer.Status,
+		CreatedAt:        developer.CreatedAt,
+		LastModifiedAt:   developer.LastModifiedAt,
+	}
+}
+
+func (d DeveloperBackup) ToApigee() *apigee.GoogleCloudApigeeV1Developer {
+	return &apigee.GoogleCloudApigeeV1Developer{
+		Email:            d.Email,
+		UserName:         d.UserName,
+		FirstName:        d.FirstName,
+		LastName:         d.LastName,
+		Apps:             d.Apps,
+		DeveloperId:      d.DeveloperID,
+		OrganizationName: d.OrganizationName,
+		Status:           d.Status,
+		CreatedAt:        d.CreatedAt,
+		LastModifiedAt:   d.LastModifiedAt,
+	}
+}
+
+func ConvertAttributes(attributes []Attribute) []*apigee.GoogleCloudApigeeV1Attribute {
+	apiAttributes := make([]*apigee.GoogleCloudApigeeV1Attribute, 0, len(attributes))
+	for _, attr := range attributes {
+		apiAttributes = append(apiAttributes, &apigee.GoogleCloudApigeeV1Attribute{
+			Name:  attr.Name,
+			Value: attr.Value,
+		})
+	}
+	return apiAttributes
+}
+
+// ProductNames devolve os nomes dos API products associados a credencial.
+func (c Credential) ProductNames() []string {
+	names := make([]string, 0, len(c.APIProducts))
+	for _, product := range c.APIProducts {
+		names = append(names, product.APIProduct)
+	}
+	return names
+}
@@ -0,0 +1,163 @@
+package restore
+
+import (
+	"fmt"
+	"sort"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Fases do restore all, na ordem em que rodam: cada uma depende das anteriores.
+const (
+	PhaseProducts   = "products"
+	PhaseDevelopers = "developers"
+	PhaseApps       = "apps"
+	PhaseKeys       = "keys"
+	PhaseBindings   = "bindings"
+	PhaseStatuses   = "statuses"
+)
+
+var phases = []string{PhaseProducts, PhaseDevelopers, PhaseApps, PhaseKeys, PhaseBindings, PhaseStatuses}
+
+func phaseOf(action string) string {
+	switch action {
+	case ActionCheckProduct:
+		return PhaseProducts
+	case ActionCreateDeveloper:
+		return PhaseDevelopers
+	case ActionCreateApp, ActionDeleteDefaultKey:
+		return PhaseApps
+	case ActionImportKey:
+		return PhaseKeys
+	case ActionAssociateProducts:
+		return PhaseBindings
+	}
+	return PhaseStatuses
+}
+
+// AllPlan monta o plano do restore completo da organizacao a partir dos
+// snapshots (normalmente o de developers e o de apps do mesmo backup), em
+// fases: API products, developers, apps, chaves, associacoes das chaves com
+// os products e, por ultimo, os status.
+//
+// O backup nao guarda os API products, entao a primeira fase so confere que
+// os products usados pelas chaves existem na organizacao; as associacoes com
+// um product que nao existe sao puladas.
+func AllPlan(org string, live *diff.State, opts Options, snaps ...*snapshot.Reader) (*Plan, error) {
+	want, err := loadState(snaps, opts.Filter, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := snapshotPlan(org, want, live, opts, snaps)
+	if err != nil {
+		return nil, err
+	}
+
+	checked := map[string]bool{}
+	for _, step := range plan.Steps {
+		if step.Action != ActionAssociateProducts {
+			continue
+		}
+		for _, product := range step.Products {
+			checked[product] = true
+		}
+	}
+	for _, product := range sortedKeys(checked) {
+		plan.Steps = append(plan.Steps, Step{Action: ActionCheckProduct, Products: []string{product}})
+	}
+
+	statuses, warnings := statusSteps(want, live)
+	plan.Steps = append(plan.Steps, statuses...)
+	plan.Warnings = append(plan.Warnings, warnings...)
+
+	order := map[string]int{}
+	for i, phase := range phases {
+		order[phase] = i
+	}
+	sort.SliceStable(plan.Steps, func(i, j int) bool {
+		return order[phaseOf(plan.Steps[i].Action)] < order[phaseOf(plan.Steps[j].Action)]
+	})
+	return plan, nil
+}
+
+// statusSteps devolve os passos que deixam developers, apps, chaves e
+// associacoes com o status do backup. O que ja existe so muda se o status
+// for diferente; o que vai ser criado recebe o status explicitamente, ja que
+// o padrao da API depende da configuracao do product.
+func statusSteps(want, live *diff.State) ([]Step, []string) {
+	var steps []Step
+	var warnings []string
+	add := func(step Step) {
+		if _, err := statusAction(step); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", step, err))
+			return
+		}
+		steps = append(steps, step)
+	}
+
+	for _, key := range sortedKeys(want.Developers) {
+		developer := want.Developers[key]
+		current, exists := live.Developers[key]
+		if developer.Status != "" && (!exists || current.Status != developer.Status) {
+			add(Step{Action: ActionDeveloperStatus, Developer: developer.Email, Status: developer.Status})
+		}
+	}
+
+	for _, key := range sortedKeys(want.Apps) {
+		app := want.Apps[key]
+		current, exists := live.Apps[key]
+		if app.Status != "" && (!exists || current.Status != app.Status) {
+			add(Step{Action: ActionAppStatus, Developer: app.DeveloperID, App: app.Name, Status: app.Status})
+		}
+
+		for _, cred := range app.Credentials {
+			var liveCred *model.Credential
+			if exists {
+				liveCred, _ = findCredential(current, cred.ConsumerKey)
+			}
+			if cred.Status != "" && (liveCred == nil || liveCred.Status != cred.Status) {
+				add(Step{Action: ActionKeyStatus, Developer: app.DeveloperID, App: app.Name, Key: cred.ConsumerKey, Status: cred.Status})
+			}
+
+			for _, product := range cred.APIProducts {
+				if product.Status == "" || productStatus(liveCred, product.APIProduct) == product.Status {
+					continue
+				}
+				add(Step{Action: ActionProductStatus, Developer: app.DeveloperID, App: app.Name, Key: cred.ConsumerKey, Products: []string{product.APIProduct}, Status: product.Status})
+			}
+		}
+	}
+	return steps, warnings
+}
+
+func productStatus(cred *model.Credential, product string) string {
+	if cred == nil {
+		return ""
+	}
+	for _, ref := range cred.APIProducts {
+		if ref.APIProduct == product {
+			return ref.Status
+		}
+	}
+	return ""
+}
+
+// statusAction traduz o status do backup na action da API.
+func statusAction(step Step) (string, error) {
+	if step.Action == ActionDeveloperStatus {
+		switch step.Status {
+		case "active", "inactive":
+			return step.Status, nil
+		}
+	} else {
+		switch step.Status {
+		case "approved":
+			return "approve", nil
+		case "revoked":
+			return "revoke", nil
+		}
+	}
+	return "", fmt.Errorf("status %s nao pode ser aplicado pela API", step.Status)
+}
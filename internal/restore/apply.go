@@ -0,0 +1,444 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Politicas para quando um passo falha.
+const (
+	// PolicyFailFast para no primeiro erro; os passos seguintes ficam de fora.
+	PolicyFailFast = "fail-fast"
+	// PolicyContinue segue com os passos que nao dependem do que falhou.
+	PolicyContinue = "continue"
+)
+
+// Output recebe o progresso do ApplyPlan. Com o relatorio em JSON no stdout,
+// os comandos mandam para o stderr.
+var Output io.Writer = os.Stdout
+
+// Resultado de cada passo no Report.
+const (
+	ResultOK      = "ok"
+	ResultFailed  = "failed"
+	ResultSkipped = "skipped"
+)
+
+// Report e o resultado da execucao de um plano, um item por passo.
+type Report struct {
+	Organization string   `json:"organization"`
+	Results      []Result `json:"results"`
+	// Existing e o que o plano pulou por ja existir na organizacao.
+	Existing []string `json:"existing,omitempty"`
+	// RolledBack indica que as alteracoes foram desfeitas depois da falha
+	// (Options.Rollback).
+	RolledBack bool `json:"rolledBack,omitempty"`
+}
+
+// Result e o que aconteceu com um passo. Error explica a falha ou por que o
+// passo foi pulado.
+type Result struct {
+	Phase  string `json:"phase"`
+	Step   Step   `json:"step"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Count devolve quantos passos terminaram com o status.
+func (r *Report) Count(status string) int {
+	var n int
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// ApplyPlan executa os passos do plano na ordem, lendo developers e
+// credenciais dos snapshots do plano. Com PolicyFailFast (o padrao) para no
+// primeiro erro; com PolicyContinue pula so os passos que dependem do que
+// falhou, como as chaves de um app que nao foi criado. O erro devolvido
+// resume as falhas; o Report tem o detalhe de cada passo. Com opts.Rollback,
+// uma execucao com falha e desfeita pelo opts.Journal.
+func ApplyPlan(ctx context.Context, client *apigeeclient.Client, plan *Plan, snaps []*snapshot.Reader, opts Options) (*Report, error) {
+	switch opts.Policy {
+	case "", PolicyFailFast, PolicyContinue:
+	default:
+		return nil, fmt.Errorf("politica desconhecida: %s (use %s ou %s)", opts.Policy, PolicyFailFast, PolicyContinue)
+	}
+
+	if plan.Mapping != nil {
+		opts.Mapping = plan.Mapping
+	}
+	docs, err := loadDocuments(snaps, plan, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Organization: plan.Organization, Existing: plan.Skipped}
+	mark := opts.Journal.mark()
+	broken := map[string]bool{}
+	stopped := ""
+
+	for i, step := range plan.Steps {
+		result := Result{Phase: phaseOf(step.Action), Step: step}
+
+		if opts.Done[i] {
+			fmt.Fprintf(Output, "%d. %s: feito na execucao anterior\n", i+1, step)
+			result.Status = ResultOK
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		interrupted := stopped != ""
+		if interrupted {
+			result.Status, result.Error = ResultSkipped, stopped
+		} else if dep := brokenDependency(step, broken); dep != "" {
+			result.Status, result.Error = ResultSkipped, "depende de "+dep+", que falhou"
+		} else if err := applyStep(ctx, client, plan.Organization, step, docs, opts); err != nil {
+			result.Status, result.Error = ResultFailed, err.Error()
+			if opts.Policy != PolicyContinue {
+				stopped = fmt.Sprintf("interrompido depois da falha do passo %d", i+1)
+			}
+		} else {
+			result.Status = ResultOK
+		}
+
+		if result.Status != ResultOK {
+			if resource := step.resource(); resource != "" {
+				broken[resource] = true
+			}
+		}
+		switch {
+		case result.Status == ResultOK:
+			fmt.Fprintf(Output, "%d. %s: ok\n", i+1, step)
+		case result.Status == ResultFailed:
+			fmt.Fprintf(Output, "%d. %s: falhou: %s\n", i+1, step, result.Error)
+		case !interrupted:
+			fmt.Fprintf(Output, "%d. %s: pulado: %s\n", i+1, step, result.Error)
+		}
+		report.Results = append(report.Results, result)
+
+		if opts.Progress != nil && !interrupted {
+			if err := opts.Progress(i, result); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if failed := report.Count(ResultFailed); failed > 0 {
+		err := fmt.Errorf("%d passo(s) falharam e %d foram pulados", failed, report.Count(ResultSkipped))
+		if opts.Rollback && opts.Journal.mark() > mark {
+			fmt.Fprintf(Output, "Desfazendo as alteracoes desta execucao\n")
+			if rollbackErr := opts.Journal.rollbackFrom(ctx, client, mark); rollbackErr != nil {
+				return report, fmt.Errorf("%v; o rollback tambem falhou: %v", err, rollbackErr)
+			}
+			report.RolledBack = true
+		}
+		return report, err
+	}
+	return report, nil
+}
+
+// resource identifica o que o passo cria ou altera, para os passos que
+// dependem dele. Os de status nao tem dependentes.
+func (s Step) resource() string {
+	app := diff.AppKey(s.Developer, s.App)
+	switch s.Action {
+	case ActionCheckProduct:
+		return "API product " + s.Products[0]
+	case ActionCreateDeveloper:
+		return "developer " + diff.DeveloperKey(s.Developer)
+	case ActionCreateApp, ActionDeleteDefaultKey:
+		return "app " + app
+	case ActionImportKey:
+		return "chave " + app + "/" + s.Key
+	case ActionAssociateProducts:
+		return "associacao " + app + "/" + s.Key
+	}
+	return ""
+}
+
+// dependencies sao os recursos que precisam existir antes do passo.
+func (s Step) dependencies() []string {
+	developer := "developer " + diff.DeveloperKey(s.Developer)
+	app := "app " + diff.AppKey(s.Developer, s.App)
+	key := "chave " + diff.AppKey(s.Developer, s.App) + "/" + s.Key
+	var products []string
+	for _, product := range s.Products {
+		products = append(products, "API product "+product)
+	}
+
+	switch s.Action {
+	case ActionCreateApp:
+		return []string{developer}
+	case ActionDeleteDefaultKey, ActionImportKey:
+		return []string{app}
+	case ActionAssociateProducts:
+		return append([]string{app, key}, products...)
+	case ActionDeveloperStatus:
+		return []string{developer}
+	case ActionAppStatus:
+		return []string{developer, app}
+	case ActionKeyStatus:
+		return []string{app, key}
+	case ActionProductStatus:
+		return append([]string{app, key, "associacao " + diff.AppKey(s.Developer, s.App) + "/" + s.Key}, products...)
+	}
+	return nil
+}
+
+func brokenDependency(step Step, broken map[string]bool) string {
+	for _, dep := range step.dependencies() {
+		if broken[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+func applyStep(ctx context.Context, client *apigeeclient.Client, org string, step Step, docs *diff.State, opts Options) error {
+	appKey := diff.AppKey(step.Developer, step.App)
+	apps := client.Service.Organizations.Developers.Apps
+	journal := opts.Journal
+
+	switch step.Action {
+	case ActionCheckProduct:
+		_, err := client.Service.Organizations.Apiproducts.Get("organizations/" + org + "/apiproducts/" + step.Products[0]).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("API product %s nao encontrado na organizacao: %v", step.Products[0], err)
+		}
+		return nil
+
+	case ActionCreateDeveloper:
+		developer, ok := docs.Developers[diff.DeveloperKey(step.Developer)]
+		if !ok {
+			return fmt.Errorf("developer nao encontrado no snapshot")
+		}
+		if err := Developer(ctx, client, org, developer); err != nil {
+			return err
+		}
+		return journal.record(org, step, "")
+
+	case ActionCreateApp:
+		app, ok := docs.Apps[appKey]
+		if !ok {
+			return fmt.Errorf("app nao encontrado no snapshot")
+		}
+		_, err := newApp(ctx, client.Service, org, app, journal)
+		return err
+
+	case ActionDeleteDefaultKey:
+		app, err := apps.Get(appPath(org, step.Developer, step.App)).Context(ctx).Do()
+		if err != nil {
+			return err
+		}
+		return deleteDefaultKeys(ctx, client.Service, org, step.Developer, step.App, app.Credentials, journal)
+
+	case ActionImportKey:
+		app, ok := docs.Apps[appKey]
+		if !ok {
+			return fmt.Errorf("app nao encontrado no snapshot")
+		}
+		cred, ok := findCredential(app, step.Key)
+		if !ok {
+			return fmt.Errorf("chave nao encontrada no snapshot")
+		}
+		resolved, err := resolveRedacted(app.Name, []model.Credential{*cred}, opts)
+		if err != nil {
+			return err
+		}
+		return importKey(ctx, client.Service, org, step.Developer, step.App, resolved[0], journal)
+
+	case ActionAssociateProducts:
+		return associateKeyToProducts(ctx, client, org, step.Developer, step.App, step.Key, step.Products, journal)
+
+	case ActionDeveloperStatus, ActionAppStatus, ActionKeyStatus, ActionProductStatus:
+		// O status anterior vai para o journal, para o rollback voltar.
+		var previous string
+		if journal != nil {
+			var err error
+			if previous, err = currentStatus(ctx, client, org, step); err != nil {
+				return err
+			}
+		}
+		if err := setStatus(ctx, client, org, step); err != nil {
+			return err
+		}
+		return journal.record(org, step, previous)
+	}
+	return fmt.Errorf("acao desconhecida: %s", step.Action)
+}
+
+// setStatus aplica o status de um passo de status.
+func setStatus(ctx context.Context, client *apigeeclient.Client, org string, step Step) error {
+	action, err := statusAction(step)
+	if err != nil {
+		return err
+	}
+	apps := client.Service.Organizations.Developers.Apps
+
+	switch step.Action {
+	case ActionDeveloperStatus:
+		_, err = client.Service.Organizations.Developers.SetDeveloperStatus("organizations/" + org + "/developers/" + step.Developer).Action(action).Context(ctx).Do()
+	case ActionAppStatus:
+		_, err = apps.GenerateKeyPairOrUpdateDeveloperAppStatus(appPath(org, step.Developer, step.App), nil).Action(action).Context(ctx).Do()
+	case ActionKeyStatus:
+		_, err = apps.Keys.UpdateDeveloperAppKey(keyPath(org, step.Developer, step.App, step.Key), nil).Action(action).Context(ctx).Do()
+	case ActionProductStatus:
+		_, err = apps.Keys.Apiproducts.UpdateDeveloperAppKeyApiProduct(keyPath(org, step.Developer, step.App, step.Key) + "/apiproducts/" + step.Products[0]).Action(action).Context(ctx).Do()
+	}
+	return err
+}
+
+// currentStatus le o status que um passo de status vai trocar.
+func currentStatus(ctx context.Context, client *apigeeclient.Client, org string, step Step) (string, error) {
+	apps := client.Service.Organizations.Developers.Apps
+
+	if step.Action == ActionDeveloperStatus {
+		developer, err := client.Service.Organizations.Developers.Get("organizations/" + org + "/developers/" + step.Developer).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		return developer.Status, nil
+	}
+
+	app, err := apps.Get(appPath(org, step.Developer, step.App)).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if step.Action == ActionAppStatus {
+		return app.Status, nil
+	}
+	for _, cred := range app.Credentials {
+		if cred.ConsumerKey != step.Key {
+			continue
+		}
+		if step.Action == ActionKeyStatus {
+			return cred.Status, nil
+		}
+		for _, product := range cred.ApiProducts {
+			if product.Apiproduct == step.Products[0] {
+				return product.Status, nil
+			}
+		}
+		return "", fmt.Errorf("API product %s nao esta associado a chave %s", step.Products[0], step.Key)
+	}
+	return "", fmt.Errorf("chave %s nao encontrada no app %s", step.Key, step.App)
+}
+
+// loadDocuments le dos snapshots, ja decifrados e com o mapeamento aplicado,
+// so os documentos que o plano usa.
+func loadDocuments(snaps []*snapshot.Reader, plan *Plan, m *mapping.Mapping) (*diff.State, error) {
+	needDevelopers, needApps := map[string]bool{}, map[string]bool{}
+	for _, step := range plan.Steps {
+		switch step.Action {
+		case ActionCreateDeveloper:
+			needDevelopers[diff.DeveloperKey(step.Developer)] = true
+		case ActionCreateApp, ActionImportKey:
+			needApps[diff.AppKey(step.Developer, step.App)] = true
+		}
+	}
+
+	docs := diff.NewState()
+	for _, r := range snaps {
+		if len(needDevelopers) > 0 {
+			files, err := r.FilesOfKind(snapshot.KindDeveloper)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range files {
+				developer, err := r.ReadDeveloper(name)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				developer = m.DeveloperDoc(developer)
+				if needDevelopers[diff.DeveloperKey(developer.Email)] {
+					docs.AddDeveloper(developer)
+				}
+			}
+		}
+		if len(needApps) > 0 {
+			files, err := r.FilesOfKind(snapshot.KindApp)
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range files {
+				// O documento selado so serve para saber de quem e o app; o
+				// decifrado e lido so para os apps do plano.
+				app, err := r.ReadAppSealed(name)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				if !needApps[diff.AppKey(m.App(app).DeveloperID, app.Name)] {
+					continue
+				}
+				if app, err = r.ReadApp(name); err != nil {
+					return nil, fmt.Errorf("%s: %v", name, err)
+				}
+				docs.AddApp(m.App(app))
+			}
+		}
+	}
+	return docs, nil
+}
+
+// WriteReport grava o relatorio em texto (resumo por fase e o que nao deu
+// certo) ou JSON (todos os passos).
+func WriteReport(w io.Writer, report *Report, format string) error {
+	switch format {
+	case FormatText, "":
+		fmt.Fprintf(w, "Restore na organizacao %s\n", report.Organization)
+		for _, phase := range phases {
+			var ok, failed, skipped int
+			for _, result := range report.Results {
+				if result.Phase != phase {
+					continue
+				}
+				switch result.Status {
+				case ResultOK:
+					ok++
+				case ResultFailed:
+					failed++
+				case ResultSkipped:
+					skipped++
+				}
+			}
+			if ok+failed+skipped == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "%s: %d ok, %d falha(s), %d pulado(s)\n", phase, ok, failed, skipped)
+			for _, result := range report.Results {
+				if result.Phase == phase && result.Status != ResultOK {
+					fmt.Fprintf(w, "    %s %s: %s\n", result.Status, result.Step, result.Error)
+				}
+			}
+		}
+		if len(report.Existing) > 0 {
+			fmt.Fprintf(w, "ja existiam: %d\n", len(report.Existing))
+		}
+		return nil
+	case FormatJSON:
+		out := *report
+		if out.Results == nil {
+			out.Results = []Result{}
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	return fmt.Errorf("formato desconhecido: %s (use %s ou %s)", format, FormatText, FormatJSON)
+}
@@ -0,0 +1,283 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+
+	"google.golang.org/api/apigee/v1"
+)
+
+// Options ajusta o comportamento do restore.
+type Options struct {
+	// RegenerateRedacted gera um consumer secret novo para as credenciais de
+	// um backup feito com --redact-secrets. Sem ela esse restore e recusado.
+	RegenerateRedacted bool
+	// Policy diz o que o ApplyPlan faz quando um passo falha: PolicyFailFast
+	// (padrao) ou PolicyContinue.
+	Policy string
+	// Mapping renomeia API products, emails e atributos dos documentos antes
+	// do restore, para restaurar em outra organizacao.
+	Mapping *mapping.Mapping
+	// Filter seleciona os developers e apps do snapshot que sao restaurados,
+	// pelos nomes do snapshot (antes do Mapping). nil restaura tudo.
+	Filter *filter.Filter
+	// Done sao os indices dos passos do plano ja feitos em uma execucao
+	// anterior, que o ApplyPlan nao repete.
+	Done map[int]bool
+	// Progress, se informado, e chamado pelo ApplyPlan depois de cada passo.
+	Progress func(step int, result Result) error
+	// Journal registra cada alteracao feita na organizacao, para o rollback.
+	Journal *Journal
+	// Rollback faz o ApplyPlan desfazer, pelo Journal, tudo o que ele fez
+	// quando termina com erro.
+	Rollback bool
+}
+
+// Apps restaura os apps do snapshot que passam no opts.Filter. Um app com erro e logado e o
+// restore segue para o proximo; o erro devolvido resume as falhas.
+func Apps(ctx context.Context, client *apigeeclient.Client, org string, r *snapshot.Reader, opts Options) (int, error) {
+	files, err := r.FilesOfKind(snapshot.KindApp)
+	if err != nil {
+		return 0, fmt.Errorf("erro ao listar os apps do snapshot: %v", err)
+	}
+
+	var restored, failed int
+	for _, name := range files {
+		appBackup, err := r.ReadApp(name)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			failed++
+			continue
+		}
+		if !opts.Filter.App(appBackup) {
+			continue
+		}
+
+		err = App(ctx, client, org, appBackup, opts)
+		if err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+
+		fmt.Printf("App restaurado com sucesso: %s\n", appBackup.Name)
+		restored++
+	}
+
+	if failed > 0 {
+		return restored, fmt.Errorf("%d app(s) nao foram restaurados", failed)
+	}
+	return restored, nil
+}
+
+// App recria o app, remove a chave padrao gerada pela API e importa as
+// credenciais do backup com os seus API products. E tudo ou nada: se algum
+// passo falha, o que ja foi feito no app e desfeito, para nao deixar um app
+// pela metade e sem a chave padrao. As alteracoes vao para o opts.Journal.
+func App(ctx context.Context, client *apigeeclient.Client, org string, appBackup model.AppBackup, opts Options) error {
+	journal := opts.Journal
+	if journal == nil {
+		journal = NewJournal()
+	}
+	mark := journal.mark()
+
+	err := restoreApp(ctx, client, org, appBackup, journal, opts)
+	if err != nil && journal.mark() > mark {
+		fmt.Fprintf(Output, "Desfazendo o que foi feito no App %s\n", appBackup.Name)
+		if rollbackErr := journal.rollbackFrom(ctx, client, mark); rollbackErr != nil {
+			return fmt.Errorf("%v; o rollback tambem falhou: %v", err, rollbackErr)
+		}
+	}
+	return err
+}
+
+func restoreApp(ctx context.Context, client *apigeeclient.Client, org string, appBackup model.AppBackup, journal *Journal, opts Options) error {
+	appBackup = opts.Mapping.App(appBackup)
+	credentials := appBackup.Credentials
+	if len(credentials) == 0 {
+		return fmt.Errorf("nenhuma credencial encontrada no arquivo de backup")
+	}
+
+	credentials, err := resolveRedacted(appBackup.Name, credentials, opts)
+	if err != nil {
+		return err
+	}
+
+	err = createApp(ctx, client.Service, org, appBackup, journal)
+	if err != nil {
+		return fmt.Errorf("erro ao criar o aplicativo %s: %v", appBackup.Name, err)
+	}
+
+	err = createConsumerKeys(ctx, client, appBackup.DeveloperID, org, appBackup.Name, credentials, journal)
+	if err != nil {
+		return fmt.Errorf("erro ao criar as chaves do aplicativo para o App %s: %v", appBackup.Name, err)
+	}
+
+	return nil
+}
+
+// resolveRedacted recusa credenciais com o secret removido, a menos que
+// RegenerateRedacted esteja ligado; nesse caso gera um secret novo.
+func resolveRedacted(appName string, credentials []model.Credential, opts Options) ([]model.Credential, error) {
+	out := append([]model.Credential(nil), credentials...)
+	for i, cred := range out {
+		if !secrets.IsRedacted(cred.ConsumerSecret) {
+			continue
+		}
+		if !opts.RegenerateRedacted {
+			return nil, redactedError(appName, cred.ConsumerKey)
+		}
+
+		secret, err := secrets.NewConsumerSecret()
+		if err != nil {
+			return nil, err
+		}
+		out[i].ConsumerSecret = secret
+		fmt.Printf("Novo consumerSecret gerado para a chave %s do app %s\n", cred.ConsumerKey, appName)
+	}
+	return out, nil
+}
+
+func redactedError(appName, consumerKey string) error {
+	return fmt.Errorf("o App %s veio de um backup com --redact-secrets e o consumerSecret de %s nao pode ser restaurado: use --regenerate-secrets para gerar um novo", appName, consumerKey)
+}
+
+func createApp(ctx context.Context, client *apigee.Service, org string, appBackup model.AppBackup, journal *Journal) error {
+	app, err := newApp(ctx, client, org, appBackup, journal)
+	if err != nil {
+		return err
+	}
+	return deleteDefaultKeys(ctx, client, org, appBackup.DeveloperID, appBackup.Name, app.Credentials, journal)
+}
+
+// newApp cria o app com os atributos do backup. A API gera uma chave padrao,
+// sem API products, que o restore apaga em seguida.
+func newApp(ctx context.Context, client *apigee.Service, org string, appBackup model.AppBackup, journal *Journal) (*apigee.GoogleCloudApigeeV1DeveloperApp, error) {
+	app := &apigee.GoogleCloudApigeeV1DeveloperApp{
+		Name:       appBackup.Name,
+		Attributes: model.ConvertAttributes(appBackup.Attributes),
+	}
+
+	createAppCall := client.Organizations.Developers.Apps.Create("organizations/"+org+"/developers/"+appBackup.DeveloperID, app)
+	newApp, err := createAppCall.Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar o app: %v", err)
+	}
+	step := Step{Action: ActionCreateApp, Developer: appBackup.DeveloperID, App: appBackup.Name}
+	return newApp, journal.record(org, step, "")
+}
+
+// deleteDefaultKeys apaga as chaves sem API products, que sao as geradas pela
+// API na criacao do app.
+func deleteDefaultKeys(ctx context.Context, client *apigee.Service, org, developerID, appName string, keys []*apigee.GoogleCloudApigeeV1Credential, journal *Journal) error {
+	for _, key := range keys {
+		if len(key.ApiProducts) == 0 {
+			deleteKeyCall := client.Organizations.Developers.Apps.Keys.Delete(keyPath(org, developerID, appName, key.ConsumerKey))
+			deleteKeyCall.Context(ctx)
+			if _, err := deleteKeyCall.Do(); err != nil {
+				return fmt.Errorf("erro ao excluir o token padrão: %v", err)
+			}
+			fmt.Printf("Token padrão do app %s excluído: %s\n", appName, key.ConsumerKey)
+			step := Step{Action: ActionDeleteDefaultKey, Developer: developerID, App: appName, Key: key.ConsumerKey}
+			if err := journal.record(org, step, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importKey cria no app a chave do backup, com o mesmo consumerKey e consumerSecret.
+func importKey(ctx context.Context, client *apigee.Service, org, developerID, appName string, credential model.Credential, journal *Journal) error {
+	req := &apigee.GoogleCloudApigeeV1DeveloperAppKey{
+		ConsumerKey:    credential.ConsumerKey,
+		ConsumerSecret: credential.ConsumerSecret,
+	}
+
+	key, err := client.Organizations.Developers.Apps.Keys.Create(appPath(org, developerID, appName), req).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Chave do app %s criada: %s\n", appName, key.ConsumerKey)
+	return journal.record(org, Step{Action: ActionImportKey, Developer: developerID, App: appName, Key: key.ConsumerKey}, "")
+}
+
+// createConsumerKeys importa as chaves e associa cada uma aos seus API
+// products. Para na primeira falha, para o App desfazer o app inteiro.
+func createConsumerKeys(ctx context.Context, api *apigeeclient.Client, developerID, org, appName string, credentials []model.Credential, journal *Journal) error {
+	if len(credentials) == 0 {
+		return fmt.Errorf("nenhuma credencial encontrada no arquivo de backup")
+	}
+
+	for _, credential := range credentials {
+		err := importKey(ctx, api.Service, org, developerID, appName, credential, journal)
+		if err != nil {
+			return fmt.Errorf("erro ao criar a chave %s: %v", credential.ConsumerKey, err)
+		}
+
+		if len(credential.APIProducts) == 0 {
+			continue
+		}
+
+		err = associateKeyToProducts(ctx, api, org, developerID, appName, credential.ConsumerKey, credential.ProductNames(), journal)
+		if err != nil {
+			return fmt.Errorf("erro ao associar a chave %s aos API products: %v", credential.ConsumerKey, err)
+		}
+	}
+
+	return nil
+}
+
+func associateKeyToProducts(ctx context.Context, api *apigeeclient.Client, org, developerID, appName, consumerKey string, products []string, journal *Journal) error {
+	url := api.URL("organizations/%s/developers/%s/apps/%s/keys/%s", org, developerID, appName, consumerKey)
+
+	requestBody, err := json.Marshal(map[string][]string{"apiProducts": products})
+	if err != nil {
+		return fmt.Errorf("erro ao montar o corpo da requisição: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(string(requestBody)))
+	if err != nil {
+		return fmt.Errorf("erro ao criar a requisição HTTP: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := api.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("erro ao fazer a requisição HTTP: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("erro ao ler o corpo da resposta HTTP: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("erro ao associar a chave ao produto: %s", string(body))
+	}
+
+	step := Step{Action: ActionAssociateProducts, Developer: developerID, App: appName, Key: consumerKey, Products: products}
+	return journal.record(org, step, "")
+}
+
+func appPath(org, developerID, app string) string {
+	return "organizations/" + org + "/developers/" + developerID + "/apps/" + app
+}
+
+func keyPath(org, developerID, app, consumerKey string) string {
+	return appPath(org, developerID, app) + "/keys/" + consumerKey
+}
@@ -0,0 +1,70 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Developers cria na organizacao cada developer do snapshot que passa no
+// opts.Filter. Erros em um arquivo sao logados e o restore segue para o
+// proximo.
+func Developers(ctx context.Context, client *apigeeclient.Client, org string, r *snapshot.Reader, opts Options) (int, error) {
+	// Listar os arquivos de backup
+	backupFiles, err := r.FilesOfKind(snapshot.KindDeveloper)
+	if err != nil {
+		return 0, fmt.Errorf("error listing backup files: %v", err)
+	}
+
+	var restored int
+
+	// Percorrer os arquivos de backup
+	for _, backupFile := range backupFiles {
+		backup, err := r.ReadDeveloper(backupFile)
+		if err != nil {
+			log.Printf("Error decoding backup file %s: %v", backupFile, err)
+			continue
+		}
+		if !opts.Filter.Developer(backup.Email) {
+			continue
+		}
+		backup = opts.Mapping.DeveloperDoc(backup)
+
+		// Imprimir o JSON do objeto developer
+		jsonData, err := json.MarshalIndent(backup.ToApigee(), "", "  ")
+		if err != nil {
+			log.Printf("Error marshaling developer to JSON: %v", err)
+			continue
+		}
+
+		fmt.Println(string(jsonData))
+
+		err = Developer(ctx, client, org, backup)
+		if err != nil {
+			log.Printf("Error creating or updating developer %s: %v", backup.Email, err)
+			continue
+		}
+
+		if err := opts.Journal.record(org, Step{Action: ActionCreateDeveloper, Developer: backup.Email}, ""); err != nil {
+			return restored, err
+		}
+
+		fmt.Printf("Restored developer: %s\n", backup.Email)
+		restored++
+	}
+
+	return restored, nil
+}
+
+// Developer cria o developer na organizacao a partir do backup.
+func Developer(ctx context.Context, client *apigeeclient.Client, org string, backup model.DeveloperBackup) error {
+	developer := backup.ToApigee()
+
+	_, err := client.Service.Organizations.Developers.Create("organizations/"+org, developer).Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,305 @@
+package restore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"backup-restore-apigee/internal/apigeeclient"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ActionUndo e a entrada do journal que marca outra como desfeita.
+const ActionUndo = "undo"
+
+// Entry e uma alteracao feita na organizacao. As entradas ActionUndo apontam
+// (Undoes) para a entrada desfeita, para um segundo rollback nao repetir.
+type Entry struct {
+	Seq          int       `json:"seq"`
+	Time         time.Time `json:"time"`
+	Organization string    `json:"organization"`
+	Step
+	// Previous e o status antes da alteracao, nos passos de status.
+	Previous string `json:"previous,omitempty"`
+	Undoes   int    `json:"undoes,omitempty"`
+}
+
+// Journal registra cada alteracao que o restore faz, para desfazer na ordem
+// inversa (Rollback): apagar as chaves e os apps criados e voltar os status
+// alterados. O restore nunca sobrescreve atributos, ja que apps existentes
+// sao pulados, entao nao ha atributos a voltar. Com arquivo, cada entrada e
+// gravada como uma linha JSON assim que a alteracao termina: o journal
+// sobrevive a uma queda no meio do restore. O arquivo so e criado na primeira
+// alteracao. Um Journal nil nao registra nada.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries []Entry
+}
+
+// NewJournal devolve um journal so em memoria, usado para desfazer um app
+// que falhou no meio.
+func NewJournal() *Journal {
+	return &Journal{}
+}
+
+// CreateJournal devolve um journal gravado em path. Falha se o arquivo ja
+// existir, para nao misturar duas execucoes.
+func CreateJournal(path string) (*Journal, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("o journal %s ja existe", path)
+	}
+	return &Journal{path: path}, nil
+}
+
+// OpenJournal le o journal gravado por uma execucao anterior e o abre para
+// registrar o que o Rollback desfizer.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("journal invalido em %s, linha %d: %v", path, line, err)
+		}
+		j.entries = append(j.entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// JournalFlag registra --journal no FlagSet padrao. A funcao devolvida cria
+// o journal da organizacao depois do flag.Parse, no arquivo informado ou em
+// restore-<org>-<data e hora UTC>.jsonl.
+func JournalFlag() func(org string) (*Journal, error) {
+	path := flag.String("journal", "", "Arquivo onde cada alteracao do restore e registrada, para o rollback (padrao restore-<org>-<data e hora>.jsonl)")
+
+	return func(org string) (*Journal, error) {
+		if *path == "" {
+			*path = fmt.Sprintf("restore-%s-%s.jsonl", org, time.Now().UTC().Format("20060102T150405Z"))
+		}
+		return CreateJournal(*path)
+	}
+}
+
+// Path devolve o arquivo do journal, ou vazio para um journal em memoria.
+func (j *Journal) Path() string {
+	if j == nil {
+		return ""
+	}
+	return j.path
+}
+
+// Pending devolve, na ordem em que foram feitas, as alteracoes que ainda nao
+// foram desfeitas.
+func (j *Journal) Pending() []Entry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pendingFrom(0)
+}
+
+// Close fecha o arquivo.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// Rollback desfaz todas as alteracoes pendentes do journal, da ultima para a
+// primeira. Um erro em uma alteracao e logado e o rollback segue; o erro
+// devolvido resume as falhas.
+func (j *Journal) Rollback(ctx context.Context, client *apigeeclient.Client) error {
+	return j.rollbackFrom(ctx, client, 0)
+}
+
+// mark devolve a posicao atual do journal, para o rollbackFrom desfazer so o
+// que vier depois dela.
+func (j *Journal) mark() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.entries)
+}
+
+// record registra uma alteracao que acabou de ser feita.
+func (j *Journal) record(org string, step Step, previous string) error {
+	if j == nil {
+		return nil
+	}
+	return j.append(Entry{Organization: org, Step: step, Previous: previous})
+}
+
+func (j *Journal) append(entry Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.Seq = len(j.entries) + 1
+	entry.Time = time.Now().UTC()
+	j.entries = append(j.entries, entry)
+	if j.path == "" {
+		return nil
+	}
+	if j.file == nil {
+		file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("erro ao criar o journal: %v", err)
+		}
+		j.file = file
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("erro ao gravar o journal %s: %v", j.path, err)
+	}
+	return j.file.Sync()
+}
+
+// pendingFrom devolve as alteracoes a partir da posicao mark que nao foram
+// desfeitas. Chamado com j.mu travado.
+func (j *Journal) pendingFrom(mark int) []Entry {
+	undone := map[int]bool{}
+	for _, entry := range j.entries {
+		if entry.Action == ActionUndo {
+			undone[entry.Undoes] = true
+		}
+	}
+	var pending []Entry
+	for _, entry := range j.entries[mark:] {
+		if entry.Action != ActionUndo && !undone[entry.Seq] {
+			pending = append(pending, entry)
+		}
+	}
+	return pending
+}
+
+func (j *Journal) rollbackFrom(ctx context.Context, client *apigeeclient.Client, mark int) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	pending := j.pendingFrom(mark)
+	j.mu.Unlock()
+
+	var failed int
+	for i := len(pending) - 1; i >= 0; i-- {
+		entry := pending[i]
+		err := undo(ctx, client, entry)
+		if isNotFound(err) {
+			// Ja foi desfeito por fora, ou junto com o app ou developer.
+			err = nil
+		}
+		if err != nil {
+			log.Printf("Erro ao desfazer %q: %v", entry.Step, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(Output, "Desfeito: %s\n", entry.Step)
+		if err := j.append(Entry{Organization: entry.Organization, Step: Step{Action: ActionUndo}, Undoes: entry.Seq}); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d alteracao(oes) nao foram desfeitas", failed)
+	}
+	return nil
+}
+
+// undo desfaz uma alteracao.
+func undo(ctx context.Context, client *apigeeclient.Client, entry Entry) error {
+	org := entry.Organization
+	apps := client.Service.Organizations.Developers.Apps
+
+	switch entry.Action {
+	case ActionCreateDeveloper:
+		_, err := client.Service.Organizations.Developers.Delete("organizations/" + org + "/developers/" + entry.Developer).Context(ctx).Do()
+		return err
+
+	case ActionCreateApp:
+		_, err := apps.Delete(appPath(org, entry.Developer, entry.App)).Context(ctx).Do()
+		return err
+
+	case ActionDeleteDefaultKey:
+		// A chave gerada pela API nao tem como voltar; o app que a recebeu
+		// foi criado pelo restore e e apagado pela entrada anterior.
+		return nil
+
+	case ActionImportKey:
+		_, err := apps.Keys.Delete(keyPath(org, entry.Developer, entry.App, entry.Key)).Context(ctx).Do()
+		return err
+
+	case ActionAssociateProducts:
+		for _, product := range entry.Products {
+			_, err := apps.Keys.Apiproducts.Delete(keyPath(org, entry.Developer, entry.App, entry.Key) + "/apiproducts/" + product).Context(ctx).Do()
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+
+	case ActionDeveloperStatus, ActionAppStatus, ActionKeyStatus, ActionProductStatus:
+		if entry.Previous == "" {
+			return nil
+		}
+		step := entry.Step
+		step.Status = entry.Previous
+		return setStatus(ctx, client, org, step)
+	}
+	return fmt.Errorf("acao desconhecida no journal: %s", entry.Action)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// CloseAndLog fecha o journal e, se ficaram alteracoes pendentes, diz no log
+// onde elas estao e como desfazer. Os comandos de restore chamam no final.
+func (j *Journal) CloseAndLog() {
+	if err := j.Close(); err != nil {
+		log.Printf("Erro ao fechar o journal: %v", err)
+	}
+	if pending := len(j.Pending()); pending > 0 && j.Path() != "" {
+		log.Printf("%d alteracao(oes) registradas em %s; para desfazer: go run rollback_restore.go <serviceAccountFile> %s", pending, j.Path(), j.Path())
+	}
+}
@@ -0,0 +1,381 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/mapping"
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+// Acoes de um passo do plano, na ordem em que aparecem para cada app. As de
+// API product e de status so aparecem no plano do restore all.
+const (
+	ActionCheckProduct      = "check-product"
+	ActionCreateDeveloper   = "create-developer"
+	ActionCreateApp         = "create-app"
+	ActionDeleteDefaultKey  = "delete-default-key"
+	ActionImportKey         = "import-key"
+	ActionAssociateProducts = "associate-products"
+	ActionDeveloperStatus   = "set-developer-status"
+	ActionAppStatus         = "set-app-status"
+	ActionKeyStatus         = "set-key-status"
+	ActionProductStatus     = "set-product-status"
+)
+
+// Formatos de saida aceitos por WritePlan.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Plan e a lista ordenada de chamadas de escrita que o restore faria na
+// organizacao. O plano nao guarda secrets: o ApplyPlan le as credenciais do
+// snapshot de origem.
+type Plan struct {
+	Organization string    `json:"organization"`
+	Snapshots    []Source  `json:"snapshots"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Steps        []Step    `json:"steps"`
+	// Skipped lista o que ja existe na organizacao e fica de fora.
+	Skipped []string `json:"skipped,omitempty"`
+	// Warnings aponta o que deve falhar na execucao, como app de developer
+	// que nao existe e nao esta no plano.
+	Warnings []string `json:"warnings,omitempty"`
+	// Mapping e o mapeamento usado no plano. O ApplyPlan aplica o mesmo aos
+	// documentos do snapshot.
+	Mapping *mapping.Mapping `json:"mapping,omitempty"`
+}
+
+// Source e um snapshot de onde o plano le os documentos. O ID confere, na
+// execucao, que o snapshot no caminho ainda e o mesmo.
+type Source struct {
+	Path string `json:"path"`
+	ID   string `json:"id,omitempty"`
+}
+
+// Step e uma chamada a API. Developer e o email; App, Key, Products e Status
+// so sao preenchidos nas acoes que usam.
+type Step struct {
+	Action    string   `json:"action"`
+	Developer string   `json:"developer,omitempty"`
+	App       string   `json:"app,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Products  []string `json:"products,omitempty"`
+	Status    string   `json:"status,omitempty"`
+}
+
+func (s Step) String() string {
+	app := s.Developer + "/" + s.App
+	switch s.Action {
+	case ActionCheckProduct:
+		return "conferir se o API product " + strings.Join(s.Products, ", ") + " existe"
+	case ActionCreateDeveloper:
+		return "criar developer " + s.Developer
+	case ActionCreateApp:
+		return "criar app " + app
+	case ActionDeleteDefaultKey:
+		return "apagar a chave padrao do app " + app
+	case ActionImportKey:
+		return fmt.Sprintf("importar a chave %s no app %s", s.Key, app)
+	case ActionAssociateProducts:
+		return fmt.Sprintf("associar a chave %s do app %s a %s", s.Key, app, strings.Join(s.Products, ", "))
+	case ActionDeveloperStatus:
+		return fmt.Sprintf("mudar o status do developer %s para %s", s.Developer, s.Status)
+	case ActionAppStatus:
+		return fmt.Sprintf("mudar o status do app %s para %s", app, s.Status)
+	case ActionKeyStatus:
+		return fmt.Sprintf("mudar o status da chave %s do app %s para %s", s.Key, app, s.Status)
+	case ActionProductStatus:
+		return fmt.Sprintf("mudar o status do API product %s na chave %s do app %s para %s", strings.Join(s.Products, ", "), s.Key, app, s.Status)
+	}
+	return s.Action
+}
+
+// NewPlan compara o que esta em want (os documentos a restaurar) com o estado
+// atual da organizacao em live e monta o plano. Developers que ja existem sao
+// pulados; apps que ja existem recebem so as chaves e associacoes que faltam.
+func NewPlan(org string, want, live *diff.State, opts Options) (*Plan, error) {
+	plan := &Plan{Organization: org, CreatedAt: time.Now().UTC()}
+	creating := map[string]bool{}
+
+	for _, key := range sortedKeys(want.Developers) {
+		developer := want.Developers[key]
+		if _, ok := live.Developers[key]; ok {
+			plan.Skipped = append(plan.Skipped, "developer "+developer.Email+" ja existe")
+			continue
+		}
+		creating[key] = true
+		plan.Steps = append(plan.Steps, Step{Action: ActionCreateDeveloper, Developer: developer.Email})
+	}
+
+	for _, key := range sortedKeys(want.Apps) {
+		app := want.Apps[key]
+		if len(app.Credentials) == 0 {
+			return nil, fmt.Errorf("app %s: nenhuma credencial encontrada no arquivo de backup", key)
+		}
+		for _, cred := range app.Credentials {
+			if secrets.IsRedacted(cred.ConsumerSecret) && !opts.RegenerateRedacted {
+				return nil, redactedError(app.Name, cred.ConsumerKey)
+			}
+		}
+
+		developerKey := diff.DeveloperKey(app.DeveloperID)
+		if _, ok := live.Developers[developerKey]; !ok && !creating[developerKey] {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("o developer %s do app %s nao existe na organizacao nem esta no plano", app.DeveloperID, app.Name))
+		}
+
+		current, exists := live.Apps[key]
+		if !exists {
+			plan.Steps = append(plan.Steps,
+				Step{Action: ActionCreateApp, Developer: app.DeveloperID, App: app.Name},
+				Step{Action: ActionDeleteDefaultKey, Developer: app.DeveloperID, App: app.Name})
+		}
+
+		before := len(plan.Steps)
+		for _, cred := range app.Credentials {
+			liveCred, hasKey := findCredential(current, cred.ConsumerKey)
+			if !hasKey {
+				plan.Steps = append(plan.Steps, Step{Action: ActionImportKey, Developer: app.DeveloperID, App: app.Name, Key: cred.ConsumerKey})
+			}
+
+			var missing []string
+			for _, product := range cred.ProductNames() {
+				if !hasProduct(liveCred, product) {
+					missing = append(missing, product)
+				}
+			}
+			if len(missing) > 0 {
+				plan.Steps = append(plan.Steps, Step{Action: ActionAssociateProducts, Developer: app.DeveloperID, App: app.Name, Key: cred.ConsumerKey, Products: missing})
+			}
+		}
+		if exists && len(plan.Steps) == before {
+			plan.Skipped = append(plan.Skipped, "app "+key+" ja existe com todas as chaves")
+		}
+	}
+
+	return plan, nil
+}
+
+// SnapshotPlan monta o plano para restaurar os snapshots inteiros, como o de
+// developers e o de apps de um mesmo backup.
+func SnapshotPlan(org string, live *diff.State, opts Options, snaps ...*snapshot.Reader) (*Plan, error) {
+	want, err := loadState(snaps, opts.Filter, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotPlan(org, want, live, opts, snaps)
+}
+
+// FilePlan monta o plano para restaurar so o app do arquivo name do snapshot.
+func FilePlan(org string, r *snapshot.Reader, name string, live *diff.State, opts Options) (*Plan, error) {
+	app, err := r.ReadApp(name)
+	if errors.Is(err, snapshot.ErrNoKey) {
+		// O plano nao precisa dos secrets, so o ApplyPlan.
+		app, err = r.ReadAppSealed(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	want := diff.NewState()
+	want.AddApp(opts.Mapping.App(app))
+	return snapshotPlan(org, want, live, opts, []*snapshot.Reader{r})
+}
+
+func snapshotPlan(org string, want, live *diff.State, opts Options, snaps []*snapshot.Reader) (*Plan, error) {
+	plan, err := NewPlan(org, want, live, opts)
+	if err != nil {
+		return nil, err
+	}
+	plan.Mapping = opts.Mapping
+	for _, r := range snaps {
+		source := Source{Path: r.Path()}
+		if manifest, err := r.Manifest(); err == nil {
+			source.ID = manifest.ID
+		}
+		plan.Snapshots = append(plan.Snapshots, source)
+	}
+	return plan, nil
+}
+
+// loadState junta os documentos dos snapshots que passam no filtro, ja com o
+// mapeamento aplicado. O filtro olha os nomes do snapshot, antes do
+// mapeamento. Sem a chave, os apps cifrados sao lidos selados: o plano nao
+// usa os secrets.
+func loadState(snaps []*snapshot.Reader, f *filter.Filter, m *mapping.Mapping) (*diff.State, error) {
+	want := diff.NewState()
+	for _, r := range snaps {
+		state, err := diff.Load(r)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler o snapshot %s: %v", r.Path(), err)
+		}
+		for _, developer := range state.Developers {
+			if f.Developer(developer.Email) {
+				want.AddDeveloper(m.DeveloperDoc(developer))
+			}
+		}
+		for _, app := range state.Apps {
+			if f.App(app) {
+				want.AddApp(m.App(app))
+			}
+		}
+	}
+	return want, nil
+}
+
+// CheckMapping aponta as referencias dos snapshots que o mapeamento nao
+// cobre, antes de qualquer chamada a API. Devolve erro se houver alguma, a
+// menos que o mapeamento permita (--allow-unmapped). So os documentos que
+// passam no opts.Filter sao conferidos.
+func CheckMapping(opts Options, snaps ...*snapshot.Reader) error {
+	m := opts.Mapping
+	if m == nil {
+		return nil
+	}
+	state, err := loadState(snaps, opts.Filter, nil)
+	if err != nil {
+		return err
+	}
+	var developers []model.DeveloperBackup
+	for _, key := range sortedKeys(state.Developers) {
+		developers = append(developers, state.Developers[key])
+	}
+	var apps []model.AppBackup
+	for _, key := range sortedKeys(state.Apps) {
+		apps = append(apps, state.Apps[key])
+	}
+	return CheckUnmapped(m, developers, apps)
+}
+
+// CheckUnmapped e o CheckMapping para documentos ja lidos, como o YAML de um
+// app avulso.
+func CheckUnmapped(m *mapping.Mapping, developers []model.DeveloperBackup, apps []model.AppBackup) error {
+	if m == nil {
+		return nil
+	}
+	unmapped := m.Unmapped(developers, apps)
+	if len(unmapped) == 0 {
+		return nil
+	}
+	for _, ref := range unmapped {
+		log.Printf("Sem mapeamento: %s", ref)
+	}
+	if m.AllowUnmapped {
+		log.Printf("%d referencia(s) sem mapeamento serao restauradas com o nome original", len(unmapped))
+		return nil
+	}
+	return fmt.Errorf("%d referencia(s) sem mapeamento; complete o arquivo de mapeamento ou use --allow-unmapped", len(unmapped))
+}
+
+// OpenSnapshots abre os snapshots do plano e confere que sao os mesmos de
+// quando o plano foi gerado.
+func OpenSnapshots(ctx context.Context, plan *Plan, kms secrets.KMS) ([]*snapshot.Reader, error) {
+	var snaps []*snapshot.Reader
+	for _, source := range plan.Snapshots {
+		r, err := snapshot.Open(ctx, source.Path)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao abrir o snapshot do plano: %v", err)
+		}
+		if manifest, err := r.Manifest(); err == nil && source.ID != "" && manifest.ID != "" && manifest.ID != source.ID {
+			return nil, fmt.Errorf("o plano foi gerado para o snapshot %s, mas %s e o snapshot %s", source.ID, source.Path, manifest.ID)
+		}
+		r.SetKMS(kms)
+		snaps = append(snaps, r)
+	}
+	return snaps, nil
+}
+
+// WritePlan grava o plano em texto ou JSON. O JSON e o que o --apply-plan le.
+func WritePlan(w io.Writer, plan *Plan, format string) error {
+	switch format {
+	case FormatText, "":
+		var paths []string
+		for _, source := range plan.Snapshots {
+			paths = append(paths, source.Path)
+		}
+		fmt.Fprintf(w, "Plano de restore na organizacao %s a partir de %s\n", plan.Organization, strings.Join(paths, ", "))
+		if len(plan.Steps) == 0 {
+			fmt.Fprintln(w, "Nada a fazer.")
+		}
+		for i, step := range plan.Steps {
+			fmt.Fprintf(w, "%d. %s\n", i+1, step)
+		}
+		for _, msg := range plan.Skipped {
+			fmt.Fprintf(w, "pulado: %s\n", msg)
+		}
+		for _, msg := range plan.Warnings {
+			fmt.Fprintf(w, "atencao: %s\n", msg)
+		}
+		return nil
+	case FormatJSON:
+		out := *plan
+		if out.Steps == nil {
+			out.Steps = []Step{}
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+	return fmt.Errorf("formato desconhecido: %s (use %s ou %s)", format, FormatText, FormatJSON)
+}
+
+// ReadPlan le um plano gravado com WritePlan em JSON.
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("plano invalido em %s: %v", path, err)
+	}
+	if plan.Organization == "" || len(plan.Snapshots) == 0 {
+		return nil, errors.New("plano sem organizacao ou snapshot")
+	}
+	return &plan, nil
+}
+
+func findCredential(app model.AppBackup, consumerKey string) (*model.Credential, bool) {
+	for i := range app.Credentials {
+		if app.Credentials[i].ConsumerKey == consumerKey {
+			return &app.Credentials[i], true
+		}
+	}
+	return nil, false
+}
+
+func hasProduct(cred *model.Credential, product string) bool {
+	if cred == nil {
+		return false
+	}
+	for _, name := range cred.ProductNames() {
+		if name == product {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+// Package retention decide quais snapshots manter em uma politica avo-pai-filho
+// (grandfather-father-son): os N ultimos dias, semanas e meses com backup.
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policy e quantos dias, semanas e meses manter. Em cada periodo fica o
+// snapshot mais recente; periodos sem backup nao contam.
+type Policy struct {
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Validate recusa politicas que apagariam todos os snapshots.
+func (p Policy) Validate() error {
+	if p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 {
+		return fmt.Errorf("a quantidade de snapshots a manter nao pode ser negativa")
+	}
+	if p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 {
+		return fmt.Errorf("informe ao menos um de --daily, --weekly ou --monthly")
+	}
+	return nil
+}
+
+// Apply devolve, para cada instante de times, os motivos para manter o
+// snapshot (ex: "diario 2024-02-01"). Sem motivos, o snapshot pode ser
+// apagado. A ordem de times nao importa.
+func Apply(p Policy, times []time.Time) [][]string {
+	order := make([]int, len(times))
+	for i := range order {
+		order[i] = i
+	}
+	// Do mais novo para o mais antigo: em cada periodo fica o mais recente.
+	sort.SliceStable(order, func(a, b int) bool {
+		return times[order[a]].After(times[order[b]])
+	})
+
+	reasons := make([][]string, len(times))
+	rules := []struct {
+		count int
+		label string
+		key   func(time.Time) string
+	}{
+		{p.Daily, "diario", func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, "semanal", func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{p.Monthly, "mensal", func(t time.Time) string { return t.Format("2006-01") }},
+	}
+
+	for _, rule := range rules {
+		kept := 0
+		last := ""
+		for _, i := range order {
+			if kept == rule.count {
+				break
+			}
+			key := rule.key(times[i])
+			if key == last {
+				continue
+			}
+			last = key
+			kept++
+			reasons[i] = append(reasons[i], rule.label+" "+key)
+		}
+	}
+
+	return reasons
+}
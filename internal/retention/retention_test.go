@@ -0,0 +1,63 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	times := []time.Time{
+		at("2024-03-04 02:00"), // segunda
+		at("2024-03-03 14:00"), // domingo, mais recente do dia
+		at("2024-03-03 02:00"),
+		at("2024-03-01 02:00"),
+		at("2024-02-29 02:00"),
+		at("2024-02-10 02:00"),
+		at("2024-01-15 02:00"),
+	}
+
+	got := Apply(Policy{Daily: 3, Weekly: 2, Monthly: 2}, times)
+	want := [][]string{
+		{"diario 2024-03-04", "semanal 2024-W10", "mensal 2024-03"},
+		{"diario 2024-03-03", "semanal 2024-W09"},
+		nil,
+		{"diario 2024-03-01"},
+		{"mensal 2024-02"},
+		nil,
+		nil,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply =\n%v\nesperado\n%v", got, want)
+	}
+}
+
+func TestApplyIgnoresInputOrder(t *testing.T) {
+	old := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	got := Apply(Policy{Daily: 1}, []time.Time{old, recent})
+	if got[0] != nil || len(got[1]) != 1 {
+		t.Errorf("deveria manter o mais recente do dia: %v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Policy{}).Validate(); err == nil {
+		t.Error("politica vazia apagaria tudo")
+	}
+	if err := (Policy{Daily: -1, Weekly: 1}).Validate(); err == nil {
+		t.Error("quantidade negativa")
+	}
+	if err := (Policy{Monthly: 12}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
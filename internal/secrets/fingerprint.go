@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// RedactedPrefix marca um secret substituido pela sua impressao digital.
+const RedactedPrefix = "redacted:v1:"
+
+// Fingerprint devolve a impressao digital de secret: SHA-256 com um salt
+// aleatorio, no formato redacted:v1:<salt>:<hash>. O secret nao pode ser
+// recuperado dela, so comparado com MatchFingerprint.
+func Fingerprint(secret string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return RedactedPrefix + base64.RawURLEncoding.EncodeToString(salt) + ":" + fingerprintHash(salt, secret), nil
+}
+
+// MatchFingerprint informa se secret gera a mesma impressao digital.
+func MatchFingerprint(fingerprint, secret string) (bool, error) {
+	if !IsRedacted(fingerprint) {
+		return false, fmt.Errorf("valor nao e uma impressao digital")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(fingerprint, RedactedPrefix), ":", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("impressao digital invalida")
+	}
+
+	salt, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false, fmt.Errorf("impressao digital invalida: %v", err)
+	}
+
+	got := fingerprintHash(salt, secret)
+	return subtle.ConstantTimeCompare([]byte(got), []byte(parts[1])) == 1, nil
+}
+
+// IsRedacted informa se value foi gerado por Fingerprint.
+func IsRedacted(value string) bool {
+	return strings.HasPrefix(value, RedactedPrefix)
+}
+
+func fingerprintHash(salt []byte, secret string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// NewConsumerSecret gera um consumer secret aleatorio de 64 caracteres, no
+// mesmo formato dos gerados pelo Apigee.
+func NewConsumerSecret() (string, error) {
+	out := make([]byte, 64)
+	max := big.NewInt(int64(len(secretAlphabet)))
+	for i := range out {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		out[i] = secretAlphabet[n.Int64()]
+	}
+	return string(out), nil
+}
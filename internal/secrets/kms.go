@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const saltSize = 16
+
+// localKey envolve a DEK com uma chave AES-256 guardada em arquivo. Serve
+// para testes e para quem ja guarda a chave em um cofre proprio.
+type localKey struct {
+	key []byte
+}
+
+// NewLocalKey cria um KMS a partir de uma chave de 32 bytes.
+func NewLocalKey(key []byte) (KMS, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("a chave local precisa ter %d bytes, tem %d", keySize, len(key))
+	}
+	return &localKey{key: key}, nil
+}
+
+// LoadLocalKey le uma chave de 32 bytes em hexadecimal (64 caracteres) do arquivo.
+func LoadLocalKey(file string) (KMS, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler a chave %s: %v", file, err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("a chave %s precisa estar em hexadecimal: %v", file, err)
+	}
+
+	return NewLocalKey(key)
+}
+
+func (k *localKey) Scheme() string {
+	return "local-key"
+}
+
+func (k *localKey) WrapKey(dek []byte) ([]byte, error) {
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return seal(aead, dek, []byte(k.Scheme()))
+}
+
+func (k *localKey) UnwrapKey(wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(k.key)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, wrapped, []byte(k.Scheme()))
+}
+
+// passphrase deriva a chave que envolve a DEK com scrypt. O salt vai junto
+// com a chave envolvida: salt || nonce || ciphertext.
+type passphrase struct {
+	secret []byte
+}
+
+func NewPassphrase(secret string) (KMS, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("senha vazia")
+	}
+	return &passphrase{secret: []byte(secret)}, nil
+}
+
+func (p *passphrase) Scheme() string {
+	return "passphrase-scrypt"
+}
+
+func (p *passphrase) WrapKey(dek []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	kek, err := scrypt.Key(p.secret, salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(aead, dek, []byte(p.Scheme()))
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, sealed...), nil
+}
+
+func (p *passphrase) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < saltSize {
+		return nil, fmt.Errorf("chave envolvida invalida")
+	}
+
+	kek, err := scrypt.Key(p.secret, wrapped[:saltSize], 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(kek)
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, wrapped[saltSize:], []byte(p.Scheme()))
+}
+
+// Flags registra --secrets-key-file e --secrets-passphrase-env no FlagSet
+// padrao. A funcao devolvida monta o KMS depois do flag.Parse, ou nil se
+// nenhuma das duas foi informada.
+func Flags() func() (KMS, error) {
+	keyFile := flag.String("secrets-key-file", "", "Arquivo com a chave local (hex, 32 bytes) usada para cifrar/decifrar os consumer secrets")
+	passphraseEnv := flag.String("secrets-passphrase-env", "", "Variavel de ambiente com a senha usada para cifrar/decifrar os consumer secrets")
+
+	return func() (KMS, error) {
+		switch {
+		case *keyFile != "" && *passphraseEnv != "":
+			return nil, fmt.Errorf("use --secrets-key-file ou --secrets-passphrase-env, nao os dois")
+		case *keyFile != "":
+			return LoadLocalKey(*keyFile)
+		case *passphraseEnv != "":
+			return NewPassphrase(os.Getenv(*passphraseEnv))
+		}
+		return nil, nil
+	}
+}
@@ -0,0 +1,137 @@
+// Package secrets cifra os consumer secrets gravados nos backups usando
+// envelope encryption: cada snapshot tem uma chave de dados (DEK) propria,
+// guardada no manifesto ja envolvida (wrapped) por um KMS.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Prefix marca um valor cifrado dentro de um documento do backup.
+const Prefix = "enc:v1:"
+
+const keySize = 32
+
+// KMS envolve e desenvolve a chave de dados de um snapshot. Scheme identifica
+// a implementacao e e gravado no manifesto junto com a chave envolvida.
+type KMS interface {
+	Scheme() string
+	WrapKey(dek []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// Envelope e o que vai para o manifesto: o esquema e a DEK envolvida.
+type Envelope struct {
+	Scheme     string `json:"scheme"`
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// Sealer cifra e decifra valores com a DEK de um snapshot.
+type Sealer struct {
+	aead     cipher.AEAD
+	envelope Envelope
+}
+
+// NewSealer gera uma DEK nova e a envolve com kms.
+func NewSealer(kms KMS) (*Sealer, error) {
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := kms.WrapKey(dek)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao envolver a chave do snapshot: %v", err)
+	}
+
+	return newSealer(dek, Envelope{Scheme: kms.Scheme(), WrappedKey: wrapped})
+}
+
+// OpenEnvelope recupera a DEK do manifesto usando kms.
+func OpenEnvelope(kms KMS, envelope Envelope) (*Sealer, error) {
+	if kms.Scheme() != envelope.Scheme {
+		return nil, fmt.Errorf("snapshot cifrado com %s, mas a chave informada e %s", envelope.Scheme, kms.Scheme())
+	}
+
+	dek, err := kms.UnwrapKey(envelope.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao abrir a chave do snapshot (chave ou senha incorreta?): %v", err)
+	}
+
+	return newSealer(dek, envelope)
+}
+
+func newSealer(dek []byte, envelope Envelope) (*Sealer, error) {
+	aead, err := newGCM(dek)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead, envelope: envelope}, nil
+}
+
+func (s *Sealer) Envelope() Envelope {
+	return s.envelope
+}
+
+// Seal cifra plaintext. aad amarra o valor ao seu contexto (ex: o consumer
+// key), entao um secret nao pode ser copiado para outra credencial.
+func (s *Sealer) Seal(plaintext, aad string) (string, error) {
+	sealed, err := seal(s.aead, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decifra um valor gerado por Seal com o mesmo aad.
+func (s *Sealer) Open(value, aad string) (string, error) {
+	if !IsSealed(value) {
+		return "", fmt.Errorf("valor nao esta cifrado")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return "", fmt.Errorf("valor cifrado invalido: %v", err)
+	}
+
+	plaintext, err := open(s.aead, sealed, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("erro ao decifrar o valor: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed informa se value foi gerado por Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal devolve nonce || ciphertext.
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("valor cifrado muito curto")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testKMS(t *testing.T) map[string]KMS {
+	t.Helper()
+
+	local, err := NewLocalKey(bytes.Repeat([]byte{7}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass, err := NewPassphrase("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]KMS{"local": local, "passphrase": pass}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	for name, kms := range testKMS(t) {
+		t.Run(name, func(t *testing.T) {
+			sealer, err := NewSealer(kms)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sealed, err := sealer.Seal("s3cr3t", "key-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsSealed(sealed) || strings.Contains(sealed, "s3cr3t") {
+				t.Fatalf("valor cifrado inesperado: %s", sealed)
+			}
+
+			reopened, err := OpenEnvelope(kms, sealer.Envelope())
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := reopened.Open(sealed, "key-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plain != "s3cr3t" {
+				t.Errorf("Open = %q", plain)
+			}
+
+			if _, err := reopened.Open(sealed, "key-2"); err == nil {
+				t.Error("Open com aad diferente deveria falhar")
+			}
+		})
+	}
+}
+
+func TestOpenEnvelopeWrongKey(t *testing.T) {
+	kms := testKMS(t)
+	sealer, err := NewSealer(kms["passphrase"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, _ := NewPassphrase("outra senha")
+	if _, err := OpenEnvelope(wrong, sealer.Envelope()); err == nil {
+		t.Error("senha errada deveria falhar")
+	}
+	if _, err := OpenEnvelope(kms["local"], sealer.Envelope()); err == nil {
+		t.Error("esquema diferente deveria falhar")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	fp, err := Fingerprint("s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsRedacted(fp) || strings.Contains(fp, "s3cr3t") {
+		t.Fatalf("impressao digital inesperada: %s", fp)
+	}
+
+	other, _ := Fingerprint("s3cr3t")
+	if other == fp {
+		t.Error("salt deveria mudar a impressao digital")
+	}
+
+	if ok, err := MatchFingerprint(fp, "s3cr3t"); err != nil || !ok {
+		t.Errorf("MatchFingerprint(secret certo) = %v, %v", ok, err)
+	}
+	if ok, err := MatchFingerprint(fp, "outro"); err != nil || ok {
+		t.Errorf("MatchFingerprint(secret errado) = %v, %v", ok, err)
+	}
+}
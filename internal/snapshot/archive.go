@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/storage"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formatos aceitos em Options.Archive.
+const (
+	FormatTarGz   = "tar.gz"
+	FormatTarZstd = "tar.zst"
+)
+
+func archiveExtension(format string) string {
+	return "." + format
+}
+
+// IsArchive informa se path tem a extensao de um snapshot compactado.
+func IsArchive(path string) bool {
+	return archiveFormat(path) != ""
+}
+
+func archiveFormat(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return FormatTarGz
+	case strings.HasSuffix(path, ".tar.zst"), strings.HasSuffix(path, ".tzst"):
+		return FormatTarZstd
+	}
+	return ""
+}
+
+// archiveSink escreve os arquivos do snapshot em um tar compactado, sem
+// passar pelo disco arquivo a arquivo.
+type archiveSink struct {
+	file io.WriteCloser
+	zw   io.WriteCloser
+	tw   *tar.Writer
+	now  time.Time
+}
+
+func newArchiveSink(ctx context.Context, backend storage.Backend, key, format string) (*archiveSink, error) {
+	// O disco local ja abre o arquivo com O_EXCL.
+	if _, ok := backend.(storage.DirMaker); !ok {
+		if err := mustNotExist(ctx, backend, key); err != nil {
+			return nil, err
+		}
+	}
+
+	// Valida o formato antes de criar o objeto, que nao pode ser desfeito
+	// depois de um upload.
+	if format != FormatTarGz && format != FormatTarZstd {
+		return nil, unknownFormat(format)
+	}
+
+	file, err := backend.NewWriter(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	zw, err := newCompressor(file, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &archiveSink{
+		file: file,
+		zw:   zw,
+		tw:   tar.NewWriter(zw),
+		now:  time.Now(),
+	}, nil
+}
+
+func newCompressor(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case FormatTarGz:
+		return gzip.NewWriter(w), nil
+	case FormatTarZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, unknownFormat(format)
+}
+
+func unknownFormat(format string) error {
+	return fmt.Errorf("formato de arquivo desconhecido: %s (use %s ou %s)", format, FormatTarGz, FormatTarZstd)
+}
+
+func (a *archiveSink) writeFile(name string, data []byte) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: a.now,
+	})
+	if err != nil {
+		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+	}
+
+	_, err = a.tw.Write(data)
+	if err != nil {
+		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+	}
+
+	return nil
+}
+
+func (a *archiveSink) close() error {
+	return errors.Join(a.tw.Close(), a.zw.Close(), a.file.Close())
+}
+
+// readArchive carrega em memoria todos os arquivos do tar compactado.
+func readArchive(ctx context.Context, backend storage.Backend, key string) (memorySource, error) {
+	filename := backend.URL(key)
+	file, err := backend.NewReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader
+	switch archiveFormat(filename) {
+	case FormatTarGz:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case FormatTarZstd:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fmt.Errorf("formato de arquivo desconhecido: %s", filename)
+	}
+
+	files := memorySource{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler o arquivo %s: %v", filename, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler %s de %s: %v", header.Name, filename, err)
+		}
+		files[path.Clean(header.Name)] = data
+	}
+
+	return files, nil
+}
@@ -0,0 +1,312 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/storage"
+)
+
+// CatalogDir e o diretorio (ou prefixo no bucket), ao lado dos snapshots, com
+// uma entrada JSON por snapshot. Cada backup grava so a propria entrada, entao
+// backups simultaneos nao disputam o mesmo arquivo.
+const CatalogDir = ".catalog"
+
+// Status de um snapshot no catalogo.
+const (
+	// StatusRunning e gravado no Create. Se ficar assim, o backup nao chegou
+	// ao Close.
+	StatusRunning = "running"
+	// StatusComplete e um backup que terminou sem erros.
+	StatusComplete = "complete"
+	// StatusPartial e um backup que terminou com erros no manifesto ou sem
+	// ler todos os recursos; tem checkpoint e pode ser retomado (Resume).
+	StatusPartial = "partial"
+	// StatusIncomplete e um snapshot sem manifesto, achado pelo RebuildCatalog.
+	StatusIncomplete = "incomplete"
+)
+
+// Entry e a entrada de um snapshot no catalogo.
+type Entry struct {
+	ID string `json:"id"`
+	// Name e o nome do snapshot, ex: apps_20240201T100000Z-3fa2c1.tar.gz.
+	Name string `json:"name"`
+	// Base e o nome do <backupDir> usado no backup, ex: apps.
+	Base         string         `json:"base"`
+	Organization string         `json:"organization"`
+	Status       string         `json:"status"`
+	StartedAt    time.Time      `json:"startedAt"`
+	FinishedAt   time.Time      `json:"finishedAt"`
+	Counts       map[string]int `json:"counts"`
+	Errors       int            `json:"errors,omitempty"`
+	Archive      string         `json:"archive,omitempty"`
+	Encrypted    bool           `json:"encrypted,omitempty"`
+	Redacted     bool           `json:"redacted,omitempty"`
+	Dedup        bool           `json:"dedup,omitempty"`
+	Format       string         `json:"format,omitempty"`
+	// Incremental e o nome da base, nos snapshots incrementais.
+	Incremental string `json:"incremental,omitempty"`
+
+	// Path e o caminho ou URL aceito por Open, montado na leitura.
+	Path string `json:"-"`
+}
+
+// Kinds devolve os tipos de recurso do snapshot em ordem.
+func (e Entry) Kinds() []string {
+	kinds := make([]string, 0, len(e.Counts))
+	for kind := range e.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func (w *Writer) writeCatalog(status string) error {
+	name := nameOf(w.key)
+	base, _, _ := splitName(name)
+
+	entry := entryFromManifest(base, &w.manifest, status)
+	entry.Name = name
+	entry.Archive = archiveFormat(name)
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("erro ao converter a entrada do catalogo para JSON: %v", err)
+	}
+	err = w.backend.Put(w.ctx, catalogKey(w.key), data)
+	if err != nil {
+		return fmt.Errorf("erro ao gravar o catalogo: %v", err)
+	}
+	return nil
+}
+
+func entryFromManifest(base string, manifest *Manifest, status string) Entry {
+	entry := Entry{
+		ID:           manifest.ID,
+		Base:         base,
+		Organization: manifest.Organization,
+		Status:       status,
+		StartedAt:    manifest.StartedAt,
+		FinishedAt:   manifest.FinishedAt,
+		Counts:       manifest.Counts,
+		Errors:       len(manifest.Errors),
+		Encrypted:    manifest.Encryption != nil,
+		Redacted:     manifest.Redacted,
+		Dedup:        manifest.Dedup,
+		Format:       manifest.Format,
+	}
+	if manifest.Incremental != nil {
+		entry.Incremental = manifest.Incremental.Base
+	}
+	return entry
+}
+
+// Catalog le o catalogo dos snapshots gravados em location (o diretorio ou
+// prefixo do bucket onde estao os snapshots), do mais novo para o mais antigo.
+func Catalog(ctx context.Context, location string) ([]Entry, error) {
+	backend, key, err := storage.Open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return readCatalog(ctx, backend, dirPrefix(key))
+}
+
+// AsOf devolve o snapshot mais recente de backupDir (o mesmo argumento do
+// backup) iniciado ate at. Backups em andamento ou interrompidos sao
+// ignorados.
+func AsOf(ctx context.Context, backupDir string, at time.Time) (Entry, error) {
+	backend, key, err := storage.Open(ctx, backupDir)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	dir := key[:strings.LastIndex(key, "/")+1]
+	entries, err := readCatalog(ctx, backend, dir)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	for _, entry := range entries {
+		if entry.Base != key[len(dir):] || entry.StartedAt.After(at) {
+			continue
+		}
+		if entry.Status == StatusComplete || entry.Status == StatusPartial {
+			return entry, nil
+		}
+	}
+
+	return Entry{}, fmt.Errorf("nenhum snapshot de %s no catalogo iniciado ate %s (snapshots antigos precisam do list_snapshots --rebuild)", backupDir, at.UTC().Format(time.RFC3339))
+}
+
+// ResolveAsOf devolve o snapshot a restaurar: location quando asOf e vazio, ou
+// o snapshot mais recente de location (um <backupDir>) iniciado ate asOf.
+func ResolveAsOf(ctx context.Context, location, asOf string) (string, error) {
+	if asOf == "" {
+		return location, nil
+	}
+
+	at, err := ParseAsOf(asOf)
+	if err != nil {
+		return "", err
+	}
+	entry, err := AsOf(ctx, location, at)
+	if err != nil {
+		return "", err
+	}
+	return entry.Path, nil
+}
+
+// RebuildCatalog recria as entradas do catalogo a partir dos manifestos dos
+// snapshots em location, inclusive os gravados antes do catalogo existir.
+func RebuildCatalog(ctx context.Context, location string) (int, error) {
+	backend, key, err := storage.Open(ctx, location)
+	if err != nil {
+		return 0, err
+	}
+	dir := dirPrefix(key)
+
+	keys, err := backend.List(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := map[string]bool{}
+	rebuilt := 0
+	for _, k := range keys {
+		name, _, _ := strings.Cut(strings.TrimPrefix(k, dir), "/")
+		if seen[name] || name == CatalogDir || name == ObjectsDir || strings.HasSuffix(name, PinSuffix) {
+			continue
+		}
+		seen[name] = true
+
+		base, at, ok := splitName(name)
+		if !ok {
+			continue
+		}
+
+		entry := Entry{ID: strings.TrimPrefix(name, base+"_"), Base: base, Status: StatusIncomplete, StartedAt: at.UTC()}
+		r, err := Open(ctx, backend.URL(dir+name))
+		if err == nil {
+			var manifest *Manifest
+			manifest, err = r.Manifest()
+			if err == nil {
+				status := manifest.Status
+				if status == "" {
+					status = StatusComplete
+					if len(manifest.Errors) > 0 {
+						status = StatusPartial
+					}
+				}
+				entry = entryFromManifest(base, manifest, status)
+			}
+		}
+		if entry.StartedAt.IsZero() {
+			entry.StartedAt = at.UTC()
+		}
+		if entry.ID == "" {
+			entry.ID = strings.TrimSuffix(strings.TrimPrefix(name, base+"_"), "."+archiveFormat(name))
+		}
+		entry.Name = name
+		entry.Archive = archiveFormat(name)
+
+		data, err := json.MarshalIndent(entry, "", "  ")
+		if err != nil {
+			return rebuilt, err
+		}
+		if err := backend.Put(ctx, dir+CatalogDir+"/"+name+".json", data); err != nil {
+			return rebuilt, fmt.Errorf("erro ao gravar o catalogo: %v", err)
+		}
+		rebuilt++
+	}
+
+	return rebuilt, nil
+}
+
+// ParseAsOf le o horario do --as-of. Sem fuso, vale UTC, como os IDs.
+func ParseAsOf(value string) (time.Time, error) {
+	layouts := []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02", idLayout}
+	for _, layout := range layouts {
+		at, err := time.ParseInLocation(layout, value, time.UTC)
+		if err == nil {
+			return at, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("horario invalido %q, use por exemplo 2024-02-01T10:00:00Z ou 2024-02-01", value)
+}
+
+func readCatalog(ctx context.Context, backend storage.Backend, dir string) ([]Entry, error) {
+	keys, err := backend.List(ctx, dir+CatalogDir+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		data, err := backend.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		var entry Entry
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao fazer a desserializacao de %s: %v", backend.URL(key), err)
+		}
+		entry.Path = backend.URL(dir + entry.Name)
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedAt.After(entries[j].StartedAt)
+	})
+	return entries, nil
+}
+
+// deleteCatalog apaga a entrada do snapshot key, se houver.
+func deleteCatalog(ctx context.Context, backend storage.Backend, key string) error {
+	err := backend.Delete(ctx, catalogKey(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// catalogKey devolve a chave da entrada do catalogo do snapshot key.
+func catalogKey(key string) string {
+	return key[:strings.LastIndex(key, "/")+1] + CatalogDir + "/" + nameOf(key) + ".json"
+}
+
+// dirPrefix transforma a chave de um diretorio no prefixo dos objetos dentro
+// dele: "" para o diretorio atual ou a raiz do bucket.
+func dirPrefix(key string) string {
+	if key == "" || key == "." {
+		return ""
+	}
+	return strings.TrimSuffix(key, "/") + "/"
+}
+
+func nameOf(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+// splitName separa o nome de um snapshot em <backupDir> e data, nos formatos
+// atual e antigo.
+func splitName(name string) (string, time.Time, bool) {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '_' {
+			continue
+		}
+		if at, ok := parseTime(name[i+1:]); ok {
+			return name[:i], at, true
+		}
+	}
+	return "", time.Time{}, false
+}
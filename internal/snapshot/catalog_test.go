@@ -0,0 +1,128 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"backup-restore-apigee/internal/storage"
+)
+
+func TestCreateSameSecondAndCatalog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	first, err := Create(ctx, filepath.Join(dir, "apps"), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Create(ctx, filepath.Join(dir, "apps"), "my-org", Options{Archive: FormatTarGz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	running, err := Create(ctx, filepath.Join(dir, "developers"), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !regexp.MustCompile(`apps_\d{8}T\d{6}Z-[0-9a-f]{6}$`).MatchString(first.Path()) {
+		t.Errorf("ID fora do formato: %s", first.Path())
+	}
+
+	first.Expect(KindApp)
+	first.WriteFile(KindApp, "mobile.yaml", []byte("name: mobile\n"))
+	if _, err := first.Close(); err != nil {
+		t.Fatal(err)
+	}
+	second.RecordError(errors.New("falha no developer x"))
+	if _, err := second.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Catalog(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("catalogo com %d entradas: %+v", len(entries), entries)
+	}
+
+	byPath := map[string]Entry{}
+	for _, entry := range entries {
+		byPath[entry.Path] = entry
+	}
+	if e := byPath[first.Path()]; e.Status != StatusComplete || e.Base != "apps" || e.Counts[KindApp] != 1 || e.Organization != "my-org" {
+		t.Errorf("entrada do primeiro snapshot: %+v", e)
+	}
+	if e := byPath[second.Path()]; e.Status != StatusPartial || e.Archive != FormatTarGz || e.Errors != 1 {
+		t.Errorf("entrada do snapshot com erro: %+v", e)
+	}
+	if e := byPath[running.Path()]; e.Status != StatusRunning {
+		t.Errorf("entrada do snapshot em andamento: %+v", e)
+	}
+
+	// O snapshot em andamento nao e candidato ao --as-of.
+	if _, err := AsOf(ctx, filepath.Join(dir, "developers"), time.Now()); err == nil {
+		t.Error("AsOf devolveu um snapshot em andamento")
+	}
+	if _, err := AsOf(ctx, filepath.Join(dir, "apps"), time.Now().Add(-time.Hour)); err == nil {
+		t.Error("AsOf devolveu um snapshot posterior ao horario")
+	}
+	entry, err := AsOf(ctx, filepath.Join(dir, "apps"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Base != "apps" {
+		t.Errorf("AsOf = %+v", entry)
+	}
+	if _, err := Open(ctx, entry.Path); err != nil {
+		t.Errorf("caminho do catalogo nao abre: %v", err)
+	}
+}
+
+func TestRebuildCatalog(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Snapshot antigo, sem ID no manifesto e sem entrada no catalogo.
+	legacy := storage.Join(dir, "apps_01-02-2024_10-00-00")
+	local := storage.Local{}
+	local.Put(ctx, storage.Join(legacy, "mobile.yaml"), []byte("name: mobile\n"))
+	local.Put(ctx, storage.Join(legacy, ManifestFile), []byte(`{"organization":"old-org","counts":{"apps":1}}`))
+	local.Put(ctx, storage.Join(dir, "apps_02-02-2024_10-00-00", "mobile.yaml"), []byte("name: mobile\n"))
+
+	n, err := RebuildCatalog(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("RebuildCatalog = %d", n)
+	}
+
+	entries, _ := Catalog(ctx, dir)
+	if len(entries) != 2 || entries[0].Status != StatusIncomplete || entries[1].Organization != "old-org" || entries[1].ID != "01-02-2024_10-00-00" {
+		t.Errorf("catalogo = %+v", entries)
+	}
+
+	at, _ := ParseAsOf("2024-02-03")
+	entry, err := AsOf(ctx, filepath.Join(dir, "apps"), at)
+	if err != nil || entry.Name != "apps_01-02-2024_10-00-00" {
+		t.Errorf("AsOf = %+v, %v", entry, err)
+	}
+}
+
+func TestParseAsOf(t *testing.T) {
+	want := time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)
+	for _, value := range []string{"2024-02-01T10:00:00Z", "2024-02-01T07:00:00-03:00", "2024-02-01T10:00", "20240201T100000Z"} {
+		got, err := ParseAsOf(value)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseAsOf(%s) = %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseAsOf("ontem"); err == nil {
+		t.Error("ParseAsOf aceitou um horario invalido")
+	}
+}
@@ -0,0 +1,269 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/storage"
+)
+
+// CheckpointFile guarda, na raiz de um snapshot em diretorio, o progresso do
+// backup para o Resume. E apagado quando o snapshot fica completo.
+const CheckpointFile = "checkpoint.json"
+
+// checkpointEvery e quantos developers terminados o MarkDone acumula antes de
+// gravar o checkpoint; o Advance e o Close sempre gravam.
+const checkpointEvery = 100
+
+// Checkpoint e o progresso de um backup. Os developers sao listados em
+// paginas; Cursors e, por tipo, o email onde a listagem recomeca (todos os
+// anteriores ja terminaram) e Done sao os developers ja terminados a partir
+// do cursor.
+type Checkpoint struct {
+	Cursors map[string]string   `json:"cursors"`
+	Done    map[string][]string `json:"done"`
+	// Manifest e o manifesto ate aqui: ID, inicio, cifragem, filtro e os
+	// arquivos ja gravados.
+	Manifest Manifest `json:"manifest"`
+	// Streams e, no formato NDJSON, quantos bytes de StreamPath(kind) o
+	// checkpoint cobre; o Resume corta o resto.
+	Streams map[string]int64 `json:"streams,omitempty"`
+}
+
+// Resume reabre o snapshot em path, interrompido no meio ou terminado com
+// erros, para o backup continuar de onde parou com o mesmo ID. Filtro e
+// redacao vem do snapshot; opts so informa o KMS, obrigatorio se os secrets
+// foram cifrados. Snapshots compactados nao podem ser retomados.
+func Resume(ctx context.Context, path, org string, opts Options) (*Writer, error) {
+	backend, key, err := storage.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if IsArchive(key) {
+		return nil, fmt.Errorf("%s: snapshots compactados nao podem ser retomados", path)
+	}
+
+	data, err := backend.Get(ctx, storage.Join(key, CheckpointFile))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, manifestErr := backend.Get(ctx, storage.Join(key, ManifestFile)); manifestErr == nil {
+			return nil, fmt.Errorf("%s ja esta completo, nao ha o que retomar", path)
+		}
+		return nil, fmt.Errorf("%s nao tem %s: o snapshot foi gravado antes dos checkpoints e nao pode ser retomado", path, CheckpointFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint invalido em %s: %v", path, err)
+	}
+	if cp.Manifest.Organization != org {
+		return nil, fmt.Errorf("o snapshot %s e da organizacao %s, nao de %s", path, cp.Manifest.Organization, org)
+	}
+
+	var sealer *secrets.Sealer
+	if cp.Manifest.Encryption != nil {
+		if opts.KMS == nil {
+			return nil, fmt.Errorf("%s tem os consumer secrets cifrados: informe a mesma chave ou senha do backup", path)
+		}
+		sealer, err = secrets.OpenEnvelope(opts.KMS, *cp.Manifest.Encryption)
+		if err != nil {
+			return nil, err
+		}
+	} else if opts.KMS != nil {
+		return nil, fmt.Errorf("%s foi iniciado sem cifrar os consumer secrets", path)
+	}
+
+	// Os arquivos gravados depois do ultimo checkpoint sao de developers que
+	// nao terminaram e vao ser gravados de novo.
+	known := map[string]bool{CheckpointFile: true, ManifestFile: true}
+	for _, f := range cp.Manifest.Files {
+		known[f.Path] = true
+	}
+	if cp.Manifest.Format == FormatNDJSON {
+		for _, kind := range streamKinds {
+			known[StreamPath(kind)] = true
+		}
+	}
+	keys, err := backend.List(ctx, key+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if name := strings.TrimPrefix(k, key+"/"); !known[name] {
+			if err := backend.Delete(ctx, k); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	manifest := cp.Manifest
+	manifest.Errors = nil
+	manifest.Status = ""
+	manifest.FinishedAt = time.Time{}
+	if manifest.Counts == nil {
+		manifest.Counts = map[string]int{}
+	}
+
+	var sink sink = &objectSink{ctx: ctx, backend: backend, prefix: key}
+	if manifest.Dedup {
+		sink, err = newDedupSink(ctx, backend, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if manifest.Format == FormatNDJSON {
+		stream := newStreamSink(sink)
+		if err := stream.resume(cp.Streams, manifest.Files); err != nil {
+			return nil, err
+		}
+		sink = stream
+	}
+
+	w := &Writer{
+		ctx:        ctx,
+		path:       backend.URL(key),
+		backend:    backend,
+		key:        key,
+		sink:       sink,
+		sealer:     sealer,
+		redact:     manifest.Redacted,
+		filter:     manifest.Filter,
+		checkpoint: true,
+		cursors:    cp.Cursors,
+		done:       map[string]map[string]bool{},
+		finished:   map[string]bool{},
+		manifest:   manifest,
+	}
+	if w.cursors == nil {
+		w.cursors = map[string]string{}
+	}
+	if manifest.Incremental != nil {
+		base, err := OpenIn(ctx, backend, key[:strings.LastIndex(key, "/")+1]+manifest.Incremental.Base)
+		if err != nil {
+			return nil, fmt.Errorf("o snapshot incremental %s depende de %s: %v", path, manifest.Incremental.Base, err)
+		}
+		if err := w.setBase(base); err != nil {
+			return nil, err
+		}
+	}
+	for kind := range cp.Cursors {
+		w.finished[kind] = false
+	}
+	for kind, emails := range cp.Done {
+		w.finished[kind] = false
+		w.done[kind] = map[string]bool{}
+		for _, email := range emails {
+			w.done[kind][email] = true
+		}
+	}
+
+	if err := w.writeCatalog(StatusRunning); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Cursor devolve o email onde a listagem de developers do tipo kind
+// recomeca, ou vazio para comecar do inicio.
+func (w *Writer) Cursor(kind string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.cursors[kind]
+}
+
+// Done diz se o developer ja terminou para o tipo kind em uma execucao
+// anterior (ou nesta).
+func (w *Writer) Done(kind, email string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.done[kind][strings.ToLower(email)]
+}
+
+// MarkDone registra que todos os documentos do developer para o tipo kind
+// foram gravados. O checkpoint e gravado a cada checkpointEvery developers.
+func (w *Writer) MarkDone(kind, email string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.finished[kind] = false
+	if w.done[kind] == nil {
+		w.done[kind] = map[string]bool{}
+	}
+	w.done[kind][strings.ToLower(email)] = true
+	if w.unsaved++; w.unsaved < checkpointEvery {
+		return nil
+	}
+	return w.saveCheckpoint()
+}
+
+// Advance move o cursor do tipo kind para email quando todos os developers
+// ate ele terminaram. Os anteriores saem do checkpoint, ja que a listagem
+// retomada comeca no cursor; os seguintes ja terminados ficam.
+func (w *Writer) Advance(kind, email string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	email = strings.ToLower(email)
+	w.finished[kind] = false
+	w.cursors[kind] = email
+	done := map[string]bool{email: true}
+	for other := range w.done[kind] {
+		if other > email {
+			done[other] = true
+		}
+	}
+	w.done[kind] = done
+	return w.saveCheckpoint()
+}
+
+// Finish registra que todos os recursos do tipo kind foram lidos. Um tipo com
+// progresso registrado (MarkDone, Advance) e sem Finish deixa o snapshot
+// parcial, assim como qualquer erro.
+func (w *Writer) Finish(kind string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finished[kind] = true
+}
+
+// saveCheckpoint grava o progresso. Chamado com w.mu travado; snapshots
+// compactados nao tem checkpoint.
+func (w *Writer) saveCheckpoint() error {
+	if !w.checkpoint {
+		return nil
+	}
+	w.unsaved = 0
+
+	cp := Checkpoint{Cursors: w.cursors, Done: map[string][]string{}, Manifest: w.manifest}
+	if stream, ok := w.sink.(*streamSink); ok {
+		// As linhas do NDJSON vao para o arquivo antes do checkpoint que as
+		// lista.
+		offsets, err := stream.flush()
+		if err != nil {
+			return fmt.Errorf("erro ao gravar o checkpoint: %v", err)
+		}
+		cp.Streams = offsets
+	}
+	for kind, emails := range w.done {
+		for email := range emails {
+			cp.Done[kind] = append(cp.Done[kind], email)
+		}
+		sort.Strings(cp.Done[kind])
+	}
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := w.sink.writeFile(CheckpointFile, data); err != nil {
+		return fmt.Errorf("erro ao gravar o checkpoint: %v", err)
+	}
+	return nil
+}
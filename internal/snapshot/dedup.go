@@ -0,0 +1,198 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/storage"
+)
+
+// ObjectsDir e o diretorio (ou prefixo no bucket), ao lado dos snapshots, com
+// os documentos dos snapshots deduplicados (Options.Dedup), um objeto por
+// conteudo: .objects/<2 primeiros digitos>/<SHA-256>. O snapshot em si so tem
+// o manifesto, que ja lista o SHA-256 de cada documento.
+const ObjectsDir = ".objects"
+
+// gcGrace e por quanto tempo um backup em andamento impede a coleta dos
+// objetos: os documentos que ele gravou depois do ultimo checkpoint ainda nao
+// estao em nenhum manifesto.
+const gcGrace = 24 * time.Hour
+
+// objectKey devolve a chave do objeto com o SHA-256 sum, em dir.
+func objectKey(dir, sum string) string {
+	return dir + ObjectsDir + "/" + sum[:2] + "/" + sum
+}
+
+// dedupSink grava o manifesto e o checkpoint no diretorio do snapshot e cada
+// documento em ObjectsDir, so se o conteudo ainda nao estiver la.
+type dedupSink struct {
+	*objectSink
+	dir     string
+	present map[string]bool
+}
+
+func newDedupSink(ctx context.Context, backend storage.Backend, prefix string) (*dedupSink, error) {
+	sink, err := newObjectSink(ctx, backend, prefix)
+	if err != nil {
+		return nil, err
+	}
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	// Uma listagem no inicio evita regravar, a cada backup, os objetos que
+	// ja existem.
+	keys, err := backend.List(ctx, dir+ObjectsDir+"/")
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, key := range keys {
+		present[key] = true
+	}
+	return &dedupSink{objectSink: sink, dir: dir, present: present}, nil
+}
+
+func (d *dedupSink) writeFile(name string, data []byte) error {
+	if name == ManifestFile || name == CheckpointFile {
+		return d.objectSink.writeFile(name, data)
+	}
+
+	key := objectKey(d.dir, Checksum(data))
+	if d.present[key] {
+		return nil
+	}
+	if err := d.backend.Put(d.ctx, key, data); err != nil {
+		return err
+	}
+	d.present[key] = true
+	return nil
+}
+
+// dedupSource le os documentos de um snapshot deduplicado pelo SHA-256 do
+// manifesto.
+type dedupSource struct {
+	ctx     context.Context
+	backend storage.Backend
+	prefix  string
+	dir     string
+	sums    map[string]string
+}
+
+func newDedupSource(ctx context.Context, backend storage.Backend, prefix string, manifest *Manifest) *dedupSource {
+	sums := map[string]string{}
+	for _, f := range manifest.Files {
+		sums[f.Path] = f.SHA256
+	}
+	return &dedupSource{ctx: ctx, backend: backend, prefix: prefix, dir: prefix[:strings.LastIndex(prefix, "/")+1], sums: sums}
+}
+
+func (d *dedupSource) list() ([]string, error) {
+	names := []string{ManifestFile}
+	for name := range d.sums {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (d *dedupSource) read(name string) ([]byte, error) {
+	if name == ManifestFile {
+		return d.backend.Get(d.ctx, storage.Join(d.prefix, name))
+	}
+	sum, ok := d.sums[name]
+	if !ok || len(sum) < 2 {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	data, err := d.backend.Get(d.ctx, objectKey(d.dir, sum))
+	if err != nil {
+		return nil, fmt.Errorf("objeto %s de %s: %w", sum, name, err)
+	}
+	return data, nil
+}
+
+// CollectGarbage apaga de ObjectsDir os objetos que nenhum snapshot
+// deduplicado ao lado de backupDir usa mais, depois do prune. Os snapshots
+// interrompidos contam pelo checkpoint; com um backup em andamento ha menos
+// de 24h, nada e apagado. Devolve o numero de objetos apagados.
+func CollectGarbage(ctx context.Context, backupDir string) (int, error) {
+	backend, base, err := storage.Open(ctx, backupDir)
+	if err != nil {
+		return 0, err
+	}
+	dir := base[:strings.LastIndex(base, "/")+1]
+
+	objects, err := backend.List(ctx, dir+ObjectsDir+"/")
+	if err != nil || len(objects) == 0 {
+		return 0, err
+	}
+
+	entries, err := readCatalog(ctx, backend, dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.Status == StatusRunning && time.Since(entry.StartedAt) < gcGrace {
+			return 0, fmt.Errorf("o backup %s esta em andamento: rode a coleta dos objetos depois que ele terminar", entry.Path)
+		}
+	}
+
+	keys, err := backend.List(ctx, dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Os manifestos (ou checkpoints) de todos os snapshots do diretorio,
+	// achados pela listagem e nao pelo catalogo, que pode estar incompleto.
+	used := map[string]bool{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, dir)
+		if strings.Count(name, "/") != 1 || (!strings.HasSuffix(name, "/"+ManifestFile) && !strings.HasSuffix(name, "/"+CheckpointFile)) {
+			continue
+		}
+
+		data, err := backend.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		manifest, err := referencedManifest(name, data)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", backend.URL(key), err)
+		}
+		if manifest.Dedup {
+			for _, f := range manifest.Files {
+				used[objectKey(dir, f.SHA256)] = true
+			}
+		}
+	}
+
+	removed := 0
+	for _, key := range objects {
+		if used[key] {
+			continue
+		}
+		if err := backend.Delete(ctx, key); err != nil {
+			return removed, fmt.Errorf("erro ao apagar %s: %v", backend.URL(key), err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// referencedManifest le o manifesto de um manifest.json ou de um checkpoint.
+func referencedManifest(name string, data []byte) (*Manifest, error) {
+	if strings.HasSuffix(name, "/"+CheckpointFile) {
+		var cp Checkpoint
+		if err := json.Unmarshal(data, &cp); err != nil {
+			return nil, err
+		}
+		return &cp.Manifest, nil
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
@@ -0,0 +1,244 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/secrets"
+
+	"gopkg.in/yaml.v2"
+)
+
+// WriteApp grava o app em w.AppPath(developerId, name). Conforme as opcoes do snapshot, os
+// consumer secrets sao cifrados ou trocados pela impressao digital.
+func (w *Writer) WriteApp(app model.AppBackup) error {
+	if w.sealer != nil || w.redact {
+		app.Credentials = append([]model.Credential(nil), app.Credentials...)
+		for i, cred := range app.Credentials {
+			secret, err := w.protectSecret(cred)
+			if err != nil {
+				return err
+			}
+			app.Credentials[i].ConsumerSecret = secret
+		}
+	}
+
+	return w.writeApp(app)
+}
+
+// writeApp grava o app como esta, sem mexer nos consumer secrets.
+func (w *Writer) writeApp(app model.AppBackup) error {
+	data, err := EncodeApp(app, w.manifest.Format)
+	if err != nil {
+		return err
+	}
+
+	return w.writeDocument(KindApp, w.AppPath(app.DeveloperID, app.Name), data, app.LastModifiedAt)
+}
+
+// AppPath devolve o caminho do app neste snapshot, com a extensao do formato.
+func (w *Writer) AppPath(developerEmail, appName string) string {
+	return appPath(developerEmail, appName, documentExt(w.manifest.Format, KindApp))
+}
+
+// EncodeApp devolve o documento do app na forma canonica: listas ordenadas
+// (model.AppBackup.Canonical) e o formato pedido (YAML quando vazio). O mesmo
+// app gera sempre os mesmos bytes.
+func EncodeApp(app model.AppBackup, format string) ([]byte, error) {
+	data, err := encodeDocument(format, KindApp, app.Canonical())
+	if err != nil {
+		return nil, fmt.Errorf("erro ao converter o backup do App: %v", err)
+	}
+	return data, nil
+}
+
+func (w *Writer) protectSecret(cred model.Credential) (string, error) {
+	if w.redact {
+		fingerprint, err := secrets.Fingerprint(cred.ConsumerSecret)
+		if err != nil {
+			return "", fmt.Errorf("erro ao gerar a impressao digital do consumerSecret de %s: %v", cred.ConsumerKey, err)
+		}
+		return fingerprint, nil
+	}
+
+	sealed, err := w.sealer.Seal(cred.ConsumerSecret, cred.ConsumerKey)
+	if err != nil {
+		return "", fmt.Errorf("erro ao cifrar o consumerSecret de %s: %v", cred.ConsumerKey, err)
+	}
+	return sealed, nil
+}
+
+// DeveloperPath devolve o caminho do developer dentro do snapshot, no formato
+// padrao. O email passa por EncodeName, como nos diretorios dos apps.
+func DeveloperPath(email string) string {
+	return EncodeName(email) + ".json"
+}
+
+// DeveloperPath devolve o caminho do developer neste snapshot, com a extensao
+// do formato.
+func (w *Writer) DeveloperPath(email string) string {
+	return EncodeName(email) + documentExt(w.manifest.Format, KindDeveloper)
+}
+
+// WriteDeveloper grava o developer em w.DeveloperPath(email).
+func (w *Writer) WriteDeveloper(developer model.DeveloperBackup) error {
+	backupData, err := EncodeDeveloper(developer, w.manifest.Format)
+	if err != nil {
+		return err
+	}
+
+	return w.writeDocument(KindDeveloper, w.DeveloperPath(developer.Email), backupData, developer.LastModifiedAt)
+}
+
+// EncodeDeveloper devolve o documento do developer na forma canonica: apps
+// ordenados (model.DeveloperBackup.Canonical) e o formato pedido (JSON
+// indentado com 2 espacos quando vazio).
+func EncodeDeveloper(developer model.DeveloperBackup, format string) ([]byte, error) {
+	data, err := encodeDocument(format, KindDeveloper, developer.Canonical())
+	if err != nil {
+		return nil, fmt.Errorf("erro ao converter o developer: %v", err)
+	}
+	return data, nil
+}
+
+// encodeDocument grava doc em YAML, em JSON indentado ou, no NDJSON, em JSON
+// numa linha so.
+func encodeDocument(format, kind string, doc interface{}) ([]byte, error) {
+	switch {
+	case format == FormatNDJSON:
+		return json.Marshal(doc)
+	case documentExt(format, kind) == ".json":
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	return yaml.Marshal(doc)
+}
+
+// decodeDocument le name em JSON ou YAML, conforme a extensao. strict recusa
+// campos desconhecidos, como no Verify.
+func decodeDocument(name string, data []byte, v interface{}, strict bool) error {
+	if isJSON(name) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			decoder.DisallowUnknownFields()
+		}
+		if err := decoder.Decode(v); err != nil {
+			return fmt.Errorf("JSON invalido: %v", err)
+		}
+		return nil
+	}
+
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	if err := unmarshal(data, v); err != nil {
+		return fmt.Errorf("YAML invalido: %v", err)
+	}
+	return nil
+}
+
+// SetKMS informa a chave usada para decifrar os consumer secrets, tambem na
+// base de um snapshot incremental.
+func (r *Reader) SetKMS(kms secrets.KMS) {
+	r.kms = kms
+	r.sealer = nil
+	if r.base != nil {
+		r.base.SetKMS(kms)
+	}
+}
+
+// Encrypted informa se o manifesto do snapshot declara segredos cifrados.
+func (r *Reader) Encrypted() bool {
+	manifest, err := r.Manifest()
+	return err == nil && manifest.Encryption != nil
+}
+
+// ErrNoKey e devolvido ao ler um app com secrets cifrados sem SetKMS.
+var ErrNoKey = errors.New("o snapshot tem segredos cifrados")
+
+// ReadApp le e decodifica um app do snapshot, decifrando os consumer secrets.
+// Secrets removidos com RedactSecrets voltam como a impressao digital; use
+// secrets.IsRedacted para detectar.
+func (r *Reader) ReadApp(name string) (model.AppBackup, error) {
+	// Cada snapshot da cadeia tem a sua chave.
+	if layer := r.layerOf(name); layer != r {
+		return layer.ReadApp(name)
+	}
+
+	app, err := r.ReadAppSealed(name)
+	if err != nil {
+		return app, err
+	}
+
+	for i, cred := range app.Credentials {
+		if !secrets.IsSealed(cred.ConsumerSecret) {
+			continue
+		}
+
+		sealer, err := r.openSealer()
+		if err != nil {
+			return app, err
+		}
+		app.Credentials[i].ConsumerSecret, err = sealer.Open(cred.ConsumerSecret, cred.ConsumerKey)
+		if err != nil {
+			return app, fmt.Errorf("consumerSecret de %s: %v", cred.ConsumerKey, err)
+		}
+	}
+
+	return app, nil
+}
+
+// ReadAppSealed le o app sem decifrar os consumer secrets, que voltam como
+// gravados (enc:v1:...). Serve para comparar snapshots sem a chave.
+func (r *Reader) ReadAppSealed(name string) (model.AppBackup, error) {
+	var app model.AppBackup
+
+	data, err := r.ReadFile(name)
+	if err != nil {
+		return app, fmt.Errorf("erro ao ler o arquivo de backup: %v", err)
+	}
+
+	err = decodeDocument(name, data, &app, false)
+	if err != nil {
+		return app, fmt.Errorf("erro ao fazer a desserializacao do arquivo de backup: %v", err)
+	}
+
+	return app, nil
+}
+
+// ReadDeveloper le e decodifica um developer do snapshot.
+func (r *Reader) ReadDeveloper(name string) (model.DeveloperBackup, error) {
+	var developer model.DeveloperBackup
+
+	data, err := r.ReadFile(name)
+	if err != nil {
+		return developer, err
+	}
+
+	// Decodificar o arquivo JSON (ou YAML) em uma estrutura DeveloperBackup
+	err = decodeDocument(name, data, &developer, false)
+	return developer, err
+}
+
+func (r *Reader) openSealer() (*secrets.Sealer, error) {
+	if r.sealer != nil {
+		return r.sealer, nil
+	}
+
+	manifest, err := r.Manifest()
+	if err != nil || manifest.Encryption == nil {
+		return nil, fmt.Errorf("o backup tem segredos cifrados mas o manifesto nao tem a chave do snapshot")
+	}
+	if r.kms == nil {
+		return nil, fmt.Errorf("%w (%s): informe --secrets-key-file ou --secrets-passphrase-env", ErrNoKey, manifest.Encryption.Scheme)
+	}
+
+	r.sealer, err = secrets.OpenEnvelope(r.kms, *manifest.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	return r.sealer, nil
+}
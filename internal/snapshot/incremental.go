@@ -0,0 +1,319 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/model"
+	"backup-restore-apigee/internal/storage"
+)
+
+// ErrNoBase e devolvido pelo Create incremental quando nao ha, no catalogo,
+// um snapshot completo anterior do mesmo backupDir e da mesma organizacao.
+var ErrNoBase = errors.New("nenhum snapshot completo anterior para servir de base ao backup incremental")
+
+// Incremental liga um snapshot incremental a sua base. O snapshot so tem os
+// developers e apps cujo lastModifiedAt mudou; o resto vem da base, que pode
+// ser incremental tambem. O Open monta a visao completa sozinho.
+type Incremental struct {
+	// Base e o nome do snapshot anterior, no mesmo diretorio.
+	Base string `json:"base"`
+	// Deleted sao os documentos da base que nao existem mais na organizacao
+	// (tombstones): saem da visao completa.
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// findBase abre o snapshot completo mais recente de key (o <backupDir>) no
+// catalogo, para base de um backup incremental com as opcoes opts.
+func findBase(ctx context.Context, backend storage.Backend, key, org string, opts Options) (*Reader, string, error) {
+	dir := key[:strings.LastIndex(key, "/")+1]
+	entries, err := readCatalog(ctx, backend, dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, entry := range entries {
+		if entry.Base != key[len(dir):] || entry.Organization != org || entry.Status != StatusComplete {
+			continue
+		}
+
+		base, err := OpenIn(ctx, backend, dir+entry.Name)
+		if err != nil {
+			return nil, "", err
+		}
+		manifest, err := base.Manifest()
+		if err != nil {
+			return nil, "", err
+		}
+		if manifest.Redacted != opts.RedactSecrets || (manifest.Encryption != nil) != (opts.KMS != nil) || manifest.Filter.String() != opts.Filter.String() || manifest.Format != opts.Format {
+			return nil, "", fmt.Errorf("o backup incremental precisa das mesmas opcoes de secrets, dos mesmos filtros e do mesmo formato do snapshot base %s", entry.Path)
+		}
+		return base, entry.Name, nil
+	}
+
+	return nil, "", fmt.Errorf("%w: %s", ErrNoBase, backend.URL(key))
+}
+
+// setBase indexa os documentos da visao completa da base por tipo e
+// developer. Snapshots sem o lastModifiedAt no manifesto ou com apps no
+// layout antigo nao servem de base.
+func (w *Writer) setBase(base *Reader) error {
+	docs, err := base.documents()
+	if err != nil {
+		return err
+	}
+	kinds, err := base.kinds()
+	if err != nil {
+		return err
+	}
+
+	w.base = base
+	w.modified = map[string]int64{}
+	w.baseDocs = map[string]map[string][]string{}
+	for name, kind := range kinds {
+		doc, ok := docs[name]
+		email := developerOf(kind, name)
+		if !ok || email == "" {
+			return fmt.Errorf("o snapshot %s foi gravado por uma versao antiga e nao serve de base para um backup incremental: faca um backup completo", base.Path())
+		}
+		w.modified[name] = doc.Modified
+		if w.baseDocs[kind] == nil {
+			w.baseDocs[kind] = map[string][]string{}
+		}
+		w.baseDocs[kind][email] = append(w.baseDocs[kind][email], name)
+	}
+
+	w.deleted = map[string]bool{}
+	for _, name := range w.manifest.Incremental.Deleted {
+		w.deleted[name] = true
+	}
+	return nil
+}
+
+// developerOf devolve o email (minusculo) do developer dono do documento, ou
+// vazio fora do layout atual. Snapshots antigos gravaram o email do developer
+// sem EncodeName; um nome que nao decodifica vale como esta.
+func developerOf(kind, name string) string {
+	switch kind {
+	case KindDeveloper:
+		if ext := path.Ext(name); !strings.Contains(name, "/") && (ext == ".json" || ext == ".yaml") {
+			email := strings.TrimSuffix(name, ext)
+			if decoded, err := DecodeName(email); err == nil {
+				email = decoded
+			}
+			return strings.ToLower(email)
+		}
+	case KindApp:
+		parts := strings.Split(name, "/")
+		if len(parts) == 3 && parts[0] == AppsDir {
+			if email, err := DecodeName(parts[1]); err == nil {
+				return strings.ToLower(email)
+			}
+		}
+	}
+	return ""
+}
+
+// Incremental diz se o snapshot e incremental (Options.Incremental).
+func (w *Writer) Incremental() bool {
+	return w.base != nil
+}
+
+// Base devolve a visao completa do snapshot base, ou nil se o snapshot nao
+// e incremental.
+func (w *Writer) Base() *Reader {
+	return w.base
+}
+
+// Unchanged diz se o documento name esta na base com o mesmo lastModifiedAt:
+// o backup incremental nao precisa buscar os detalhes nem grava-lo.
+func (w *Writer) Unchanged(name string, modified int64) bool {
+	if w.base == nil || modified == 0 {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	previous, ok := w.modified[name]
+	return ok && !w.deleted[name] && previous == modified
+}
+
+// BaseDevelopers devolve, em ordem, os emails (minusculos) dos developers com
+// documentos do tipo kind na base.
+func (w *Writer) BaseDevelopers(kind string) []string {
+	emails := make([]string, 0, len(w.baseDocs[kind]))
+	for email := range w.baseDocs[kind] {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// Listed registra os documentos do tipo kind do developer que continuam na
+// organizacao, gravados ou sem mudanca. Os outros documentos dele na base
+// viram tombstones; paths nil e um developer que nao existe mais.
+func (w *Writer) Listed(kind, email string, paths []string) {
+	if w.base == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	present := map[string]bool{}
+	for _, name := range paths {
+		present[name] = true
+	}
+	for _, name := range w.baseDocs[kind][strings.ToLower(email)] {
+		if !present[name] && !w.deleted[name] {
+			w.deleted[name] = true
+			w.manifest.Incremental.Deleted = append(w.manifest.Incremental.Deleted, name)
+		}
+	}
+}
+
+// openBase abre a base de um snapshot incremental, no mesmo diretorio dele.
+func (r *Reader) openBase(ctx context.Context, backend storage.Backend, key string) error {
+	manifest, err := r.Manifest()
+	if err != nil || manifest.Incremental == nil {
+		return nil
+	}
+
+	base, err := OpenIn(ctx, backend, key[:strings.LastIndex(key, "/")+1]+manifest.Incremental.Base)
+	if err != nil {
+		return fmt.Errorf("o snapshot incremental %s depende de %s: %v", r.path, manifest.Incremental.Base, err)
+	}
+	files, err := r.ownFiles()
+	if err != nil {
+		return err
+	}
+
+	r.base = base
+	r.own = map[string]bool{}
+	for _, name := range files {
+		r.own[name] = true
+	}
+	r.deleted = map[string]bool{}
+	for _, name := range manifest.Incremental.Deleted {
+		r.deleted[name] = true
+	}
+	return nil
+}
+
+// layerOf devolve o snapshot da cadeia de onde vem o documento name: este,
+// se o tem ou se ele foi apagado, ou a base.
+func (r *Reader) layerOf(name string) *Reader {
+	if r.base == nil || r.own[name] || r.deleted[name] {
+		return r
+	}
+	return r.base.layerOf(name)
+}
+
+// kinds devolve o tipo de cada documento da visao completa do snapshot.
+func (r *Reader) kinds() (map[string]string, error) {
+	files, err := r.ownFiles()
+	if err != nil {
+		return nil, err
+	}
+	manifest, _ := r.Manifest()
+
+	kinds := map[string]string{}
+	if r.base != nil {
+		baseKinds, err := r.base.kinds()
+		if err != nil {
+			return nil, err
+		}
+		for name, kind := range baseKinds {
+			if !r.deleted[name] {
+				kinds[name] = kind
+			}
+		}
+	}
+	for _, name := range files {
+		kinds[name] = kindOf(manifest, name)
+	}
+	return kinds, nil
+}
+
+// documents devolve a entrada do manifesto de cada documento da visao
+// completa, vinda do snapshot da cadeia que o tem.
+func (r *Reader) documents() (map[string]File, error) {
+	docs := map[string]File{}
+	if r.base != nil {
+		baseDocs, err := r.base.documents()
+		if err != nil {
+			return nil, err
+		}
+		for name, doc := range baseDocs {
+			if !r.deleted[name] {
+				docs[name] = doc
+			}
+		}
+	}
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range manifest.Files {
+		docs[doc.Path] = doc
+	}
+	return docs, nil
+}
+
+// Materialize grava em w a visao completa de r (a base mais os
+// incrementais) como um snapshot completo, que pode servir de nova base e
+// dispensa os anteriores. Os documentos sao gravados de novo no formato de w
+// (Options.Format), o que tambem serve para converter entre formatos. Com
+// secrets cifrados, os apps sao decifrados com o KMS de r (SetKMS) e cifrados
+// de novo com a chave de w. Devolve o numero de documentos gravados.
+func Materialize(r *Reader, w *Writer) (int, error) {
+	kinds, err := r.kinds()
+	if err != nil {
+		return 0, err
+	}
+	encrypted := r.Encrypted()
+
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		kind := kinds[name]
+		w.Expect(kind)
+
+		switch kind {
+		case KindApp:
+			var app model.AppBackup
+			if encrypted {
+				app, err = r.ReadApp(name)
+				if err == nil {
+					err = w.WriteApp(app)
+				}
+			} else {
+				// Impressoes digitais e secrets em texto claro vao como estao.
+				app, err = r.ReadAppSealed(name)
+				if err == nil {
+					err = w.writeApp(app)
+				}
+			}
+		case KindDeveloper:
+			var developer model.DeveloperBackup
+			developer, err = r.ReadDeveloper(name)
+			if err == nil {
+				err = w.WriteDeveloper(developer)
+			}
+		default:
+			err = fmt.Errorf("tipo de documento desconhecido: %s", kind)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return len(names), nil
+}
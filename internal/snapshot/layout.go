@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// AppsDir e o diretorio dos apps dentro do snapshot. Nome de app so e unico
+// por developer, entao cada developer tem o seu subdiretorio:
+// apps/<email>/<app>.yaml. Snapshots antigos tem os apps soltos na raiz
+// (<app>.yaml) e continuam sendo lidos.
+const AppsDir = "apps"
+
+// Formatos dos documentos (Options.Format). Vazio e o formato de sempre:
+// apps em YAML e developers em JSON. Com FormatNDJSON os documentos de cada
+// tipo ficam em um unico arquivo, StreamPath(kind), um por linha.
+const (
+	FormatYAML   = "yaml"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// AppPath devolve o caminho do app dentro do snapshot, no formato padrao.
+func AppPath(developerEmail, appName string) string {
+	return appPath(developerEmail, appName, ".yaml")
+}
+
+func appPath(developerEmail, appName, ext string) string {
+	return AppsDir + "/" + EncodeName(developerEmail) + "/" + EncodeName(appName) + ext
+}
+
+// StreamPath devolve o arquivo NDJSON com os documentos do tipo kind.
+func StreamPath(kind string) string {
+	return kind + ".ndjson"
+}
+
+// CheckFormat valida um formato de documento.
+func CheckFormat(format string) error {
+	switch format {
+	case "", FormatYAML, FormatJSON, FormatNDJSON:
+		return nil
+	}
+	return fmt.Errorf("formato de documento desconhecido: %s (use %s, %s ou %s)", format, FormatYAML, FormatJSON, FormatNDJSON)
+}
+
+// documentExt devolve a extensao dos documentos do tipo kind no formato. No
+// NDJSON e a extensao dos caminhos de cada linha dentro do arquivo.
+func documentExt(format, kind string) string {
+	switch format {
+	case FormatYAML:
+		return ".yaml"
+	case FormatJSON, FormatNDJSON:
+		return ".json"
+	}
+	if kind == KindApp {
+		return ".yaml"
+	}
+	return ".json"
+}
+
+// isJSON informa se o documento name e JSON, pela extensao; o resto e YAML.
+func isJSON(name string) bool {
+	return path.Ext(name) == ".json"
+}
+
+// EncodeName deixa um email ou nome de app seguro para ser nome de arquivo
+// e chave de bucket: letras, numeros e "-_.@+" ficam como estao, o resto
+// (inclusive "/", "%" e um "." no inicio) vira %XX.
+func EncodeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if isSafeNameByte(c) && !(c == '.' && i == 0) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// DecodeName desfaz EncodeName.
+func DecodeName(encoded string) (string, error) {
+	return url.PathUnescape(encoded)
+}
+
+func isSafeNameByte(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("-_.@+", c) >= 0
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeName(t *testing.T) {
+	cases := map[string]string{
+		"alice@example.com":   "alice@example.com",
+		"joao+test@acme.io":   "joao+test@acme.io",
+		"Meu App/v2":          "Meu%20App%2Fv2",
+		"..":                  "%2E.",
+		"100%":                "100%25",
+		"app:prod?x=1":        "app%3Aprod%3Fx%3D1",
+		"relatorio-mensal_v1": "relatorio-mensal_v1",
+	}
+	for name, want := range cases {
+		got := EncodeName(name)
+		if got != want {
+			t.Errorf("EncodeName(%q) = %q, esperado %q", name, got, want)
+		}
+		decoded, err := DecodeName(got)
+		if err != nil || decoded != name {
+			t.Errorf("DecodeName(%q) = %q, %v", got, decoded, err)
+		}
+	}
+
+	if got := AppPath("alice@example.com", "Meu App"); got != "apps/alice@example.com/Meu%20App.yaml" {
+		t.Errorf("AppPath = %s", got)
+	}
+	if got := DeveloperPath("ops/team@example.com"); got != "ops%2Fteam@example.com.json" {
+		t.Errorf("DeveloperPath = %s", got)
+	}
+}
+
+func TestDeveloperOf(t *testing.T) {
+	cases := []struct{ kind, name, want string }{
+		{KindDeveloper, "Alice@Example.com.json", "alice@example.com"},
+		{KindDeveloper, "ops%2Fteam@example.com.yaml", "ops/team@example.com"},
+		// Nome antigo, gravado sem EncodeName.
+		{KindDeveloper, "100%@example.com.json", "100%@example.com"},
+		{KindDeveloper, "ops/team@example.com.json", ""},
+		{KindApp, "apps/ops%2Fteam@example.com/mobile.yaml", "ops/team@example.com"},
+		{KindApp, "mobile.yaml", ""},
+	}
+	for _, c := range cases {
+		if got := developerOf(c.kind, c.name); got != c.want {
+			t.Errorf("developerOf(%s, %q) = %q, esperado %q", c.kind, c.name, got, c.want)
+		}
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	ctx := context.Background()
+	w, err := Create(ctx, filepath.Join(t.TempDir(), "apps"), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteFile(KindApp, AppPath("alice@example.com", "mobile"), []byte("name: mobile\n"))
+	w.WriteFile(KindApp, "web.yaml", []byte("name: web\n"))
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for location, want := range map[string]string{
+		filepath.Join(w.Path(), "apps", "alice@example.com", "mobile.yaml"): "apps/alice@example.com/mobile.yaml",
+		filepath.Join(w.Path(), "web.yaml"):                                 "web.yaml",
+	} {
+		r, name, err := OpenFile(ctx, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != want || r.Path() != w.Path() {
+			t.Errorf("OpenFile(%s) = %s %s", location, r.Path(), name)
+		}
+		if _, err := r.Manifest(); err != nil {
+			t.Errorf("OpenFile(%s) nao achou o manifesto: %v", location, err)
+		}
+	}
+
+}
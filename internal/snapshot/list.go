@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"backup-restore-apigee/internal/storage"
+)
+
+// PinSuffix e o sufixo do marcador gravado ao lado de um snapshot fixado. O
+// marcador fica fora do snapshot para nao mudar o manifesto nem o arquivo
+// compactado.
+const PinSuffix = ".pinned"
+
+// Info descreve um snapshot encontrado por List.
+type Info struct {
+	// Name e o nome do snapshot, ex: apps_20240201T100000Z-3fa2c1.tar.gz.
+	Name string
+	// Path e o caminho ou URL aceito por Open.
+	Path   string
+	Time   time.Time
+	Pinned bool
+	// Base e o nome da base de um snapshot incremental, pelo catalogo.
+	Base string
+
+	backend storage.Backend
+	key     string
+	keys    []string
+}
+
+// List devolve os snapshots criados com backupDir (o mesmo argumento do
+// backup), do mais novo para o mais antigo. Funciona no disco local e nos
+// buckets.
+func List(ctx context.Context, backupDir string) ([]Info, error) {
+	backend, base, err := storage.Open(ctx, backupDir)
+	if err != nil {
+		return nil, err
+	}
+	// parent termina com "/" (ou e vazio) para valer tanto para caminhos
+	// locais quanto para chaves de bucket.
+	parent := base[:strings.LastIndex(base, "/")+1]
+
+	keys, err := backend.List(ctx, base+"_")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Info{}
+	pinned := map[string]bool{}
+	for _, key := range keys {
+		name, _, _ := strings.Cut(strings.TrimPrefix(key, parent), "/")
+		if strings.HasSuffix(name, PinSuffix) {
+			pinned[strings.TrimSuffix(name, PinSuffix)] = true
+			continue
+		}
+
+		info, ok := byName[name]
+		if !ok {
+			at, ok := parseTime(strings.TrimPrefix(name, base[len(parent):]+"_"))
+			if !ok {
+				continue
+			}
+			snapKey := parent + name
+			info = &Info{Name: name, Path: backend.URL(snapKey), Time: at, backend: backend, key: snapKey}
+			byName[name] = info
+		}
+		info.keys = append(info.keys, key)
+	}
+
+	// Sem catalogo nao ha como saber quais sao incrementais.
+	bases := map[string]string{}
+	if entries, err := readCatalog(ctx, backend, parent); err == nil {
+		for _, entry := range entries {
+			bases[entry.Name] = entry.Incremental
+		}
+	}
+
+	infos := make([]Info, 0, len(byName))
+	for name, info := range byName {
+		info.Pinned = pinned[name]
+		info.Base = bases[name]
+		infos = append(infos, *info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Time.After(infos[j].Time)
+	})
+
+	return infos, nil
+}
+
+// Delete apaga todos os objetos do snapshot e o marcador de fixado.
+func Delete(ctx context.Context, info Info) error {
+	if info.Pinned {
+		return fmt.Errorf("%s esta fixado, desafixe antes de apagar", info.Path)
+	}
+
+	// O manifesto vai primeiro: um snapshot apagado pela metade fica sem
+	// manifesto e o verify acusa.
+	keys := append([]string(nil), info.keys...)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return strings.HasSuffix(keys[i], "/"+ManifestFile)
+	})
+
+	for _, key := range keys {
+		if err := info.backend.Delete(ctx, key); err != nil {
+			return fmt.Errorf("erro ao apagar %s: %v", info.backend.URL(key), err)
+		}
+	}
+
+	if dm, ok := info.backend.(storage.DirMaker); ok && !IsArchive(info.key) {
+		if err := dm.RemoveDir(info.key); err != nil {
+			return err
+		}
+	}
+
+	return deleteCatalog(ctx, info.backend, info.key)
+}
+
+// Pin fixa (ou desafixa) o snapshot em path, que deixa de ser apagado pelo
+// prune.
+func Pin(ctx context.Context, path string, pinned bool) error {
+	backend, key, err := storage.Open(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if !pinned {
+		err := backend.Delete(ctx, key+PinSuffix)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if _, err := Open(ctx, path); err != nil {
+		return err
+	}
+	return backend.Put(ctx, key+PinSuffix, []byte("pinned\n"))
+}
+
+// parseTime le o ID do nome de um snapshot, com ou sem a extensao do arquivo
+// compactado: 20240201T100000Z-3fa2c1 ou, nos snapshots antigos,
+// 01-02-2024_10-00-00 em hora local.
+func parseTime(suffix string) (time.Time, bool) {
+	if format := archiveFormat(suffix); format != "" {
+		suffix = strings.TrimSuffix(suffix, "."+format)
+	}
+
+	if len(suffix) > len(idLayout) && suffix[len(idLayout)] == '-' {
+		if at, err := time.Parse(idLayout, suffix[:len(idLayout)]); err == nil {
+			return at, true
+		}
+	}
+
+	at, err := time.ParseInLocation(legacyLayout, suffix, time.Local)
+	return at, err == nil
+}
@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"backup-restore-apigee/internal/fakestorage"
+	"backup-restore-apigee/internal/retention"
+	"backup-restore-apigee/internal/storage"
+)
+
+// seedSnapshots grava snapshots de apps nas datas informadas, alternando
+// diretorio com ID atual e arquivo compactado com o nome antigo.
+func seedSnapshots(t *testing.T, backupDir string, dates ...string) {
+	t.Helper()
+	ctx := context.Background()
+	backend, base, err := storage.Open(ctx, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, date := range dates {
+		at, err := time.ParseInLocation("2006-01-02 15:04", date, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 1 {
+			backend.Put(ctx, base+"_"+at.Format(legacyLayout)+".tar.gz", []byte("arquivo"))
+			continue
+		}
+		id, err := newID(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := base + "_" + id
+		backend.Put(ctx, storage.Join(name, "mobile.yaml"), []byte("name: mobile\n"))
+		backend.Put(ctx, storage.Join(name, ManifestFile), []byte("{}"))
+	}
+}
+
+func testPrune(t *testing.T, backupDir string) {
+	ctx := context.Background()
+	seedSnapshots(t, backupDir,
+		"2024-03-04 02:00",
+		"2024-03-03 14:00",
+		"2024-03-03 02:00",
+		"2024-02-10 02:00",
+		"2024-01-15 02:00",
+	)
+
+	snaps, err := List(ctx, backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 5 {
+		t.Fatalf("List = %d snapshots, esperado 5: %v", len(snaps), snaps)
+	}
+	if !snaps[0].Time.After(snaps[1].Time) {
+		t.Errorf("List fora de ordem: %v", snaps)
+	}
+
+	// O mais antigo fica por estar fixado, mesmo fora da politica.
+	if err := Pin(ctx, snaps[4].Path+"/", true); err != nil {
+		t.Fatal(err)
+	}
+	snaps, _ = List(ctx, backupDir)
+
+	decisions := PlanPrune(snaps, retention.Policy{Daily: 2})
+	var removed []string
+	for _, d := range decisions {
+		if !d.Keep {
+			removed = append(removed, d.Snapshot.Name)
+			if err := Delete(ctx, d.Snapshot); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(removed) != 2 {
+		t.Fatalf("removidos = %v", removed)
+	}
+
+	snaps, _ = List(ctx, backupDir)
+	if len(snaps) != 3 || !snaps[2].Pinned {
+		t.Fatalf("restaram %v", snaps)
+	}
+	if err := Delete(ctx, snaps[2]); err == nil {
+		t.Error("Delete apagou um snapshot fixado")
+	}
+}
+
+func TestPruneLocal(t *testing.T) {
+	dir := t.TempDir()
+	testPrune(t, filepath.Join(dir, "apps"))
+
+	// Outros backups no mesmo diretorio nao entram na conta.
+	seedSnapshots(t, filepath.Join(dir, "apps_v2"), "2024-03-04 02:00")
+	snaps, err := List(context.Background(), filepath.Join(dir, "apps"))
+	if err != nil || len(snaps) != 3 {
+		t.Errorf("List = %v, %v", snaps, err)
+	}
+}
+
+func TestPruneS3(t *testing.T) {
+	server := fakestorage.NewS3()
+	defer server.Close()
+	t.Setenv(storage.S3EndpointEnv, server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "minio")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minio123")
+
+	testPrune(t, "s3://backups/nightly/apps")
+}
+
+func TestPruneKeepsIncrementalBase(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 2, 0, 0, 0, time.UTC) }
+	snaps := []Info{
+		{Name: "apps_4", Time: day(4), Base: "apps_3"},
+		{Name: "apps_3", Time: day(3), Base: "apps_1"},
+		{Name: "apps_2", Time: day(2)},
+		{Name: "apps_1", Time: day(1)},
+	}
+
+	var kept []string
+	for _, d := range PlanPrune(snaps, retention.Policy{Daily: 1}) {
+		if d.Keep {
+			kept = append(kept, d.Snapshot.Name)
+		}
+	}
+	if len(kept) != 3 || kept[0] != "apps_4" || kept[1] != "apps_3" || kept[2] != "apps_1" {
+		t.Errorf("mantidos = %v", kept)
+	}
+}
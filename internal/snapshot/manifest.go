@@ -0,0 +1,90 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/secrets"
+)
+
+// ManifestFile e o nome do manifesto gravado na raiz de cada snapshot.
+const ManifestFile = "manifest.json"
+
+// Tipos de recurso usados nas contagens e nos arquivos do manifesto.
+const (
+	KindApp       = "apps"
+	KindDeveloper = "developers"
+)
+
+// Manifest descreve um snapshot: quem gerou, quando, o que contem e os erros
+// encontrados durante o backup.
+type Manifest struct {
+	// ID e a data e hora UTC do inicio do backup mais um sufixo aleatorio,
+	// ex: 20240201T100000Z-3fa2c1. Vazio nos snapshots antigos.
+	ID           string         `json:"id,omitempty"`
+	Organization string         `json:"organization"`
+	ToolVersion  string         `json:"toolVersion"`
+	StartedAt    time.Time      `json:"startedAt"`
+	FinishedAt   time.Time      `json:"finishedAt"`
+	Counts       map[string]int `json:"counts"`
+	Files        []File         `json:"files"`
+	Errors       []string       `json:"errors"`
+
+	// Status e StatusComplete so quando todos os recursos foram lidos sem
+	// erro; senao StatusPartial, e o snapshot pode ser retomado (Resume).
+	// Vazio nos snapshots antigos.
+	Status string `json:"status,omitempty"`
+
+	// Encryption e preenchido quando os consumer secrets foram cifrados.
+	Encryption *secrets.Envelope `json:"encryption,omitempty"`
+
+	// Redacted indica que os consumer secrets foram trocados pela impressao
+	// digital e nao podem ser restaurados.
+	Redacted bool `json:"redacted,omitempty"`
+
+	// Filter e o filtro do backup (--include-*/--exclude-*). Um snapshot com
+	// filtro tem so parte da organizacao.
+	Filter *filter.Filter `json:"filter,omitempty"`
+
+	// Format e o formato dos documentos (FormatYAML, FormatJSON ou
+	// FormatNDJSON). Vazio nos snapshots com apps em YAML e developers em
+	// JSON, o padrao.
+	Format string `json:"format,omitempty"`
+
+	// Dedup indica que os documentos ficam em ObjectsDir, pelo SHA-256, e o
+	// diretorio do snapshot so tem o manifesto.
+	Dedup bool `json:"dedup,omitempty"`
+
+	// Incremental e preenchido nos snapshots incrementais, que so tem o que
+	// mudou desde o snapshot base.
+	Incremental *Incremental `json:"incremental,omitempty"`
+}
+
+// File e uma entrada do manifesto. Path e relativo a raiz do snapshot.
+type File struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Modified e o lastModifiedAt do developer ou app, usado pelo backup
+	// incremental seguinte. Zero nos snapshots antigos.
+	Modified int64 `json:"lastModifiedAt,omitempty"`
+}
+
+// ReadManifest le o manifest.json de um snapshot (diretorio ou arquivo).
+func ReadManifest(ctx context.Context, path string) (*Manifest, error) {
+	r, err := Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return r.Manifest()
+}
+
+// Checksum devolve o SHA-256 em hexadecimal, no formato usado no manifesto.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
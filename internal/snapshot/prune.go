@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"time"
+
+	"backup-restore-apigee/internal/retention"
+)
+
+// PruneDecision diz se um snapshot fica ou sai e por que.
+type PruneDecision struct {
+	Snapshot Info
+	Keep     bool
+	Reasons  []string
+}
+
+// PlanPrune aplica a politica de retencao aos snapshots de List. Snapshots
+// fixados sempre ficam, assim como a base (e a base da base) de um snapshot
+// incremental que fica. Nada e apagado aqui.
+func PlanPrune(snaps []Info, policy retention.Policy) []PruneDecision {
+	times := make([]time.Time, len(snaps))
+	for i, snap := range snaps {
+		times[i] = snap.Time
+	}
+	reasons := retention.Apply(policy, times)
+
+	decisions := make([]PruneDecision, len(snaps))
+	for i, snap := range snaps {
+		if snap.Pinned {
+			reasons[i] = append([]string{"fixado"}, reasons[i]...)
+		}
+		decisions[i] = PruneDecision{
+			Snapshot: snap,
+			Keep:     len(reasons[i]) > 0,
+			Reasons:  reasons[i],
+		}
+	}
+
+	// List devolve do mais novo para o mais antigo e a base e sempre mais
+	// antiga: uma passada basta para manter a cadeia inteira.
+	byName := map[string]int{}
+	for i, snap := range snaps {
+		byName[snap.Name] = i
+	}
+	for _, decision := range decisions {
+		if !decision.Keep || decision.Snapshot.Base == "" {
+			continue
+		}
+		if i, ok := byName[decision.Snapshot.Base]; ok {
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, "base de "+decision.Snapshot.Name)
+		}
+	}
+	return decisions
+}
@@ -0,0 +1,240 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/storage"
+)
+
+// Reader le um snapshot gravado em diretorio ou em arquivo compactado.
+type Reader struct {
+	path   string
+	source source
+
+	kms    secrets.KMS
+	sealer *secrets.Sealer
+
+	// base e a visao completa da base de um snapshot incremental: os
+	// documentos que nao estao neste (own) vem dela, menos os apagados.
+	base    *Reader
+	own     map[string]bool
+	deleted map[string]bool
+}
+
+type source interface {
+	list() ([]string, error)
+	read(name string) ([]byte, error)
+}
+
+// Open abre o snapshot em path, que pode ser um diretorio ou um .tar.gz /
+// .tar.zst, no disco local ou em uma URL gs:// ou s3://. O arquivo compactado
+// e lido direto, sem extrair no disco. Um snapshot incremental e aberto com
+// a sua base: o Reader mostra a visao completa.
+func Open(ctx context.Context, path string) (*Reader, error) {
+	backend, key, err := storage.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenIn(ctx, backend, key)
+}
+
+// OpenIn e o Open em um backend ja aberto, como um storage.Memory.
+func OpenIn(ctx context.Context, backend storage.Backend, key string) (*Reader, error) {
+	r := &Reader{path: backend.URL(key)}
+	if IsArchive(key) {
+		files, err := readArchive(ctx, backend, key)
+		if err != nil {
+			return nil, err
+		}
+		r.source = files
+	} else {
+		source, err := newObjectSource(ctx, backend, key)
+		if err != nil {
+			return nil, err
+		}
+		r.source = source
+		if manifest, err := r.Manifest(); err == nil && manifest.Dedup {
+			r.source = newDedupSource(ctx, backend, key, manifest)
+		}
+	}
+	if manifest, err := r.Manifest(); err == nil && manifest.Format == FormatNDJSON {
+		source, err := newStreamSource(r.source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", r.path, err)
+		}
+		r.source = source
+	}
+
+	if err := r.openBase(ctx, backend, key); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// OpenFile abre o snapshot que contem o documento em location (ex:
+// backups_<ID>/apps/<email>/<app>.yaml ou, no layout antigo,
+// backups_<ID>/<app>.yaml) e devolve o caminho do documento dentro dele. A
+// raiz do snapshot e o primeiro diretorio acima com manifesto; sem manifesto,
+// vale o diretorio do arquivo.
+func OpenFile(ctx context.Context, location string) (*Reader, string, error) {
+	backend, key, err := storage.Open(ctx, location)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, name := storage.Split(location)
+	root, rel := dir, name
+	for level, parts := 0, strings.Split(key, "/"); level < 3 && len(parts)-2-level >= 0; level++ {
+		candidate := strings.Join(parts[:len(parts)-1-level], "/")
+		if _, err := backend.Get(ctx, storage.Join(candidate, ManifestFile)); err == nil {
+			root = backend.URL(candidate)
+			rel = strings.Join(parts[len(parts)-1-level:], "/")
+			break
+		}
+	}
+
+	r, err := Open(ctx, root)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, rel, nil
+}
+
+// Path devolve o diretorio, arquivo ou URL aberto.
+func (r *Reader) Path() string {
+	return r.path
+}
+
+// Files lista os documentos do snapshot em ordem, sem o manifesto e o
+// checkpoint. Num snapshot incremental, sao os documentos da visao completa.
+func (r *Reader) Files() ([]string, error) {
+	if r.base == nil {
+		return r.ownFiles()
+	}
+
+	kinds, err := r.kinds()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(kinds))
+	for name := range kinds {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ownFiles lista os documentos gravados neste snapshot, sem os da base.
+func (r *Reader) ownFiles() ([]string, error) {
+	names, err := r.source.list()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		if name != ManifestFile && name != CheckpointFile {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// FilesOfKind lista os documentos de um tipo (KindApp, KindDeveloper).
+func (r *Reader) FilesOfKind(kind string) ([]string, error) {
+	kinds, err := r.kinds()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for name, k := range kinds {
+		if k == kind {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ReadFile devolve o conteudo de name, relativo a raiz do snapshot.
+func (r *Reader) ReadFile(name string) ([]byte, error) {
+	name = filepath.ToSlash(name)
+	return r.layerOf(name).source.read(name)
+}
+
+// Manifest le o manifest.json do snapshot.
+func (r *Reader) Manifest() (*Manifest, error) {
+	data, err := r.source.read(ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler o manifesto: %v", err)
+	}
+
+	var manifest Manifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao fazer a desserializacao do manifesto: %v", err)
+	}
+
+	return &manifest, nil
+}
+
+// objectSource le os arquivos de um snapshot gravado como objetos sob prefix.
+type objectSource struct {
+	ctx     context.Context
+	backend storage.Backend
+	prefix  string
+	names   []string
+}
+
+func newObjectSource(ctx context.Context, backend storage.Backend, prefix string) (*objectSource, error) {
+	keys, err := backend.List(ctx, prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s nao e um diretorio nem um arquivo de snapshot: %w", backend.URL(prefix), os.ErrNotExist)
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = strings.TrimPrefix(key, prefix+"/")
+	}
+
+	return &objectSource{ctx: ctx, backend: backend, prefix: prefix, names: names}, nil
+}
+
+func (o *objectSource) list() ([]string, error) {
+	return o.names, nil
+}
+
+func (o *objectSource) read(name string) ([]byte, error) {
+	return o.backend.Get(o.ctx, storage.Join(o.prefix, name))
+}
+
+// memorySource guarda os arquivos de um snapshot compactado ja descompactados.
+type memorySource map[string][]byte
+
+func (m memorySource) list() ([]string, error) {
+	files := make([]string, 0, len(m))
+	for name := range m {
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+func (m memorySource) read(name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return data, nil
+}
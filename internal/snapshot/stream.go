@@ -0,0 +1,353 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// streamKinds sao os tipos gravados em StreamPath(kind) no formato NDJSON.
+var streamKinds = []string{KindDeveloper, KindApp}
+
+// streamKind devolve o tipo do documento name pelo caminho: no layout atual os
+// apps ficam em AppsDir e os developers na raiz.
+func streamKind(name string) string {
+	if strings.HasPrefix(name, AppsDir+"/") {
+		return KindApp
+	}
+	return KindDeveloper
+}
+
+// streamSink grava os documentos de cada tipo em StreamPath(kind), um por
+// linha, na ordem em que chegam. As linhas ficam em memoria so ate o proximo
+// checkpoint (flush), quando sao acrescentadas ao fim do arquivo; o checkpoint
+// guarda quantos bytes de cada arquivo ele cobre (offsets). O manifesto lista
+// cada documento (linha) com o caminho que ele teria no formato json.
+//
+// Os arquivos compactados nao tem append: as linhas ficam em memoria e vao
+// para o tar no manifesto.
+type streamSink struct {
+	sink
+	pending map[string]*bytes.Buffer
+	offsets map[string]int64
+	// written sao os caminhos que ja tem linha no arquivo. Um documento
+	// gravado de novo, como no backup retomado, deixa a linha anterior para
+	// tras (stale) e o manifesto compacta o arquivo.
+	written map[string]bool
+	stale   bool
+}
+
+// appendingSink e o sink que acrescenta ao fim de um arquivo (objectSink).
+type appendingSink interface {
+	sink
+	appendFile(name string, data []byte) error
+	truncateFile(name string, size int64) error
+	readFile(name string) ([]byte, error)
+}
+
+func newStreamSink(inner sink) *streamSink {
+	s := &streamSink{sink: inner, pending: map[string]*bytes.Buffer{}, written: map[string]bool{}}
+	for _, kind := range streamKinds {
+		s.pending[kind] = &bytes.Buffer{}
+	}
+	if _, ok := inner.(appendingSink); ok {
+		s.offsets = map[string]int64{}
+		for _, kind := range streamKinds {
+			s.offsets[kind] = 0
+		}
+	}
+	return s
+}
+
+func (s *streamSink) writeFile(name string, data []byte) error {
+	if name == ManifestFile {
+		if err := s.finish(); err != nil {
+			return err
+		}
+		return s.sink.writeFile(name, data)
+	}
+	if name == CheckpointFile {
+		return s.sink.writeFile(name, data)
+	}
+
+	if bytes.ContainsAny(data, "\r\n") {
+		return fmt.Errorf("%s: documento com quebra de linha nao cabe em %s", name, StreamPath(streamKind(name)))
+	}
+	if s.written[name] {
+		s.stale = true
+	}
+	s.written[name] = true
+	buf := s.pending[streamKind(name)]
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// flush acrescenta as linhas pendentes ao fim dos arquivos e devolve o
+// tamanho de cada um, para o checkpoint. Sem append (arquivo compactado) nao
+// faz nada: as linhas vao inteiras no manifesto.
+func (s *streamSink) flush() (map[string]int64, error) {
+	inner, ok := s.sink.(appendingSink)
+	if !ok {
+		return nil, nil
+	}
+	for _, kind := range streamKinds {
+		buf := s.pending[kind]
+		if buf.Len() == 0 {
+			continue
+		}
+		if err := inner.appendFile(StreamPath(kind), buf.Bytes()); err != nil {
+			return nil, err
+		}
+		s.offsets[kind] += int64(buf.Len())
+		buf.Reset()
+	}
+
+	offsets := make(map[string]int64, len(s.offsets))
+	for kind, offset := range s.offsets {
+		offsets[kind] = offset
+	}
+	return offsets, nil
+}
+
+// finish grava o que falta antes do manifesto e, se algum documento foi
+// gravado de novo, regrava o arquivo so com a ultima linha de cada caminho.
+func (s *streamSink) finish() error {
+	inner, ok := s.sink.(appendingSink)
+	if !ok {
+		for _, kind := range streamKinds {
+			if buf := s.pending[kind]; buf.Len() > 0 {
+				if err := s.sink.writeFile(StreamPath(kind), buf.Bytes()); err != nil {
+					return err
+				}
+				buf.Reset()
+			}
+		}
+		return nil
+	}
+
+	if _, err := s.flush(); err != nil {
+		return err
+	}
+	if !s.stale {
+		return nil
+	}
+	for _, kind := range streamKinds {
+		if s.offsets[kind] == 0 {
+			continue
+		}
+		data, err := inner.readFile(StreamPath(kind))
+		if err != nil {
+			return err
+		}
+		compacted, err := compactStream(kind, data)
+		if err != nil {
+			return err
+		}
+		if err := s.sink.writeFile(StreamPath(kind), compacted); err != nil {
+			return err
+		}
+		s.offsets[kind] = int64(len(compacted))
+	}
+	s.stale = false
+	return nil
+}
+
+// resume prepara o sink do backup retomado: corta cada arquivo no tamanho do
+// checkpoint (as linhas depois dele sao de developers que nao terminaram) e
+// confere que todo documento do manifesto do checkpoint esta no arquivo com o
+// mesmo checksum. Se nao estiver, o developer seria pulado sem a sua linha, e
+// o Resume falha. Checkpoints sem offsets nao cortam os arquivos.
+func (s *streamSink) resume(offsets map[string]int64, files []File) error {
+	inner, ok := s.sink.(appendingSink)
+	if !ok {
+		return fmt.Errorf("o formato %s so pode ser retomado em diretorio", FormatNDJSON)
+	}
+
+	sums := map[string]string{}
+	for _, kind := range streamKinds {
+		stream := StreamPath(kind)
+		if offset, ok := offsets[kind]; ok {
+			err := inner.truncateFile(stream, offset)
+			if errors.Is(err, os.ErrNotExist) && offset == 0 {
+				err = nil
+			}
+			if err != nil {
+				return fmt.Errorf("erro ao cortar %s no checkpoint: %v", stream, err)
+			}
+		}
+
+		data, err := inner.readFile(stream)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = eachLine(kind, data, func(name string, line []byte) {
+			sums[name] = Checksum(line)
+			s.written[name] = true
+		})
+		if err != nil {
+			return err
+		}
+		s.offsets[kind] = int64(len(data))
+	}
+
+	for _, f := range files {
+		if sums[f.Path] != f.SHA256 {
+			return fmt.Errorf("%s: o checkpoint lista o documento mas a linha em %s falta ou nao confere; o snapshot nao pode ser retomado", f.Path, StreamPath(streamKind(f.Path)))
+		}
+	}
+	return nil
+}
+
+// compactStream deixa so a ultima linha de cada caminho, na posicao dela.
+func compactStream(kind string, data []byte) ([]byte, error) {
+	type line struct {
+		name string
+		data []byte
+	}
+	var lines []line
+	last := map[string]int{}
+	err := eachLine(kind, data, func(name string, data []byte) {
+		last[name] = len(lines)
+		lines = append(lines, line{name, data})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	for i, l := range lines {
+		if last[l.name] == i {
+			b.Write(l.data)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// streamSource mostra cada linha dos arquivos NDJSON como um documento, com o
+// caminho que ele teria no formato json. O manifesto e o checkpoint vem de
+// source.
+type streamSource struct {
+	source
+	docs map[string][]byte
+}
+
+func newStreamSource(src source) (*streamSource, error) {
+	docs, err := readStreams(src)
+	if err != nil {
+		return nil, err
+	}
+	return &streamSource{source: src, docs: docs}, nil
+}
+
+func (s *streamSource) list() ([]string, error) {
+	names, err := s.source.list()
+	if err != nil {
+		return nil, err
+	}
+
+	streams := map[string]bool{}
+	for _, kind := range streamKinds {
+		streams[StreamPath(kind)] = true
+	}
+	var out []string
+	for _, name := range names {
+		if !streams[name] {
+			out = append(out, name)
+		}
+	}
+	for name := range s.docs {
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+func (s *streamSource) read(name string) ([]byte, error) {
+	if data, ok := s.docs[name]; ok {
+		return data, nil
+	}
+	if name == ManifestFile || name == CheckpointFile {
+		return s.source.read(name)
+	}
+	return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+}
+
+// readStreams le os arquivos NDJSON de src e devolve cada linha pelo caminho
+// do documento. Uma linha repetida vale a ultima.
+func readStreams(src source) (map[string][]byte, error) {
+	names, err := src.list()
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, name := range names {
+		present[name] = true
+	}
+
+	docs := map[string][]byte{}
+	for _, kind := range streamKinds {
+		stream := StreamPath(kind)
+		if !present[stream] {
+			continue
+		}
+		data, err := src.read(stream)
+		if err != nil {
+			return nil, err
+		}
+		err = eachLine(kind, data, func(name string, line []byte) {
+			docs[name] = line
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// eachLine chama fn com o caminho e o conteudo de cada linha do arquivo do
+// tipo kind.
+func eachLine(kind string, data []byte, fn func(name string, line []byte)) error {
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		name, err := streamDocumentPath(kind, line)
+		if err != nil {
+			return fmt.Errorf("%s, linha %d: %v", StreamPath(kind), i+1, err)
+		}
+		fn(name, line)
+	}
+	return nil
+}
+
+// streamDocumentPath devolve o caminho de uma linha do arquivo do tipo kind,
+// pelo email do developer e pelo nome do app.
+func streamDocumentPath(kind string, line []byte) (string, error) {
+	var doc struct {
+		Email       string `json:"email"`
+		Name        string `json:"name"`
+		DeveloperID string `json:"developerId"`
+	}
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return "", fmt.Errorf("JSON invalido: %v", err)
+	}
+
+	ext := documentExt(FormatNDJSON, kind)
+	if kind == KindApp {
+		if doc.Name == "" || doc.DeveloperID == "" {
+			return "", fmt.Errorf("app sem name ou developerId")
+		}
+		return appPath(doc.DeveloperID, doc.Name, ext), nil
+	}
+	if doc.Email == "" {
+		return "", fmt.Errorf("developer sem email")
+	}
+	return EncodeName(doc.Email) + ext, nil
+}
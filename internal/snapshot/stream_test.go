@@ -0,0 +1,143 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+)
+
+func developer(email, name string) model.DeveloperBackup {
+	return model.DeveloperBackup{Email: email, FirstName: name, LastName: "Silva", UserName: name}
+}
+
+func readCheckpoint(t *testing.T, dir string) Checkpoint {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+func TestStreamAppendsAndResumes(t *testing.T) {
+	ctx := context.Background()
+	w, err := Create(ctx, filepath.Join(t.TempDir(), "developers"), "my-org", Options{Format: FormatNDJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := filepath.Join(w.Path(), StreamPath(KindDeveloper))
+
+	if err := w.WriteDeveloper(developer("alice@example.com", "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Advance(KindDeveloper, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := readCheckpoint(t, w.Path()).Streams[KindDeveloper]; n != int64(len(first)) || bytes.Count(first, []byte("\n")) != 1 {
+		t.Fatalf("checkpoint cobre %d bytes, arquivo com %d: %s", n, len(first), first)
+	}
+
+	// O checkpoint seguinte so acrescenta a linha do bob.
+	if err := w.WriteDeveloper(developer("bob@example.com", "bob")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Advance(KindDeveloper, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(second, first) || bytes.Count(second, []byte("\n")) != 2 {
+		t.Fatalf("arquivo depois do segundo checkpoint:\n%s", second)
+	}
+
+	// O processo morre no meio do append da carol, depois do checkpoint.
+	file, err := os.OpenFile(stream, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"email":"carol@exa`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	resumed, err := Resume(ctx, w.Path(), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(stream); !bytes.Equal(data, second) {
+		t.Fatalf("o Resume nao cortou o arquivo no checkpoint:\n%s", data)
+	}
+
+	// O bob gravado de novo substitui a linha anterior no Close.
+	if err := resumed.WriteDeveloper(developer("bob@example.com", "roberto")); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.WriteDeveloper(developer("carol@example.com", "carol")); err != nil {
+		t.Fatal(err)
+	}
+	resumed.Finish(KindDeveloper)
+	manifest, err := resumed.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Status != StatusComplete || len(manifest.Files) != 3 {
+		t.Fatalf("status %s, arquivos %v", manifest.Status, manifest.Files)
+	}
+
+	data, err := os.ReadFile(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 || strings.Contains(string(data), `"firstName":"bob"`) {
+		t.Errorf("arquivo final:\n%s", data)
+	}
+	problems, err := Verify(ctx, w.Path(), VerifyOptions{})
+	if err != nil || len(problems) > 0 {
+		t.Errorf("verify: %v %v", err, problems)
+	}
+}
+
+func TestStreamResumeRejectsDamagedLine(t *testing.T) {
+	ctx := context.Background()
+	w, err := Create(ctx, filepath.Join(t.TempDir(), "developers"), "my-org", Options{Format: FormatNDJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteDeveloper(developer("alice@example.com", "alice")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Advance(KindDeveloper, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A linha que o checkpoint lista muda de conteudo (mesmo tamanho): o
+	// developer seria pulado e ficaria sem a linha certa.
+	stream := filepath.Join(w.Path(), StreamPath(KindDeveloper))
+	data, err := os.ReadFile(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stream, bytes.Replace(data, []byte(`"alice"`), []byte(`"alicx"`), 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Resume(ctx, w.Path(), "my-org", Options{}); err == nil || !strings.Contains(err.Error(), "nao pode ser retomado") {
+		t.Fatalf("Resume com a linha alterada: %v", err)
+	}
+}
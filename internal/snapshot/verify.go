@@ -0,0 +1,305 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"backup-restore-apigee/internal/model"
+)
+
+// Problem e uma inconsistencia encontrada pelo Verify. Path e vazio quando o
+// problema e do snapshot como um todo.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// VerifyOptions complementa o Verify. DeveloperDirs sao snapshots de
+// developers usados para validar o DeveloperID dos apps quando o snapshot
+// verificado contem so apps.
+type VerifyOptions struct {
+	DeveloperDirs []string
+}
+
+// Verify confere o snapshot em path (diretorio ou arquivo compactado):
+// checksums do manifesto, parse estrito de cada documento, campos obrigatorios
+// e referencias entre apps e developers. Num snapshot incremental a base
+// precisa estar acessivel. O erro so e devolvido quando nao e possivel fazer
+// a verificacao.
+func Verify(ctx context.Context, path string, opts VerifyOptions) ([]Problem, error) {
+	r, err := Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{
+		developers: map[string]model.DeveloperBackup{},
+	}
+
+	manifest, err := r.Manifest()
+	if err != nil {
+		v.add("", err.Error())
+		manifest = &Manifest{}
+	}
+	v.partialApps = manifest.Filter.SelectsApps()
+
+	// O manifesto e os checksums sao os deste snapshot; os documentos e as
+	// referencias, os da visao completa quando ele e incremental.
+	own, err := r.ownFiles()
+	if err != nil {
+		return nil, err
+	}
+	v.checkManifest(r, manifest, own)
+
+	files, err := r.Files()
+	if err != nil {
+		return nil, err
+	}
+	kinds, err := r.kinds()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range files {
+		data, err := r.ReadFile(name)
+		if err != nil {
+			v.add(name, err.Error())
+			continue
+		}
+		v.parse(name, kinds[name], data)
+	}
+
+	for _, extra := range opts.DeveloperDirs {
+		er, err := Open(ctx, extra)
+		if err != nil {
+			return nil, err
+		}
+		extraFiles, err := er.FilesOfKind(KindDeveloper)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range extraFiles {
+			// ReadDeveloper escolhe o decoder pelo formato do snapshot.
+			developer, err := er.ReadDeveloper(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", extra, name, err)
+			}
+			if developer.Email != "" {
+				v.developers[strings.ToLower(developer.Email)] = developer
+			}
+		}
+	}
+
+	v.checkReferences()
+
+	return v.problems, nil
+}
+
+type verifier struct {
+	problems   []Problem
+	apps       []appDoc
+	developers map[string]model.DeveloperBackup
+
+	// partialApps e ligado quando o backup dos apps teve filtro de app: os
+	// developers podem listar apps que ficaram de fora.
+	partialApps bool
+}
+
+type appDoc struct {
+	path string
+	app  model.AppBackup
+}
+
+func (v *verifier) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *verifier) checkManifest(r *Reader, manifest *Manifest, files []string) {
+	for _, msg := range manifest.Errors {
+		v.add(ManifestFile, "o backup registrou erro: %s", msg)
+	}
+
+	present := map[string]bool{}
+	for _, name := range files {
+		present[name] = true
+	}
+
+	listed := map[string]bool{}
+	counts := map[string]int{}
+	for _, f := range manifest.Files {
+		listed[f.Path] = true
+		counts[f.Kind]++
+
+		if !present[f.Path] {
+			v.add(f.Path, "arquivo listado no manifesto nao existe no snapshot")
+			continue
+		}
+		data, err := r.ReadFile(f.Path)
+		if err != nil {
+			v.add(f.Path, err.Error())
+			continue
+		}
+		if sum := Checksum(data); sum != f.SHA256 {
+			v.add(f.Path, "checksum nao confere: manifesto %s, arquivo %s", f.SHA256, sum)
+		}
+	}
+
+	for kind, count := range manifest.Counts {
+		if counts[kind] != count {
+			v.add(ManifestFile, "contagem de %s no manifesto e %d mas ha %d arquivos listados", kind, count, counts[kind])
+		}
+	}
+
+	if len(manifest.Files) == 0 {
+		return
+	}
+	for _, name := range files {
+		if !listed[name] {
+			v.add(name, "arquivo nao listado no manifesto")
+		}
+	}
+}
+
+func (v *verifier) parse(name, kind string, data []byte) {
+	switch kind {
+	case KindApp:
+		var app model.AppBackup
+		if err := decodeDocument(name, data, &app, true); err != nil {
+			v.add(name, "%v", err)
+			return
+		}
+		v.checkApp(name, app)
+		v.apps = append(v.apps, appDoc{path: name, app: app})
+
+	case KindDeveloper:
+		var developer model.DeveloperBackup
+		if err := decodeDocument(name, data, &developer, true); err != nil {
+			v.add(name, "%v", err)
+			return
+		}
+		v.checkDeveloper(name, developer)
+		if developer.Email != "" {
+			v.developers[strings.ToLower(developer.Email)] = developer
+		}
+
+	default:
+		v.add(name, "tipo de arquivo desconhecido")
+	}
+}
+
+func (v *verifier) checkApp(name string, app model.AppBackup) {
+	if app.Name == "" {
+		v.add(name, "app sem name")
+	}
+	if app.DeveloperID == "" {
+		v.add(name, "app sem developerId")
+	}
+	// No layout por developer o caminho tem que bater com o conteudo; no
+	// layout antigo (<app>.yaml na raiz) nao ha o que conferir.
+	if strings.HasPrefix(name, AppsDir+"/") && app.Name != "" && app.DeveloperID != "" {
+		if want := appPath(app.DeveloperID, app.Name, path.Ext(name)); name != want {
+			v.add(name, "app %s do developer %s deveria estar em %s", app.Name, app.DeveloperID, want)
+		}
+	}
+	if len(app.Credentials) == 0 {
+		v.add(name, "app sem credentials")
+	}
+	for i, cred := range app.Credentials {
+		if cred.ConsumerKey == "" {
+			v.add(name, "credentials[%d] sem consumerKey", i)
+		}
+		if cred.ConsumerSecret == "" {
+			v.add(name, "credentials[%d] sem consumerSecret", i)
+		}
+		if len(cred.APIProducts) == 0 {
+			v.add(name, "credentials[%d] (%s) sem apiProducts", i, cred.ConsumerKey)
+		}
+		for j, product := range cred.APIProducts {
+			if product.APIProduct == "" {
+				v.add(name, "credentials[%d].apiProducts[%d] sem apiproduct", i, j)
+			}
+		}
+	}
+}
+
+func (v *verifier) checkDeveloper(name string, developer model.DeveloperBackup) {
+	required := map[string]string{
+		"email":     developer.Email,
+		"firstName": developer.FirstName,
+		"lastName":  developer.LastName,
+		"userName":  developer.UserName,
+	}
+	for _, field := range []string{"email", "firstName", "lastName", "userName"} {
+		if required[field] == "" {
+			v.add(name, "developer sem %s", field)
+		}
+	}
+}
+
+// checkReferences so roda quando ha developers conhecidos, seja no proprio
+// snapshot ou em VerifyOptions.DeveloperDirs.
+func (v *verifier) checkReferences() {
+	if len(v.developers) == 0 {
+		return
+	}
+
+	appsByDeveloper := map[string]map[string]bool{}
+	for _, doc := range v.apps {
+		email := strings.ToLower(doc.app.DeveloperID)
+		if _, ok := v.developers[email]; !ok && email != "" {
+			v.add(doc.path, "developerId %s nao tem arquivo de developer", doc.app.DeveloperID)
+		}
+		if appsByDeveloper[email] == nil {
+			appsByDeveloper[email] = map[string]bool{}
+		}
+		appsByDeveloper[email][doc.app.Name] = true
+	}
+
+	if len(v.apps) == 0 || v.partialApps {
+		return
+	}
+
+	emails := make([]string, 0, len(v.developers))
+	for email := range v.developers {
+		emails = append(emails, email)
+	}
+	sort.Strings(emails)
+	for _, email := range emails {
+		for _, app := range v.developers[email].Apps {
+			if !appsByDeveloper[email][app] {
+				v.add("", "developer %s lista o app %s que nao esta no snapshot", v.developers[email].Email, app)
+			}
+		}
+	}
+}
+
+// kindOf usa o tipo registrado no manifesto e, na falta dele, a extensao.
+func kindOf(manifest *Manifest, name string) string {
+	if manifest != nil {
+		for _, f := range manifest.Files {
+			if f.Path == name {
+				return f.Kind
+			}
+		}
+	}
+	if strings.HasPrefix(name, AppsDir+"/") {
+		return KindApp
+	}
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml":
+		return KindApp
+	case ".json":
+		return KindDeveloper
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+)
+
+const validApp = `appId: app-1
+attributes:
+- name: tier
+  value: gold
+createdAt: 1
+credentials:
+- apiProducts:
+  - apiproduct: payments
+    status: approved
+  consumerKey: key-1
+  consumerSecret: secret-1
+  expiresAt: -1
+  issuedAt: 1
+  status: approved
+developerId: alice@example.com
+lastModifiedAt: 1
+name: mobile
+status: approved
+appFamily: default
+`
+
+const validDeveloper = `{
+  "email": "alice@example.com",
+  "firstName": "Alice",
+  "lastName": "Silva",
+  "userName": "alice",
+  "apps": ["mobile"],
+  "developerId": "dev-1",
+  "organizationName": "my-org",
+  "status": "active",
+  "createdAt": 1,
+  "lastModifiedAt": 1
+}`
+
+func writeSnapshot(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	w, err := Create(context.Background(), filepath.Join(t.TempDir(), "backup"), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		kind := KindApp
+		if filepath.Ext(name) == ".json" {
+			kind = KindDeveloper
+		}
+		if err := w.WriteFile(kind, name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Path()
+}
+
+func assertProblems(t *testing.T, problems []Problem, want ...string) {
+	t.Helper()
+
+	if len(problems) != len(want) {
+		t.Fatalf("esperado %d problema(s), obtido %v", len(want), problems)
+	}
+	for i, w := range want {
+		if !strings.Contains(problems[i].String(), w) {
+			t.Errorf("problema %d = %q, esperado conter %q", i, problems[i], w)
+		}
+	}
+}
+
+func TestVerifyValidSnapshot(t *testing.T) {
+	dir := writeSnapshot(t, map[string]string{
+		"mobile.yaml":            validApp,
+		"alice@example.com.json": validDeveloper,
+	})
+
+	problems, err := Verify(context.Background(), dir, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems)
+}
+
+func TestVerifyChecksumMismatch(t *testing.T) {
+	dir := writeSnapshot(t, map[string]string{"mobile.yaml": validApp})
+
+	tampered := strings.Replace(validApp, "gold", "silver", 1)
+	if err := os.WriteFile(filepath.Join(dir, "mobile.yaml"), []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Verify(context.Background(), dir, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems, "mobile.yaml: checksum nao confere")
+}
+
+func TestVerifyMissingAndExtraFiles(t *testing.T) {
+	dir := writeSnapshot(t, map[string]string{"mobile.yaml": validApp})
+
+	if err := os.Remove(filepath.Join(dir, "mobile.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "web.yaml"), []byte(validApp), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Verify(context.Background(), dir, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems,
+		"mobile.yaml: arquivo listado no manifesto nao existe",
+		"web.yaml: arquivo nao listado no manifesto",
+	)
+}
+
+func TestVerifyRequiredFieldsAndStrictParse(t *testing.T) {
+	noProducts := strings.Replace(validApp, `  - apiproduct: payments
+    status: approved
+`, "", 1)
+	noProducts = strings.Replace(noProducts, "- apiProducts:\n", "- apiProducts: []\n", 1)
+	unknownField := validApp + "unexpected: true\n"
+
+	dir := writeSnapshot(t, map[string]string{
+		"a.yaml": noProducts,
+		"b.yaml": unknownField,
+	})
+
+	problems, err := Verify(context.Background(), dir, VerifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems,
+		"a.yaml: credentials[0] (key-1) sem apiProducts",
+		"b.yaml: YAML invalido",
+	)
+}
+
+func TestVerifyCrossReferences(t *testing.T) {
+	orphan := strings.Replace(validApp, "alice@example.com", "bob@example.com", 1)
+	apps := writeSnapshot(t, map[string]string{"mobile.yaml": orphan})
+	developers := writeSnapshot(t, map[string]string{"alice@example.com.json": validDeveloper})
+
+	problems, err := Verify(context.Background(), apps, VerifyOptions{DeveloperDirs: []string{developers}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems,
+		"mobile.yaml: developerId bob@example.com nao tem arquivo de developer",
+		"developer alice@example.com lista o app mobile que nao esta no snapshot",
+	)
+}
+
+func TestVerifyCrossReferencesYAMLDevelopers(t *testing.T) {
+	orphan := strings.Replace(validApp, "alice@example.com", "bob@example.com", 1)
+	apps := writeSnapshot(t, map[string]string{"mobile.yaml": orphan})
+
+	w, err := Create(context.Background(), filepath.Join(t.TempDir(), "developers"), "my-org", Options{Format: FormatYAML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	developer := model.DeveloperBackup{Email: "alice@example.com", FirstName: "Alice", LastName: "Silva", UserName: "alice", Apps: []string{"mobile"}}
+	if err := w.WriteDeveloper(developer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := Verify(context.Background(), apps, VerifyOptions{DeveloperDirs: []string{w.Path()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertProblems(t, problems,
+		"mobile.yaml: developerId bob@example.com nao tem arquivo de developer",
+		"developer alice@example.com lista o app mobile que nao esta no snapshot",
+	)
+}
@@ -0,0 +1,445 @@
+// Package snapshot grava e le os backups (diretorio ou arquivo compactado) e
+// o manifesto que descreve cada um deles.
+package snapshot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"backup-restore-apigee/internal/filter"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/storage"
+	"backup-restore-apigee/internal/version"
+)
+
+// Options controla onde e como o snapshot e gravado.
+type Options struct {
+	// Archive grava o snapshot em um unico arquivo (FormatTarGz ou
+	// FormatTarZstd) em vez de um diretorio. Vazio grava em diretorio.
+	Archive string
+
+	// KMS, se informado, cifra os consumer secrets dos apps com uma chave
+	// propria do snapshot, envolvida por este KMS e guardada no manifesto.
+	KMS secrets.KMS
+
+	// RedactSecrets troca os consumer secrets pela impressao digital
+	// (secrets.Fingerprint). Nao pode ser usado junto com KMS.
+	RedactSecrets bool
+
+	// Filter seleciona os developers e apps do backup. Fica gravado no
+	// manifesto; nil faz o backup da organizacao inteira.
+	Filter *filter.Filter
+
+	// Incremental compara com o snapshot completo mais recente do mesmo
+	// backupDir no catalogo (ErrNoBase se nao houver) e grava so os
+	// developers e apps que mudaram, mais os apagados (Incremental).
+	Incremental bool
+
+	// Dedup grava cada documento uma vez so, pelo conteudo, em ObjectsDir ao
+	// lado dos snapshots; o snapshot fica so com o manifesto. Nao vale com
+	// Archive.
+	Dedup bool
+
+	// Format e o formato dos documentos: FormatYAML, FormatJSON ou
+	// FormatNDJSON (um arquivo por tipo). Vazio grava os apps em YAML e os
+	// developers em JSON.
+	Format string
+}
+
+// idLayout e a data e hora UTC do ID do snapshot, em ISO-8601 no formato
+// basico (sem ":", que nao vale em nome de arquivo). Ordena como texto.
+const idLayout = "20060102T150405Z"
+
+// legacyLayout e o sufixo dos snapshots gravados antes dos IDs em ISO-8601,
+// em hora local.
+const legacyLayout = "02-01-2006_15-04-05"
+
+// createAttempts e quantas vezes Create sorteia outro ID quando o snapshot ja
+// existe.
+const createAttempts = 5
+
+// Writer grava os arquivos de um snapshot e monta o manifesto conforme eles
+// sao escritos. Close grava o manifest.json.
+type Writer struct {
+	ctx     context.Context
+	path    string
+	backend storage.Backend
+	key     string
+	sink    sink
+	sealer  *secrets.Sealer
+	redact  bool
+	filter  *filter.Filter
+
+	// checkpoint e ligado nos snapshots em diretorio, que podem ser
+	// retomados; cursors e done sao o progresso (Checkpoint). finished tem
+	// os tipos com progresso registrado e se ja terminaram.
+	checkpoint bool
+	cursors    map[string]string
+	done       map[string]map[string]bool
+	finished   map[string]bool
+	unsaved    int
+
+	// base e a visao completa da base de um snapshot incremental; modified
+	// e baseDocs indexam os documentos dela e deleted sao os tombstones.
+	base     *Reader
+	modified map[string]int64
+	baseDocs map[string]map[string][]string
+	deleted  map[string]bool
+
+	mu       sync.Mutex
+	manifest Manifest
+}
+
+// sink e o destino dos arquivos do snapshot.
+type sink interface {
+	writeFile(name string, data []byte) error
+	close() error
+}
+
+// Create cria o snapshot backupDir_<ID> (diretorio ou arquivo compactado,
+// conforme opts), registra no catalogo como em andamento e devolve o Writer.
+// backupDir pode ser um caminho local ou uma URL gs:// ou s3://.
+func Create(ctx context.Context, backupDir, org string, opts Options) (*Writer, error) {
+	backend, key, err := storage.Open(ctx, backupDir)
+	if err != nil {
+		return nil, err
+	}
+	return CreateIn(ctx, backend, key, org, opts)
+}
+
+// CreateIn e o Create em um backend ja aberto, como um storage.Memory.
+func CreateIn(ctx context.Context, backend storage.Backend, key, org string, opts Options) (*Writer, error) {
+	if opts.KMS != nil && opts.RedactSecrets {
+		return nil, fmt.Errorf("escolha entre cifrar e remover os consumer secrets, nao os dois")
+	}
+	if opts.Dedup && opts.Archive != "" {
+		return nil, fmt.Errorf("snapshots deduplicados ficam em diretorio, sem --archive")
+	}
+	if err := CheckFormat(opts.Format); err != nil {
+		return nil, err
+	}
+	if opts.Dedup && opts.Format == FormatNDJSON {
+		return nil, fmt.Errorf("o formato %s junta os documentos em um arquivo por tipo e nao combina com a deduplicacao", FormatNDJSON)
+	}
+
+	var sealer *secrets.Sealer
+	if opts.KMS != nil {
+		var err error
+		sealer, err = secrets.NewSealer(opts.KMS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var base *Reader
+	var baseName string
+	if opts.Incremental {
+		var err error
+		base, baseName, err = findBase(ctx, backend, key, org, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	startedAt := time.Now().UTC()
+
+	// Dois backups no mesmo segundo ganham sufixos diferentes; se mesmo assim
+	// o nome ja existir, sorteia outro.
+	var id, name string
+	var s sink
+	var err error
+	for attempt := 0; attempt < createAttempts; attempt++ {
+		id, err = newID(startedAt)
+		if err != nil {
+			return nil, err
+		}
+		name = key + "_" + id
+		switch {
+		case opts.Dedup:
+			s, err = newDedupSink(ctx, backend, name)
+		case opts.Archive == "":
+			s, err = newObjectSink(ctx, backend, name)
+		default:
+			name += archiveExtension(opts.Archive)
+			s, err = newArchiveSink(ctx, backend, name, opts.Archive)
+		}
+		if !errors.Is(err, os.ErrExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Format == FormatNDJSON {
+		s = newStreamSink(s)
+	}
+
+	w := &Writer{
+		ctx:        ctx,
+		path:       backend.URL(name),
+		backend:    backend,
+		key:        name,
+		sink:       s,
+		sealer:     sealer,
+		redact:     opts.RedactSecrets,
+		filter:     opts.Filter,
+		checkpoint: opts.Archive == "",
+		cursors:    map[string]string{},
+		done:       map[string]map[string]bool{},
+		finished:   map[string]bool{},
+		manifest: Manifest{
+			ID:           id,
+			Organization: org,
+			ToolVersion:  version.Version,
+			StartedAt:    startedAt,
+			Counts:       map[string]int{},
+			Redacted:     opts.RedactSecrets,
+			Dedup:        opts.Dedup,
+			Format:       opts.Format,
+		},
+	}
+	if !opts.Filter.Empty() {
+		w.manifest.Filter = opts.Filter
+	}
+	if sealer != nil {
+		envelope := sealer.Envelope()
+		w.manifest.Encryption = &envelope
+	}
+	if base != nil {
+		w.manifest.Incremental = &Incremental{Base: baseName}
+		if err := w.setBase(base); err != nil {
+			return nil, err
+		}
+	}
+
+	err = w.writeCatalog(StatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.saveCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// newID devolve o ID de um snapshot criado em t: data e hora UTC e um sufixo
+// aleatorio.
+func newID(t time.Time) (string, error) {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("erro ao sortear o sufixo do snapshot: %v", err)
+	}
+	return t.UTC().Format(idLayout) + "-" + hex.EncodeToString(suffix), nil
+}
+
+// Path devolve o diretorio, arquivo ou URL do snapshot.
+func (w *Writer) Path() string {
+	return w.path
+}
+
+// Key devolve a chave do snapshot no backend, para OpenIn.
+func (w *Writer) Key() string {
+	return w.key
+}
+
+// Filter devolve o filtro do backup (Options.Filter), ou nil.
+func (w *Writer) Filter() *filter.Filter {
+	return w.filter
+}
+
+// Expect registra o tipo kind no manifesto mesmo que nenhum arquivo dele seja
+// gravado, para o catalogo mostrar "0 apps" em vez de omitir o tipo.
+func (w *Writer) Expect(kind string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.manifest.Counts[kind] += 0
+}
+
+// WriteFile grava name (relativo ao snapshot) e registra o checksum e a
+// contagem do tipo kind no manifesto. Gravar de novo o mesmo name, como no
+// backup retomado, substitui a entrada.
+func (w *Writer) WriteFile(kind, name string, data []byte) error {
+	return w.writeDocument(kind, name, data, 0)
+}
+
+// writeDocument e o WriteFile guardando no manifesto o lastModifiedAt do
+// documento, para o backup incremental seguinte.
+func (w *Writer) writeDocument(kind, name string, data []byte, modified int64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	name = filepath.ToSlash(name)
+	err := w.sink.writeFile(name, data)
+	if err != nil {
+		return err
+	}
+
+	file := File{
+		Path:     name,
+		Kind:     kind,
+		Size:     int64(len(data)),
+		SHA256:   Checksum(data),
+		Modified: modified,
+	}
+	for i, f := range w.manifest.Files {
+		if f.Path == name {
+			w.manifest.Files[i] = file
+			return nil
+		}
+	}
+	w.manifest.Counts[kind]++
+	w.manifest.Files = append(w.manifest.Files, file)
+
+	return nil
+}
+
+// RecordError registra no manifesto um erro que nao interrompeu o backup.
+func (w *Writer) RecordError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.manifest.Errors = append(w.manifest.Errors, err.Error())
+}
+
+// Close grava o manifest.json, fecha o snapshot, atualiza o catalogo e
+// devolve o manifesto final.
+func (w *Writer) Close() (*Manifest, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.manifest.FinishedAt = time.Now().UTC()
+	w.manifest.Status = StatusComplete
+	for _, finished := range w.finished {
+		if !finished {
+			w.manifest.Status = StatusPartial
+		}
+	}
+	if len(w.manifest.Errors) > 0 {
+		w.manifest.Status = StatusPartial
+	}
+	if w.manifest.Incremental != nil {
+		sort.Strings(w.manifest.Incremental.Deleted)
+	}
+	sort.Slice(w.manifest.Files, func(i, j int) bool {
+		return w.manifest.Files[i].Path < w.manifest.Files[j].Path
+	})
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("erro ao converter o manifesto para JSON: %v", err)
+	}
+
+	err = w.sink.writeFile(ManifestFile, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Um snapshot parcial guarda o checkpoint para o Resume.
+	if w.manifest.Status == StatusPartial {
+		err = w.saveCheckpoint()
+	} else if w.checkpoint {
+		err = w.backend.Delete(w.ctx, storage.Join(w.key, CheckpointFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.sink.close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.writeCatalog(w.manifest.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := w.manifest
+	return &manifest, nil
+}
+
+// objectSink grava cada arquivo do snapshot como um objeto sob prefix: um
+// diretorio no disco local ou um prefixo no bucket.
+type objectSink struct {
+	ctx     context.Context
+	backend storage.Backend
+	prefix  string
+}
+
+func newObjectSink(ctx context.Context, backend storage.Backend, prefix string) (*objectSink, error) {
+	if dm, ok := backend.(storage.DirMaker); ok {
+		if err := dm.MakeDir(prefix); err != nil {
+			return nil, err
+		}
+	} else if err := mustNotExist(ctx, backend, prefix+"/"); err != nil {
+		return nil, err
+	}
+
+	return &objectSink{ctx: ctx, backend: backend, prefix: prefix}, nil
+}
+
+func (o *objectSink) writeFile(name string, data []byte) error {
+	return o.backend.Put(o.ctx, storage.Join(o.prefix, name), data)
+}
+
+func (o *objectSink) close() error {
+	return nil
+}
+
+// appendFile acrescenta data ao fim de name. Nos backends sem
+// storage.Appender (os buckets) o objeto e lido e regravado inteiro.
+func (o *objectSink) appendFile(name string, data []byte) error {
+	key := storage.Join(o.prefix, name)
+	if a, ok := o.backend.(storage.Appender); ok {
+		return a.Append(o.ctx, key, data)
+	}
+	old, err := o.backend.Get(o.ctx, key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return o.backend.Put(o.ctx, key, append(old, data...))
+}
+
+// truncateFile corta name em size bytes.
+func (o *objectSink) truncateFile(name string, size int64) error {
+	key := storage.Join(o.prefix, name)
+	if a, ok := o.backend.(storage.Appender); ok {
+		return a.Truncate(o.ctx, key, size)
+	}
+	data, err := o.backend.Get(o.ctx, key)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) < size {
+		return fmt.Errorf("%s tem %d bytes, menos que os %d do checkpoint", o.backend.URL(key), len(data), size)
+	}
+	return o.backend.Put(o.ctx, key, data[:size])
+}
+
+func (o *objectSink) readFile(name string) ([]byte, error) {
+	return o.backend.Get(o.ctx, storage.Join(o.prefix, name))
+}
+
+// mustNotExist falha se ja houver algum objeto com o prefixo. Os buckets nao
+// tem o os.Mkdir exclusivo do disco local.
+func mustNotExist(ctx context.Context, backend storage.Backend, prefix string) error {
+	keys, err := backend.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("%s: %w", backend.URL(prefix), os.ErrExist)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"backup-restore-apigee/internal/model"
+)
+
+func TestWriterRecordsManifest(t *testing.T) {
+	w, err := Create(context.Background(), filepath.Join(t.TempDir(), "backup"), "my-org", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"mobile.yaml":     "name: mobile\n",
+		"web.yaml":        "name: web\n",
+		"alice@acme.json": "{}",
+	}
+	for name, content := range files {
+		kind := KindApp
+		if filepath.Ext(name) == ".json" {
+			kind = KindDeveloper
+		}
+		if err := w.WriteFile(kind, name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.RecordError(errors.New("falha no developer x"))
+
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadManifest(context.Background(), w.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if manifest.Organization != "my-org" {
+		t.Errorf("organization = %q", manifest.Organization)
+	}
+	if manifest.Counts[KindApp] != 2 || manifest.Counts[KindDeveloper] != 1 {
+		t.Errorf("counts = %v", manifest.Counts)
+	}
+	if len(manifest.Errors) != 1 {
+		t.Errorf("errors = %v", manifest.Errors)
+	}
+	if manifest.FinishedAt.Before(manifest.StartedAt) {
+		t.Errorf("finishedAt %v antes de startedAt %v", manifest.FinishedAt, manifest.StartedAt)
+	}
+	if len(manifest.Files) != len(files) {
+		t.Fatalf("files = %v", manifest.Files)
+	}
+	for _, f := range manifest.Files {
+		data, err := os.ReadFile(filepath.Join(w.Path(), f.Path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Checksum(data) != f.SHA256 || int64(len(data)) != f.Size {
+			t.Errorf("checksum/tamanho de %s nao confere", f.Path)
+		}
+	}
+}
+
+func TestEncodeIsCanonical(t *testing.T) {
+	app := model.AppBackup{
+		Name:        "mobile",
+		DeveloperID: "alice@acme.com",
+		Attributes:  []model.Attribute{{Name: "tier", Value: "gold"}, {Name: "DisplayName", Value: "Mobile"}},
+		Credentials: []model.Credential{
+			{ConsumerKey: "key-2", APIProducts: []model.APIProductRef{{APIProduct: "payments"}, {APIProduct: "catalog"}}},
+			{ConsumerKey: "key-1", APIProducts: []model.APIProductRef{{APIProduct: "catalog"}}},
+		},
+	}
+	shuffled := app
+	shuffled.Attributes = []model.Attribute{app.Attributes[1], app.Attributes[0]}
+	shuffled.Credentials = []model.Credential{app.Credentials[1], app.Credentials[0]}
+	shuffled.Credentials[1].APIProducts = []model.APIProductRef{{APIProduct: "catalog"}, {APIProduct: "payments"}}
+
+	a, err := EncodeApp(app, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := EncodeApp(shuffled, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("a ordem da API mudou o documento:\n%s\n%s", a, b)
+	}
+	if app.Credentials[0].ConsumerKey != "key-2" || app.Credentials[0].APIProducts[0].APIProduct != "payments" {
+		t.Error("EncodeApp alterou as listas do app recebido")
+	}
+
+	developer := model.DeveloperBackup{Email: "alice@acme.com", Apps: []string{"web", "mobile"}}
+	a, err = EncodeDeveloper(developer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	developer.Apps = []string{"mobile", "web"}
+	if b, err = EncodeDeveloper(developer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) {
+		t.Errorf("a ordem dos apps mudou o documento:\n%s\n%s", a, b)
+	}
+
+	// Sem apps, a lista sai vazia e nao nula.
+	developer.Apps = nil
+	if a, err = EncodeDeveloper(developer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(a), `"apps": []`) {
+		t.Errorf("developer sem apps:\n%s", a)
+	}
+}
+
+func TestCreateRejectsFormat(t *testing.T) {
+	for _, opts := range []Options{{Format: "xml"}, {Format: FormatNDJSON, Dedup: true}} {
+		if _, err := Create(context.Background(), filepath.Join(t.TempDir(), "backup"), "my-org", opts); err == nil {
+			t.Errorf("Create aceitou %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	gcs "google.golang.org/api/storage/v1"
+)
+
+// EmulatorEnv aponta o backend GCS para um emulador (ex: fake-gcs-server),
+// sem autenticacao. Mesma variavel usada pelas bibliotecas oficiais.
+const EmulatorEnv = "STORAGE_EMULATOR_HOST"
+
+// GCS grava os objetos em um bucket do Google Cloud Storage. As credenciais
+// vem do Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS).
+type GCS struct {
+	bucket  string
+	service *gcs.Service
+}
+
+func NewGCS(ctx context.Context, bucket string, opts ...option.ClientOption) (*GCS, error) {
+	if host := os.Getenv(EmulatorEnv); host != "" && len(opts) == 0 {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		opts = append(opts, option.WithEndpoint(strings.TrimSuffix(host, "/")+"/storage/v1/"), option.WithoutAuthentication())
+	}
+
+	service, err := gcs.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao criar o cliente do Cloud Storage: %v", err)
+	}
+
+	return &GCS{bucket: bucket, service: service}, nil
+}
+
+func (g *GCS) Put(ctx context.Context, key string, data []byte) error {
+	_, err := g.service.Objects.Insert(g.bucket, &gcs.Object{Name: key}).Media(bytes.NewReader(data)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("erro ao gravar %s: %v", g.URL(key), err)
+	}
+	return nil
+}
+
+func (g *GCS) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := g.NewReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func (g *GCS) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := g.service.Objects.List(g.bucket).Prefix(prefix).Pages(ctx, func(objects *gcs.Objects) error {
+		for _, object := range objects.Items {
+			keys = append(keys, object.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("erro ao listar %s: %v", g.URL(prefix), err)
+	}
+	return keys, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	err := g.service.Objects.Delete(g.bucket, key).Context(ctx).Do()
+	if err != nil {
+		return notExist(err, g.URL(key))
+	}
+	return nil
+}
+
+// NewWriter faz o upload em streaming: o que e escrito vai direto para o
+// bucket por um pipe, sem passar pelo disco.
+func (g *GCS) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := g.service.Objects.Insert(g.bucket, &gcs.Object{Name: key}).Media(pr).Context(ctx).Do()
+		pr.CloseWithError(err)
+		done <- err
+	}()
+
+	return &pipeWriter{pw: pw, done: done}, nil
+}
+
+func (g *GCS) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := g.service.Objects.Get(g.bucket, key).Context(ctx).Download()
+	if err != nil {
+		return nil, notExist(err, g.URL(key))
+	}
+	return resp.Body, nil
+}
+
+func (g *GCS) URL(key string) string {
+	return "gs://" + g.bucket + "/" + key
+}
+
+type pipeWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	return p.pw.Write(b)
+}
+
+func (p *pipeWriter) Close() error {
+	p.pw.Close()
+	return <-p.done
+}
+
+// notExist converte o 404 da API em os.ErrNotExist.
+func notExist(err error, url string) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", url, os.ErrNotExist)
+	}
+	return fmt.Errorf("%s: %v", url, err)
+}
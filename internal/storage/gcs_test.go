@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"backup-restore-apigee/internal/fakestorage"
+)
+
+func TestGCS(t *testing.T) {
+	server := fakestorage.NewGCS()
+	defer server.Close()
+
+	t.Setenv(EmulatorEnv, server.URL)
+	backend, err := NewGCS(context.Background(), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend, "backups")
+}
+
+// TestGCSEmulator roda contra um fake-gcs-server de verdade quando
+// STORAGE_EMULATOR_HOST e TEST_GCS_BUCKET estao definidos.
+func TestGCSEmulator(t *testing.T) {
+	bucket := os.Getenv("TEST_GCS_BUCKET")
+	if os.Getenv(EmulatorEnv) == "" || bucket == "" {
+		t.Skip("defina STORAGE_EMULATOR_HOST e TEST_GCS_BUCKET para rodar contra o emulador")
+	}
+
+	backend, err := NewGCS(context.Background(), bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBackend(t, backend, t.Name())
+}
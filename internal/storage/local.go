@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local grava no disco. Arquivos com permissao 0600: os apps tem consumer secrets.
+type Local struct{}
+
+func (Local) Put(ctx context.Context, key string, data []byte) error {
+	filename := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	return saveToFile(filename, data)
+}
+
+// Append acrescenta data ao fim do arquivo, criando se ele nao existir.
+func (Local) Append(ctx context.Context, key string, data []byte) error {
+	filename := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("erro ao abrir o arquivo: %v", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+	}
+	return file.Close()
+}
+
+// Truncate corta o arquivo em size bytes. Um arquivo menor que size e erro:
+// o os.Truncate completaria com zeros.
+func (Local) Truncate(ctx context.Context, key string, size int64) error {
+	filename := filepath.FromSlash(key)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if info.Size() < size {
+		return fmt.Errorf("%s tem %d bytes, menos que %d", filename, info.Size(), size)
+	}
+	return os.Truncate(filename, size)
+}
+
+func (Local) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.FromSlash(key))
+}
+
+func (Local) List(ctx context.Context, prefix string) ([]string, error) {
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(prefix)))
+	root := filepath.Dir(filepath.FromSlash(clean))
+	switch {
+	case clean == ".":
+		// Prefixo vazio, "." ou "./": tudo abaixo do diretorio atual.
+		clean = ""
+		root = "."
+	case strings.HasSuffix(prefix, "/"):
+		clean += "/"
+		root = filepath.FromSlash(strings.TrimSuffix(clean, "/"))
+	}
+
+	var keys []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(path)
+		if info.IsDir() {
+			// Nao desce em diretorios que nao podem ter chaves com o prefixo.
+			if path != root && !strings.HasPrefix(key+"/", clean) && !strings.HasPrefix(clean, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(key, clean) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (Local) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.FromSlash(key))
+}
+
+func (Local) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	filename := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+func (Local) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(key))
+}
+
+func (Local) URL(key string) string {
+	return filepath.FromSlash(key)
+}
+
+// MakeDir cria o diretorio (e os pais, como nos buckets) e falha se ele ja
+// existir.
+func (Local) MakeDir(key string) error {
+	dir := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	return os.Mkdir(dir, 0755)
+}
+
+// RemoveDir apaga os diretorios vazios de key, dos mais fundos para cima. Falha
+// se ainda houver algum arquivo.
+func (Local) RemoveDir(key string) error {
+	var dirs []string
+	err := filepath.Walk(filepath.FromSlash(key), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Remove(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveToFile grava num arquivo temporario no mesmo diretorio e troca pelo
+// destino com rename. Um processo morto no meio da gravacao deixa o arquivo
+// anterior inteiro (e o temporario para tras), nunca um arquivo truncado.
+func saveToFile(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("erro ao abrir o arquivo: %v", err)
+	}
+
+	// Escreve os dados no arquivo (CreateTemp ja cria com permissao 0600)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("erro ao escrever no arquivo: %v", err)
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory guarda os objetos em memoria. Usado quando o snapshot nao precisa
+// sair do processo, como no drift e no clone.
+type Memory struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: map[string][]byte{}}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", m.URL(key), os.ErrNotExist)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.objects[key]; !ok {
+		return fmt.Errorf("%s: %w", m.URL(key), os.ErrNotExist)
+	}
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	return &bufferedWriter{put: func(data []byte) error {
+		return m.Put(ctx, key, data)
+	}}, nil
+}
+
+func (m *Memory) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, err := m.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Memory) URL(key string) string {
+	return "mem://" + key
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Variaveis de ambiente lidas pelo backend S3. S3_ENDPOINT aponta para um
+// storage compativel (MinIO, Ceph, R2...); sem ela o destino e a AWS.
+const (
+	S3EndpointEnv = "S3_ENDPOINT"
+)
+
+// S3 fala o protocolo REST do S3 assinando as requisicoes com SigV4. Com
+// endpoint proprio usa path-style (endpoint/bucket/chave), como o MinIO espera.
+type S3 struct {
+	bucket   string
+	endpoint string
+	signer   sigV4
+	client   *http.Client
+}
+
+// S3Config configura o backend S3. Endpoint vazio usa a AWS na Region.
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	HTTPClient      *http.Client
+}
+
+// NewS3FromEnv le a configuracao das variaveis padrao da AWS
+// (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN, AWS_REGION)
+// e de S3_ENDPOINT.
+func NewS3FromEnv(bucket string) (*S3, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	return NewS3(bucket, S3Config{
+		Endpoint:        os.Getenv(S3EndpointEnv),
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	})
+}
+
+func NewS3(bucket string, cfg S3Config) (*S3, error) {
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, fmt.Errorf("credenciais do S3 nao informadas (AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY)")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://" + bucket + ".s3." + cfg.Region + ".amazonaws.com"
+	} else {
+		endpoint += "/" + bucket
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &S3{
+		bucket:   bucket,
+		endpoint: endpoint,
+		client:   client,
+		signer: sigV4{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			sessionToken:    cfg.SessionToken,
+			region:          cfg.Region,
+			service:         "s3",
+		},
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.NewReader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("erro ao ler a listagem de %s: %v", s.URL(prefix), err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// NewWriter acumula o objeto em memoria: o PUT simples do S3 precisa do
+// tamanho e do hash do corpo antes do envio.
+func (s *S3) NewWriter(ctx context.Context, key string) (io.WriteCloser, error) {
+	return &bufferedWriter{put: func(data []byte) error {
+		return s.Put(ctx, key, data)
+	}}, nil
+}
+
+func (s *S3) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) URL(key string) string {
+	return "s3://" + s.bucket + "/" + key
+}
+
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	target := s.endpoint + "/" + escapePath(key)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+
+	s.signer.sign(req, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", s.URL(key), err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", s.URL(key), os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("%s: %s %s", s.URL(key), resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+// escapePath codifica cada segmento da chave como o SigV4 espera, mantendo as "/".
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
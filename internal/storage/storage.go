@@ -0,0 +1,124 @@
+// Package storage abstrai onde os snapshots sao gravados: disco local,
+// Google Cloud Storage (gs://) ou qualquer storage compativel com S3 (s3://).
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Backend guarda objetos identificados por chaves separadas por "/". Para o
+// disco local a chave e o proprio caminho do arquivo.
+type Backend interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List devolve as chaves de todos os objetos que comecam com prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+	// NewWriter grava um objeto em streaming; ele so existe apos o Close.
+	NewWriter(ctx context.Context, key string) (io.WriteCloser, error)
+	NewReader(ctx context.Context, key string) (io.ReadCloser, error)
+	// URL devolve o endereco de key no mesmo formato aceito por Open.
+	URL(key string) string
+}
+
+// DirMaker e implementado pelos backends que tem diretorios de verdade. O
+// snapshot usa para criar o diretorio de forma exclusiva e para apagar os
+// diretorios que sobram depois de apagar os objetos.
+type DirMaker interface {
+	MakeDir(key string) error
+	RemoveDir(key string) error
+}
+
+// Appender e implementado pelos backends que acrescentam ao fim de um objeto
+// sem regrava-lo, como o disco local. O NDJSON do snapshot usa para gravar so
+// as linhas novas a cada checkpoint e, no Resume, para cortar o que foi
+// gravado depois do ultimo checkpoint.
+type Appender interface {
+	Append(ctx context.Context, key string, data []byte) error
+	Truncate(ctx context.Context, key string, size int64) error
+}
+
+// Open interpreta location e devolve o backend e a chave dentro dele:
+//
+//	gs://bucket/prefixo/backups  -> GCS, chave "prefixo/backups"
+//	s3://bucket/prefixo/backups  -> S3, chave "prefixo/backups"
+//	/var/backups/apps            -> disco local
+func Open(ctx context.Context, location string) (Backend, string, error) {
+	scheme, rest, found := strings.Cut(location, "://")
+	if !found {
+		return Local{}, filepath.ToSlash(filepath.Clean(location)), nil
+	}
+
+	bucket, key, _ := strings.Cut(rest, "/")
+	key = strings.Trim(key, "/")
+
+	switch scheme {
+	case "file":
+		return Local{}, filepath.ToSlash(filepath.Clean(rest)), nil
+	case "gs":
+		if bucket == "" {
+			return nil, "", fmt.Errorf("bucket vazio em %s", location)
+		}
+		backend, err := NewGCS(ctx, bucket)
+		return backend, key, err
+	case "s3":
+		if bucket == "" {
+			return nil, "", fmt.Errorf("bucket vazio em %s", location)
+		}
+		backend, err := NewS3FromEnv(bucket)
+		return backend, key, err
+	}
+
+	return nil, "", fmt.Errorf("storage desconhecido %q em %s (use gs://, s3:// ou um caminho local)", scheme, location)
+}
+
+// IsRemote informa se location aponta para um storage remoto.
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "gs://") || strings.HasPrefix(location, "s3://")
+}
+
+// Split separa location no ultimo "/", como filepath.Split, mas sem estragar
+// o "//" de gs:// e s3://.
+func Split(location string) (dir, name string) {
+	if !IsRemote(location) {
+		dir, name = filepath.Split(location)
+		return filepath.Clean(dir), name
+	}
+	i := strings.LastIndex(location, "/")
+	return location[:i], location[i+1:]
+}
+
+// Join monta uma chave a partir das partes, sempre com "/".
+func Join(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		p = strings.Trim(p, "/")
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	joined := strings.Join(out, "/")
+	if len(parts) > 0 && strings.HasPrefix(parts[0], "/") {
+		joined = "/" + joined
+	}
+	return joined
+}
+
+// bufferedWriter acumula o objeto em memoria e grava com Put no Close. Usado
+// pelos backends que precisam do tamanho do objeto antes do upload.
+type bufferedWriter struct {
+	buf strings.Builder
+	put func([]byte) error
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *bufferedWriter) Close() error {
+	return w.put([]byte(w.buf.String()))
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testBackend e o teste de conformidade que todo backend precisa passar.
+func testBackend(t *testing.T, b Backend, root string) {
+	t.Helper()
+	ctx := context.Background()
+
+	files := map[string]string{
+		Join(root, "snap_1", "a.yaml"):               "a",
+		Join(root, "snap_1", "dev@example.com.json"): "dev",
+		Join(root, "snap_2", "b.yaml"):               "b",
+	}
+	for key, content := range files {
+		if err := b.Put(ctx, key, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w, err := b.NewWriter(ctx, Join(root, "snap_3.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "stream")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := b.Get(ctx, Join(root, "snap_1", "dev@example.com.json"))
+	if err != nil || string(got) != "dev" {
+		t.Fatalf("Get = %q, %v", got, err)
+	}
+
+	r, err := b.NewReader(ctx, Join(root, "snap_3.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed, _ := io.ReadAll(r)
+	r.Close()
+	if string(streamed) != "stream" {
+		t.Fatalf("NewReader = %q", streamed)
+	}
+
+	keys, err := b.List(ctx, Join(root, "snap_1")+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{Join(root, "snap_1", "a.yaml"), Join(root, "snap_1", "dev@example.com.json")}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("List(snap_1/) = %v, esperado %v", keys, want)
+	}
+
+	keys, err = b.List(ctx, Join(root, "snap_"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 4 {
+		t.Fatalf("List(snap_) = %v", keys)
+	}
+
+	if err := b.Delete(ctx, Join(root, "snap_2", "b.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, Join(root, "snap_2", "b.yaml")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get de objeto apagado: %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	testBackend(t, Local{}, t.TempDir())
+}
+
+func TestLocalPutReplacesWholeFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := filepath.ToSlash(filepath.Join(dir, "checkpoint.json"))
+	if err := (Local{}).Put(ctx, key, []byte("primeiro")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put troca o arquivo inteiro (rename) em vez de truncar e regravar: quem
+	// abriu o arquivo antes continua lendo o conteudo anterior completo.
+	before, err := os.Open(filepath.FromSlash(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer before.Close()
+	if err := (Local{}).Put(ctx, key, []byte("segundo")); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := io.ReadAll(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.FromSlash(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != "primeiro" || string(got) != "segundo" {
+		t.Errorf("conteudo antes = %q, depois = %q", old, got)
+	}
+	info, err := os.Stat(filepath.FromSlash(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("permissao = %v", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("o temporario ficou no diretorio: %v", entries)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory(), "backups")
+}
+
+func TestOpen(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv(EmulatorEnv, "localhost:4443")
+
+	cases := map[string]struct {
+		backend string
+		key     string
+	}{
+		"/var/backups/apps":            {"storage.Local", "/var/backups/apps"},
+		"gs://bucket/prefix/apps":      {"*storage.GCS", "prefix/apps"},
+		"s3://bucket/prefix/apps/":     {"*storage.S3", "prefix/apps"},
+		"file:///var/backups/apps_123": {"storage.Local", "/var/backups/apps_123"},
+	}
+	for location, want := range cases {
+		b, key, err := Open(context.Background(), location)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", location, err)
+		}
+		if got := reflect.TypeOf(b).String(); got != want.backend || key != want.key {
+			t.Errorf("Open(%s) = %s %q, esperado %s %q", location, got, key, want.backend, want.key)
+		}
+	}
+
+	if _, _, err := Open(context.Background(), "ftp://bucket/x"); err == nil {
+		t.Error("esquema desconhecido deveria falhar")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := map[string][2]string{
+		"gs://bucket/apps_1/mobile.yaml": {"gs://bucket/apps_1", "mobile.yaml"},
+		"s3://bucket/mobile.yaml":        {"s3://bucket", "mobile.yaml"},
+		"backups/apps_1/mobile.yaml":     {"backups/apps_1", "mobile.yaml"},
+		"mobile.yaml":                    {".", "mobile.yaml"},
+	}
+	for location, want := range cases {
+		if dir, name := Split(location); dir != want[0] || name != want[1] {
+			t.Errorf("Split(%s) = %q %q, esperado %q", location, dir, name, want)
+		}
+	}
+}
+
+func TestLocalRemoveDir(t *testing.T) {
+	ctx := context.Background()
+	root := Join(t.TempDir(), "apps_1")
+
+	b := Local{}
+	b.Put(ctx, Join(root, "alice", "mobile.yaml"), []byte("a"))
+	if err := b.RemoveDir(root); err == nil {
+		t.Fatal("RemoveDir apagou um diretorio com arquivos")
+	}
+
+	b.Delete(ctx, Join(root, "alice", "mobile.yaml"))
+	if err := b.RemoveDir(root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("%s ainda existe: %v", root, err)
+	}
+}
@@ -0,0 +1,7 @@
+// Package version guarda a versao da ferramenta gravada nos backups.
+// Sobrescreva no build com:
+//
+//	go build -ldflags "-X backup-restore-apigee/internal/version.Version=1.2.0" ./...
+package version
+
+var Version = "dev"
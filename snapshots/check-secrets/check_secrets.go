@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] <serviceAccountFile> <organization> <snapshot>")
+	fmt.Println("\nDescription: Confere se os consumer secrets atuais da organizacao batem com as impressoes digitais")
+	fmt.Println("de um backup feito com --redact-secrets, sem que o secret precise estar no backup.")
+	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
+	fmt.Println("- Options: <organization> - Organizacao Apigee")
+	fmt.Println("- Options: <snapshot> - Diretorio ou arquivo .tar.gz/.tar.zst do backup de apps")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
+	fmt.Println("\nEx: go run main.go service-account.json my-org apps_20240201T100000Z-3fa2c1")
+}
+
+func main() {
+	endpoint := apigeeclient.EndpointFlag()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 {
+		help()
+		os.Exit(2)
+	}
+
+	org := flag.Arg(1)
+	ctx := context.Background()
+
+	client, err := apigeeclient.New(ctx, flag.Arg(0), *endpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
+	}
+
+	snap, err := snapshot.Open(ctx, flag.Arg(2))
+	if err != nil {
+		log.Fatalf("Erro ao abrir o snapshot: %v", err)
+	}
+
+	files, err := snap.FilesOfKind(snapshot.KindApp)
+	if err != nil {
+		log.Fatalf("Erro ao listar os apps do snapshot: %v", err)
+	}
+
+	var checked, mismatches int
+	for _, name := range files {
+		appBackup, err := snap.ReadApp(name)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+
+		live, err := client.Service.Organizations.Developers.Apps.Get("organizations/" + org + "/developers/" + appBackup.DeveloperID + "/apps/" + appBackup.Name).Context(ctx).Do()
+		if err != nil {
+			fmt.Printf(" - %s/%s: erro ao obter o app: %v\n", appBackup.DeveloperID, appBackup.Name, err)
+			mismatches++
+			continue
+		}
+
+		liveSecrets := map[string]string{}
+		for _, cred := range live.Credentials {
+			liveSecrets[cred.ConsumerKey] = cred.ConsumerSecret
+		}
+
+		for _, cred := range appBackup.Credentials {
+			if !secrets.IsRedacted(cred.ConsumerSecret) {
+				continue
+			}
+			checked++
+
+			secret, ok := liveSecrets[cred.ConsumerKey]
+			if !ok {
+				fmt.Printf(" - %s/%s: chave %s nao existe mais\n", appBackup.DeveloperID, appBackup.Name, cred.ConsumerKey)
+				mismatches++
+				continue
+			}
+
+			match, err := secrets.MatchFingerprint(cred.ConsumerSecret, secret)
+			if err != nil || !match {
+				fmt.Printf(" - %s/%s: secret da chave %s mudou\n", appBackup.DeveloperID, appBackup.Name, cred.ConsumerKey)
+				mismatches++
+			}
+		}
+	}
+
+	fmt.Printf("Secrets conferidos: %d, divergentes: %d\n", checked, mismatches)
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go --format yaml|json|ndjson [--archive tar.gz|tar.zst] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] <snapshot> <backupDir>")
+	fmt.Println("\nDescription: Grava um snapshot existente de novo em outro formato de documento, como um snapshot novo")
+	fmt.Println("(<backupDir>_<ID>). O snapshot original nao e alterado. Um snapshot incremental sai completo, como no materialize.")
+	fmt.Println("\n- Options: <snapshot> - Snapshot a converter, em qualquer formato")
+	fmt.Println("- Options: <backupDir> - Diretorio, gs://bucket/prefixo ou s3://bucket/prefixo do novo snapshot (<backupDir>_<ID>)")
+	fmt.Println("- Options: --format - Formato dos documentos: yaml, json ou ndjson (um arquivo " + snapshot.StreamPath(snapshot.KindApp) + " e um " + snapshot.StreamPath(snapshot.KindDeveloper) + ", um documento por linha)")
+	fmt.Println("- Options: --archive - Grava o snapshot em um unico arquivo " + snapshot.FormatTarGz + " ou " + snapshot.FormatTarZstd)
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave ou senha dos consumer secrets, obrigatoria se o snapshot os tem cifrados; o novo snapshot e cifrado com ela")
+	fmt.Println("\nEx: go run main.go --format ndjson apps_20240208T100000Z-9c01aa apps-ndjson")
+}
+
+func main() {
+	format := flag.String("format", "", "Formato dos documentos: yaml, json ou ndjson")
+	archive := flag.String("archive", "", "Grava o snapshot em um arquivo tar.gz ou tar.zst")
+	secretsKMS := secrets.Flags()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 2 || *format == "" {
+		help()
+		os.Exit(2)
+	}
+	if err := snapshot.CheckFormat(*format); err != nil {
+		log.Fatal(err)
+	}
+
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	r, err := snapshot.Open(ctx, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Erro ao abrir o snapshot: %v", err)
+	}
+	manifest, err := r.Manifest()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if manifest.Encryption != nil && kms == nil {
+		log.Fatal("O snapshot tem os consumer secrets cifrados: informe --secrets-key-file ou --secrets-passphrase-env")
+	}
+	r.SetKMS(kms)
+	if manifest.Encryption == nil {
+		kms = nil
+	}
+
+	w, err := snapshot.Create(ctx, flag.Arg(1), manifest.Organization, snapshot.Options{Archive: *archive, KMS: kms, RedactSecrets: manifest.Redacted, Filter: manifest.Filter, Format: *format})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := snapshot.Materialize(r, w)
+	if err != nil {
+		w.RecordError(err)
+	}
+	if _, closeErr := w.Close(); closeErr != nil {
+		log.Fatalf("Erro ao gravar o manifesto: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatalf("Erro ao converter %s: %v", r.Path(), err)
+	}
+
+	fmt.Printf("Snapshot '%s' gravado no formato %s com %d documento(s).\n", w.Path(), *format, count)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--format text|json|markdown] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] <snapA> <snapB>")
+	fmt.Println("\nDescription: Mostra o que mudou de <snapA> para <snapB>: developers e apps adicionados, removidos e alterados,")
+	fmt.Println("com as mudancas campo a campo em atributos, credenciais, associacoes com API products e status.")
+	fmt.Println("Sai com codigo 1 quando ha diferencas.")
+	fmt.Println("\n- Options: <snapA> <snapB> - Snapshots (diretorio ou arquivo .tar.gz/.tar.zst, local ou em gs:// / s3://)")
+	fmt.Println("- Options: --format - Formato da saida: text (padrao), json ou markdown")
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave para decifrar os consumer secrets; sem ela os secrets cifrados nao sao comparados")
+	fmt.Println("\nEx: go run main.go --format markdown apps_20240201T020000Z-3fa2c1 apps_20240202T020000Z-81b0e4")
+}
+
+func main() {
+	format := flag.String("format", diff.FormatText, "Formato da saida: text, json ou markdown")
+	secretsKMS := secrets.Flags()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		help()
+		os.Exit(2)
+	}
+
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	var states [2]*diff.State
+	for i, path := range flag.Args()[:2] {
+		snap, err := snapshot.Open(ctx, path)
+		if err != nil {
+			log.Fatalf("Erro ao abrir o snapshot %s: %v", path, err)
+		}
+		snap.SetKMS(kms)
+
+		states[i], err = diff.Load(snap)
+		if err != nil {
+			log.Fatalf("Erro ao ler o snapshot %s: %v", path, err)
+		}
+	}
+
+	report := diff.Compare(states[0], states[1])
+	report.From, report.To = flag.Arg(0), flag.Arg(1)
+
+	if err := diff.Write(os.Stdout, report, *format); err != nil {
+		log.Fatal(err)
+	}
+
+	if !report.Empty() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/apigeeclient"
+	"backup-restore-apigee/internal/backup"
+	"backup-restore-apigee/internal/diff"
+	"backup-restore-apigee/internal/drift"
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--api-endpoint <url>] [--format text|json|markdown] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] <serviceAccountFile> <organization> <snapshot> [<snapshot>...]")
+	fmt.Println("\nDescription: Compara snapshots com o estado atual da organizacao, lido pelo mesmo codigo do backup.")
+	fmt.Println("Aponta developers, apps, chaves e associacoes com API products que faltam ou sobram na organizacao,")
+	fmt.Println("alem das mudancas campo a campo. Sai com codigo 1 quando ha drift.")
+	fmt.Println("\n- Options: <serviceAccountFile> - Arquivo json do service account")
+	fmt.Println("- Options: <organization> - Organizacao Apigee")
+	fmt.Println("- Options: <snapshot> - Snapshots de referencia (ex: o de developers e o de apps); so os tipos de recurso presentes neles sao comparados")
+	fmt.Println("- Options: --format - Formato da saida: text (padrao), json ou markdown")
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave para decifrar os consumer secrets; sem ela os secrets cifrados nao sao comparados")
+	fmt.Println("- Options: --api-endpoint - Endpoint da API de gerenciamento (padrao " + apigeeclient.DefaultEndpoint + ")")
+	fmt.Println("\nEx: go run main.go service-account.json my-org golden/developers golden/apps")
+}
+
+func main() {
+	endpoint := apigeeclient.EndpointFlag()
+	format := flag.String("format", diff.FormatText, "Formato da saida: text, json ou markdown")
+	secretsKMS := secrets.Flags()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 3 {
+		help()
+		os.Exit(2)
+	}
+
+	org := flag.Arg(1)
+	ctx := context.Background()
+
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var snaps []*snapshot.Reader
+	for _, path := range flag.Args()[2:] {
+		snap, err := snapshot.Open(ctx, path)
+		if err != nil {
+			log.Fatalf("Erro ao abrir o snapshot %s: %v", path, err)
+		}
+		snap.SetKMS(kms)
+		snaps = append(snaps, snap)
+	}
+
+	client, err := apigeeclient.New(ctx, flag.Arg(0), *endpoint)
+	if err != nil {
+		log.Fatalf("Erro ao criar o cliente do Apigee: %v", err)
+	}
+
+	// O progresso da leitura vai para o stderr; o stdout fica so com o relatorio.
+	backup.Output = os.Stderr
+
+	report, err := drift.Check(ctx, client, org, snaps)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := diff.Write(os.Stdout, report, *format); err != nil {
+		log.Fatal(err)
+	}
+
+	if !report.Empty() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--org <org>] [--base <backupDir>] [--kind apps|developers] [--status <status>] [--as-of <time>] [--json] [--rebuild] <location>")
+	fmt.Println("\nDescription: Lista os snapshots do catalogo, do mais novo para o mais antigo.")
+	fmt.Println("\n- Options: <location> - Diretorio, gs://bucket/prefixo ou s3://bucket/prefixo onde estao os snapshots")
+	fmt.Println("- Options: --org - So snapshots desta organizacao")
+	fmt.Println("- Options: --base - So snapshots deste <backupDir>, ex: apps")
+	fmt.Println("- Options: --kind - So snapshots com este tipo de recurso")
+	fmt.Println("- Options: --status - So snapshots com este status: " + strings.Join([]string{snapshot.StatusComplete, snapshot.StatusPartial, snapshot.StatusRunning, snapshot.StatusIncomplete}, ", "))
+	fmt.Println("- Options: --as-of - So snapshots iniciados ate o horario (ex: 2024-02-01T10:00:00Z)")
+	fmt.Println("- Options: --json - Saida em JSON")
+	fmt.Println("- Options: --rebuild - Recria o catalogo a partir dos manifestos antes de listar (snapshots antigos)")
+	fmt.Println("\nEx: go run main.go --org my-org --status complete gs://meu-bucket/apigee")
+}
+
+func main() {
+	org := flag.String("org", "", "So snapshots desta organizacao")
+	base := flag.String("base", "", "So snapshots deste <backupDir>")
+	kind := flag.String("kind", "", "So snapshots com este tipo de recurso")
+	status := flag.String("status", "", "So snapshots com este status")
+	asOf := flag.String("as-of", "", "So snapshots iniciados ate este horario")
+	asJSON := flag.Bool("json", false, "Saida em JSON")
+	rebuild := flag.Bool("rebuild", false, "Recria o catalogo a partir dos manifestos")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		help()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	location := flag.Arg(0)
+
+	var until time.Time
+	if *asOf != "" {
+		var err error
+		until, err = snapshot.ParseAsOf(*asOf)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *rebuild {
+		n, err := snapshot.RebuildCatalog(ctx, location)
+		if err != nil {
+			log.Fatalf("Erro ao recriar o catalogo de %s: %v", location, err)
+		}
+		log.Printf("Catalogo recriado com %d snapshot(s).", n)
+	}
+
+	entries, err := snapshot.Catalog(ctx, location)
+	if err != nil {
+		log.Fatalf("Erro ao ler o catalogo de %s: %v", location, err)
+	}
+
+	var selected []snapshot.Entry
+	for _, entry := range entries {
+		if *org != "" && entry.Organization != *org ||
+			*base != "" && entry.Base != *base ||
+			*status != "" && entry.Status != *status ||
+			*asOf != "" && entry.StartedAt.After(until) {
+			continue
+		}
+		if _, ok := entry.Counts[*kind]; *kind != "" && !ok {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+
+	if *asJSON {
+		out := make([]map[string]interface{}, 0, len(selected))
+		for _, entry := range selected {
+			// Entry.Path nao vai no catalogo gravado, mas e util na saida.
+			data, _ := json.Marshal(entry)
+			var doc map[string]interface{}
+			json.Unmarshal(data, &doc)
+			doc["path"] = entry.Path
+			out = append(out, doc)
+		}
+		data, _ := json.MarshalIndent(out, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tBASE\tORG\tSTATUS\tINICIO (UTC)\tRECURSOS\tCAMINHO")
+	for _, entry := range selected {
+		var counts []string
+		for _, k := range entry.Kinds() {
+			counts = append(counts, fmt.Sprintf("%d %s", entry.Counts[k], k))
+		}
+		status := entry.Status
+		if entry.Incremental != "" {
+			status += " (incremental)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.Base, entry.Organization, status,
+			entry.StartedAt.UTC().Format(time.RFC3339), strings.Join(counts, ", "), entry.Path)
+	}
+	tw.Flush()
+	fmt.Printf("Total de snapshots: %d\n", len(selected))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/secrets"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--archive tar.gz|tar.zst] [--secrets-key-file <file> | --secrets-passphrase-env <VAR>] <snapshot> <backupDir>")
+	fmt.Println("\nDescription: Grava a visao completa de um snapshot incremental (a base mais os incrementais) como um")
+	fmt.Println("snapshot completo, que nao depende dos anteriores e pode servir de base para novos incrementais.")
+	fmt.Println("\n- Options: <snapshot> - Snapshot incremental (ou completo) a materializar")
+	fmt.Println("- Options: <backupDir> - Diretorio, gs://bucket/prefixo ou s3://bucket/prefixo do novo snapshot (<backupDir>_<ID>)")
+	fmt.Println("- Options: --archive - Grava o snapshot em um unico arquivo " + snapshot.FormatTarGz + " ou " + snapshot.FormatTarZstd)
+	fmt.Println("- Options: --secrets-key-file / --secrets-passphrase-env - Chave ou senha dos consumer secrets, obrigatoria se o snapshot os tem cifrados; o novo snapshot e cifrado com ela")
+	fmt.Println("\nEx: go run main.go apps_20240208T100000Z-9c01aa apps-full")
+}
+
+func main() {
+	archive := flag.String("archive", "", "Grava o snapshot em um arquivo tar.gz ou tar.zst")
+	secretsKMS := secrets.Flags()
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		help()
+		os.Exit(2)
+	}
+
+	kms, err := secretsKMS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	r, err := snapshot.Open(ctx, flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Erro ao abrir o snapshot: %v", err)
+	}
+	manifest, err := r.Manifest()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if manifest.Encryption != nil && kms == nil {
+		log.Fatal("O snapshot tem os consumer secrets cifrados: informe --secrets-key-file ou --secrets-passphrase-env")
+	}
+	r.SetKMS(kms)
+	if manifest.Encryption == nil {
+		kms = nil
+	}
+
+	w, err := snapshot.Create(ctx, flag.Arg(1), manifest.Organization, snapshot.Options{Archive: *archive, KMS: kms, RedactSecrets: manifest.Redacted, Filter: manifest.Filter, Format: manifest.Format})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := snapshot.Materialize(r, w)
+	if err != nil {
+		w.RecordError(err)
+	}
+	if _, closeErr := w.Close(); closeErr != nil {
+		log.Fatalf("Erro ao gravar o manifesto: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatalf("Erro ao materializar %s: %v", r.Path(), err)
+	}
+
+	fmt.Printf("Snapshot '%s' gravado com %d documento(s).\n", w.Path(), count)
+}
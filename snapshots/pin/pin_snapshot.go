@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--unpin] <snapshot>")
+	fmt.Println("\nDescription: Fixa um snapshot para que o prune nunca o apague, gravando o marcador <snapshot>" + snapshot.PinSuffix + " ao lado dele.")
+	fmt.Println("\n- Options: <snapshot> - Diretorio ou arquivo .tar.gz/.tar.zst do snapshot, local ou em gs:// / s3://")
+	fmt.Println("- Options: --unpin - Remove o marcador e libera o snapshot para o prune")
+	fmt.Println("\nEx: go run main.go apps_20240201T100000Z-3fa2c1")
+}
+
+func main() {
+	unpin := flag.Bool("unpin", false, "Libera o snapshot para o prune")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		help()
+		os.Exit(2)
+	}
+
+	path := flag.Arg(0)
+	if err := snapshot.Pin(context.Background(), path, !*unpin); err != nil {
+		log.Fatalf("Erro ao alterar o snapshot %s: %v", path, err)
+	}
+
+	if *unpin {
+		fmt.Printf("Snapshot %s liberado para o prune.\n", path)
+		return
+	}
+	fmt.Printf("Snapshot %s fixado.\n", path)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"backup-restore-apigee/internal/retention"
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--daily N] [--weekly N] [--monthly N] [--dry-run] <backupDir>")
+	fmt.Println("\nDescription: Apaga os snapshots antigos de um backup seguindo a politica avo-pai-filho:")
+	fmt.Println("mantem o snapshot mais recente de cada um dos ultimos N dias, semanas e meses que tem backup.")
+	fmt.Println("Snapshots fixados com o comando pin nunca sao apagados, nem a base de um incremental que fica.")
+	fmt.Println("Depois, apaga de .objects os documentos que nenhum snapshot deduplicado (--dedup) restante usa.")
+	fmt.Println("\n- Options: <backupDir> - O mesmo <backupDir> usado no backup (diretorio local, gs://bucket/prefixo ou s3://bucket/prefixo)")
+	fmt.Println("- Options: --daily - Quantos dias manter")
+	fmt.Println("- Options: --weekly - Quantas semanas manter")
+	fmt.Println("- Options: --monthly - Quantos meses manter")
+	fmt.Println("- Options: --dry-run - So mostra o que seria apagado")
+	fmt.Println("\nEx: go run main.go --daily 7 --weekly 4 --monthly 12 gs://meu-bucket/apigee/apps")
+}
+
+func main() {
+	var policy retention.Policy
+	flag.IntVar(&policy.Daily, "daily", 0, "Quantos dias manter")
+	flag.IntVar(&policy.Weekly, "weekly", 0, "Quantas semanas manter")
+	flag.IntVar(&policy.Monthly, "monthly", 0, "Quantos meses manter")
+	dryRun := flag.Bool("dry-run", false, "So mostra o que seria apagado")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		help()
+		os.Exit(2)
+	}
+
+	if err := policy.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	backupDir := flag.Arg(0)
+
+	snaps, err := snapshot.List(ctx, backupDir)
+	if err != nil {
+		log.Fatalf("Erro ao listar os snapshots de %s: %v", backupDir, err)
+	}
+
+	removed := 0
+	failed := 0
+	for _, decision := range snapshot.PlanPrune(snaps, policy) {
+		if decision.Keep {
+			fmt.Printf("manter  %s (%s)\n", decision.Snapshot.Path, strings.Join(decision.Reasons, ", "))
+			continue
+		}
+
+		fmt.Printf("apagar  %s\n", decision.Snapshot.Path)
+		if *dryRun {
+			removed++
+			continue
+		}
+		if err := snapshot.Delete(ctx, decision.Snapshot); err != nil {
+			log.Print(err)
+			failed++
+			continue
+		}
+		removed++
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry-run: %d de %d snapshot(s) seriam apagados.\n", removed, len(snaps))
+		return
+	}
+
+	fmt.Printf("Total de snapshots apagados: %d de %d\n", removed, len(snaps))
+	if failed > 0 {
+		log.Fatalf("%d snapshot(s) nao foram apagados", failed)
+	}
+
+	// Os documentos dos snapshots deduplicados (--dedup) ficam em .objects e
+	// so saem quando nenhum snapshot restante os usa.
+	objects, err := snapshot.CollectGarbage(ctx, backupDir)
+	if err != nil {
+		log.Fatalf("Erro ao apagar os objetos sem uso: %v", err)
+	}
+	if objects > 0 {
+		fmt.Printf("Objetos deduplicados sem uso apagados: %d\n", objects)
+	}
+}
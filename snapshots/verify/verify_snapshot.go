@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"backup-restore-apigee/internal/snapshot"
+)
+
+func help() {
+	fmt.Println("Usage: go run main.go [--developers <developersDir>] <snapshotDir>")
+	fmt.Println("\nDescription: Este programa verifica a integridade de um snapshot de backup.")
+	fmt.Println("Confere os checksums do manifest.json, faz o parse estrito de cada YAML de app e JSON de developer,")
+	fmt.Println("valida os campos obrigatorios e se o developerId de cada app tem arquivo de developer.")
+	fmt.Println("\n- Options: <snapshotDir> - Diretorio ou arquivo .tar.gz/.tar.zst do snapshot gerado pelo backup, local ou em gs:// / s3://")
+	fmt.Println("- Options: --developers - Snapshot de developers usado para validar os apps quando <snapshotDir> so contem apps")
+	fmt.Println("\nEx: go run main.go --developers developers_20240201T100000Z-9b41d0 apps_20240201T100000Z-3fa2c1")
+}
+
+func main() {
+	developersDir := flag.String("developers", "", "Snapshot de developers para validar as referencias dos apps")
+	flag.Usage = help
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		help()
+		os.Exit(2)
+	}
+
+	snapshotDir := flag.Arg(0)
+
+	var opts snapshot.VerifyOptions
+	if *developersDir != "" {
+		opts.DeveloperDirs = append(opts.DeveloperDirs, *developersDir)
+	}
+
+	problems, err := snapshot.Verify(context.Background(), snapshotDir, opts)
+	if err != nil {
+		log.Fatalf("Erro ao verificar o snapshot %s: %v", snapshotDir, err)
+	}
+
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Printf(" - %s\n", problem)
+		}
+		fmt.Printf("Snapshot %s com %d problema(s)\n", snapshotDir, len(problems))
+		os.Exit(1)
+	}
+
+	fmt.Printf("Snapshot %s verificado com sucesso.\n", snapshotDir)
+}